@@ -0,0 +1,284 @@
+// Package carstore layers read-only CAR files under a blockstore.
+//
+// Blocks that aren't in the blockstore are looked up in the mounted CAR
+// files, so that their content can be served without being copied into the
+// repo. Writes and deletes only ever go to the blockstore, and only the
+// blocks of the blockstore are enumerated: mounted blocks are never garbage
+// collected, and don't count towards the size of the repo.
+//
+// Has only reports the blocks of the blockstore, as the layers above skip
+// the writes of the blocks it has: blocks that are added, fetched or
+// received are written to the repo even if a mounted file has them, so that
+// they outlive the mount. HasMounted reports the mounted ones.
+//
+// The list of mounted files is kept by the caller: the store reads it when
+// it is created, and hands it back to be saved whenever it changes.
+package carstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipfs/go-ipfs/carv2"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("carstore")
+
+// Mount is a CAR file in the mount list.
+type Mount struct {
+	Path string
+	// Blocks is the number of blocks in the file.
+	Blocks int
+	// Err is why the file couldn't be opened, if it couldn't.
+	Err error
+}
+
+type mount struct {
+	path string
+	car  *carv2.ReadOnly // nil if it couldn't be opened
+	err  error
+}
+
+// Store is a blockstore with CAR files mounted under it.
+type Store struct {
+	blockstore.Blockstore
+
+	save func(paths []string) error
+
+	mu         sync.RWMutex
+	mounts     []*mount
+	hashOnRead bool
+}
+
+// New mounts the CAR files at paths under bs. save is called with the new
+// list of mounted files when Mount or Unmount changes it, and the change is
+// undone if it fails. Files that can't be opened stay in the list, but are
+// skipped until the next start, so that a missing drive doesn't keep the
+// node from starting.
+func New(bs blockstore.Blockstore, paths []string, save func(paths []string) error) (*Store, error) {
+	s := &Store{Blockstore: bs, save: save}
+
+	for _, p := range paths {
+		if !filepath.IsAbs(p) {
+			return nil, fmt.Errorf("CAR path %q is not absolute", p)
+		}
+		m := &mount{path: filepath.Clean(p)}
+		m.car, m.err = carv2.OpenReadOnly(m.path)
+		if m.err != nil {
+			log.Errorf("could not mount CAR file: %s", m.err)
+		}
+		s.mounts = append(s.mounts, m)
+	}
+	return s, nil
+}
+
+// saveList must be called with mu held.
+func (s *Store) saveList() error {
+	paths := make([]string, 0, len(s.mounts))
+	for _, m := range s.mounts {
+		paths = append(paths, m.path)
+	}
+	return s.save(paths)
+}
+
+// Mount opens the CAR file at path and adds it to the mount list. path must
+// be absolute.
+func (s *Store) Mount(path string) (Mount, error) {
+	if !filepath.IsAbs(path) {
+		return Mount{}, fmt.Errorf("CAR path %q is not absolute", path)
+	}
+	path = filepath.Clean(path)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.mounts {
+		if m.path == path {
+			return Mount{}, fmt.Errorf("%s is already mounted", path)
+		}
+	}
+
+	car, err := carv2.OpenReadOnly(path)
+	if err != nil {
+		return Mount{}, err
+	}
+	car.HashOnRead(s.hashOnRead)
+
+	s.mounts = append(s.mounts, &mount{path: path, car: car})
+	if err := s.saveList(); err != nil {
+		s.mounts = s.mounts[:len(s.mounts)-1]
+		car.Close()
+		return Mount{}, err
+	}
+	return Mount{Path: path, Blocks: car.Len()}, nil
+}
+
+// Unmount removes the CAR file at path from the mount list.
+func (s *Store) Unmount(path string) error {
+	path = filepath.Clean(path)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, m := range s.mounts {
+		if m.path != path {
+			continue
+		}
+		old := s.mounts
+		s.mounts = append(s.mounts[:i:i], s.mounts[i+1:]...)
+		if err := s.saveList(); err != nil {
+			s.mounts = old
+			return err
+		}
+		if m.car != nil {
+			return m.car.Close()
+		}
+		return nil
+	}
+	return fmt.Errorf("%s is not mounted", path)
+}
+
+// Mounts returns the mount list.
+func (s *Store) Mounts() []Mount {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]Mount, 0, len(s.mounts))
+	for _, m := range s.mounts {
+		info := Mount{Path: m.path, Err: m.err}
+		if m.car != nil {
+			info.Blocks = m.car.Len()
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+// Close closes the mounted files.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for _, m := range s.mounts {
+		if m.car == nil {
+			continue
+		}
+		if cerr := m.car.Close(); cerr != nil {
+			err = cerr
+		}
+		m.car, m.err = nil, fmt.Errorf("closed")
+	}
+	return err
+}
+
+// HasMounted tells whether c is in a mounted file.
+func (s *Store) HasMounted(c cid.Cid) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.inMounts(c, ""), nil
+}
+
+// OnlyIn tells whether c is in the mounted file at path, but neither in the
+// blockstore nor in another mounted file, so that it would be gone once the
+// file is unmounted.
+func (s *Store) OnlyIn(path string, c cid.Cid) (bool, error) {
+	path = filepath.Clean(path)
+	if has, err := s.Blockstore.Has(c); err != nil || has {
+		return false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, m := range s.mounts {
+		if m.path != path || m.car == nil {
+			continue
+		}
+		has, err := m.car.Has(c)
+		if err != nil || !has {
+			return false, err
+		}
+		return !s.inMounts(c, path), nil
+	}
+	return false, nil
+}
+
+// inMounts tells whether c is in a mounted file, other than the one at skip
+// if it isn't empty. It must be called with mu held.
+func (s *Store) inMounts(c cid.Cid, skip string) bool {
+	for _, m := range s.mounts {
+		if m.car == nil || (skip != "" && m.path == skip) {
+			continue
+		}
+		if has, err := m.car.Has(c); err != nil {
+			log.Warnf("%s: %s", m.path, err)
+		} else if has {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns c from the blockstore, or from a mounted file.
+func (s *Store) Get(c cid.Cid) (blocks.Block, error) {
+	b, err := s.Blockstore.Get(c)
+	if err != blockstore.ErrNotFound {
+		return b, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, m := range s.mounts {
+		if m.car == nil {
+			continue
+		}
+		b, err := m.car.Get(c)
+		if err == nil {
+			return b, nil
+		} else if err != blockstore.ErrNotFound {
+			log.Warnf("%s: %s", m.path, err)
+		}
+	}
+	return nil, blockstore.ErrNotFound
+}
+
+// GetSize returns the size of c, from the blockstore or a mounted file.
+func (s *Store) GetSize(c cid.Cid) (int, error) {
+	size, err := s.Blockstore.GetSize(c)
+	if err != blockstore.ErrNotFound {
+		return size, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, m := range s.mounts {
+		if m.car == nil {
+			continue
+		}
+		size, err := m.car.GetSize(c)
+		if err == nil {
+			return size, nil
+		} else if err != blockstore.ErrNotFound {
+			log.Warnf("%s: %s", m.path, err)
+		}
+	}
+	return -1, blockstore.ErrNotFound
+}
+
+// HashOnRead sets whether blocks are checked against their CID when read,
+// in the blockstore and in the mounted files.
+func (s *Store) HashOnRead(enabled bool) {
+	s.Blockstore.HashOnRead(enabled)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hashOnRead = enabled
+	for _, m := range s.mounts {
+		if m.car != nil {
+			m.car.HashOnRead(enabled)
+		}
+	}
+}
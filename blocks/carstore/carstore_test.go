@@ -0,0 +1,213 @@
+package carstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	mdag "github.com/ipfs/go-merkledag"
+	mdtest "github.com/ipfs/go-merkledag/test"
+	gocar "github.com/ipld/go-car"
+)
+
+// writeCar writes a CAR file of a DAG of a few blocks in dir, and returns
+// its path and the CIDs of the blocks.
+func writeCar(t *testing.T, dir string) (string, []cid.Cid) {
+	ctx := context.Background()
+	ds := mdtest.Mock()
+
+	root := new(mdag.ProtoNode)
+	var cids []cid.Cid
+	for _, data := range []string{"foo", "bar"} {
+		nd := mdag.NodeWithData([]byte(data))
+		if err := ds.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		if err := root.AddNodeLink(data, nd); err != nil {
+			t.Fatal(err)
+		}
+		cids = append(cids, nd.Cid())
+	}
+	if err := ds.Add(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	cids = append(cids, root.Cid())
+
+	var buf bytes.Buffer
+	if err := gocar.WriteCar(ctx, ds, []cid.Cid{root.Cid()}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "test.car")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, cids
+}
+
+func newBlockstore() blockstore.Blockstore {
+	return blockstore.NewBlockstore(syncds.MutexWrap(datastore.NewMapDatastore()))
+}
+
+// mountList stands for the config the list of mounted files is saved in.
+type mountList struct {
+	paths []string
+	err   error
+}
+
+func (l *mountList) save(paths []string) error {
+	if l.err != nil {
+		return l.err
+	}
+	l.paths = paths
+	return nil
+}
+
+func TestMount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	carPath, cids := writeCar(t, dir)
+	list := &mountList{}
+
+	s, err := New(newBlockstore(), nil, list.save)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if has, _ := s.HasMounted(cids[0]); has {
+		t.Fatal("found a block before mounting")
+	}
+	if _, err := s.Mount("test.car"); err == nil {
+		t.Fatal("mounted a relative path")
+	}
+	m, err := s.Mount(carPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Blocks != len(cids) {
+		t.Fatalf("mounted %d blocks, expected %d", m.Blocks, len(cids))
+	}
+	if _, err := s.Mount(carPath); err == nil {
+		t.Fatal("mounted the same file twice")
+	}
+	if !reflect.DeepEqual(list.paths, []string{carPath}) {
+		t.Fatalf("saved mount list %v, expected %v", list.paths, []string{carPath})
+	}
+
+	for _, c := range cids {
+		if has, err := s.HasMounted(c); err != nil || !has {
+			t.Fatalf("%s not found: %v", c, err)
+		}
+		// the layers above write the blocks the blockstore doesn't have
+		if has, err := s.Has(c); err != nil || has {
+			t.Fatalf("mounted block %s is reported as part of the repo: %v", c, err)
+		}
+		if only, err := s.OnlyIn(carPath, c); err != nil || !only {
+			t.Fatalf("%s is not only in the mounted file: %v", c, err)
+		}
+		b, err := s.Get(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size, err := s.GetSize(c); err != nil || size != len(b.RawData()) {
+			t.Fatalf("got size %d (%v), expected %d", size, err, len(b.RawData()))
+		}
+	}
+
+	// blocks written to the repo outlive the mount
+	b, err := s.Get(cids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(b); err != nil {
+		t.Fatal(err)
+	}
+	if only, err := s.OnlyIn(carPath, cids[0]); err != nil || only {
+		t.Fatalf("%s is only in the mounted file after it was written: %v", cids[0], err)
+	}
+	if err := s.DeleteBlock(cids[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// mounted blocks aren't part of the repo
+	keys, err := s.AllKeysChan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range keys {
+		t.Fatalf("mounted block %s is enumerated", k)
+	}
+
+	// the mount list survives a restart
+	s2, err := New(newBlockstore(), list.paths, list.save)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+	if mounts := s2.Mounts(); len(mounts) != 1 || mounts[0].Path != carPath || mounts[0].Err != nil {
+		t.Fatalf("unexpected mounts after reopening: %+v", mounts)
+	}
+
+	if err := s.Unmount(carPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unmount(carPath); err == nil {
+		t.Fatal("unmounted a file that isn't mounted")
+	}
+	if _, err := s.Get(cids[0]); err != blockstore.ErrNotFound {
+		t.Fatalf("expected ErrNotFound after unmounting, got %v", err)
+	}
+	if len(s.Mounts()) != 0 || len(list.paths) != 0 {
+		t.Fatalf("unexpected mounts: %+v (saved: %v)", s.Mounts(), list.paths)
+	}
+
+	// a mount list that can't be saved is left as it was
+	list.err = errors.New("read-only config")
+	if _, err := s.Mount(carPath); err == nil {
+		t.Fatal("mounted a file without saving the mount list")
+	}
+	if len(s.Mounts()) != 0 {
+		t.Fatalf("unexpected mounts: %+v", s.Mounts())
+	}
+}
+
+func TestMissingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	missing := filepath.Join(dir, "missing.car")
+	list := &mountList{}
+
+	// a file that's gone doesn't keep the store from opening
+	s, err := New(newBlockstore(), []string{missing}, list.save)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	mounts := s.Mounts()
+	if len(mounts) != 1 || mounts[0].Path != missing || mounts[0].Err == nil {
+		t.Fatalf("unexpected mounts: %+v", mounts)
+	}
+	if _, err := s.Get(mdag.NodeWithData([]byte("foo")).Cid()); err != blockstore.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := New(newBlockstore(), []string{"relative.car"}, list.save); err == nil {
+		t.Fatal("expected an error for a relative path in the list")
+	}
+}
@@ -0,0 +1,233 @@
+// Package carv2 reads and writes CARv2 files.
+//
+// A CARv2 file wraps a CARv1 payload between a fixed size header and an
+// optional index of the blocks in the payload:
+//
+//	pragma (11 bytes) | header (40 bytes) | CARv1 payload | index
+//
+// The header tells where the payload and the index are, so the blocks of a
+// CARv2 file can be accessed without scanning the payload. Indexes can also
+// be stored on their own, next to a CARv1 file.
+package carv2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// Pragma is the beginning of every CARv2 file: a CARv1 header that only
+// contains {"version": 2}, so that CARv1 readers reject the file.
+var Pragma = []byte{0x0a, 0xa1, 0x67, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x02}
+
+const (
+	// PragmaSize is the size of Pragma.
+	PragmaSize = 11
+	// HeaderSize is the size of the header following the pragma.
+	HeaderSize = 40
+)
+
+// maxSectionSize bounds the size of the sections read from a CARv1 payload:
+// blocks are much smaller than this.
+const maxSectionSize = 32 << 20
+
+// ErrNotCarV2 is returned when reading a CARv2 header from something else.
+var ErrNotCarV2 = errors.New("not a CARv2 file")
+
+// Header is the fixed size header of a CARv2 file. Offsets are from the
+// beginning of the file.
+type Header struct {
+	Characteristics [16]byte
+	DataOffset      uint64
+	DataSize        uint64
+	// IndexOffset is 0 when the file has no index.
+	IndexOffset uint64
+}
+
+// NewHeader returns the header of a CARv2 file with a payload of dataSize
+// bytes right after the header, and an index right after the payload.
+func NewHeader(dataSize uint64) Header {
+	return Header{
+		DataOffset:  PragmaSize + HeaderSize,
+		DataSize:    dataSize,
+		IndexOffset: PragmaSize + HeaderSize + dataSize,
+	}
+}
+
+// WriteTo writes the pragma followed by the header.
+func (h Header) WriteTo(w io.Writer) (int64, error) {
+	buf := make([]byte, PragmaSize+HeaderSize)
+	copy(buf, Pragma)
+	copy(buf[PragmaSize:], h.Characteristics[:])
+	binary.LittleEndian.PutUint64(buf[PragmaSize+16:], h.DataOffset)
+	binary.LittleEndian.PutUint64(buf[PragmaSize+24:], h.DataSize)
+	binary.LittleEndian.PutUint64(buf[PragmaSize+32:], h.IndexOffset)
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// ReadHeader reads the pragma and the header of a CARv2 file.
+func ReadHeader(r io.Reader) (Header, error) {
+	buf := make([]byte, PragmaSize+HeaderSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return Header{}, ErrNotCarV2
+		}
+		return Header{}, err
+	}
+	if !bytes.Equal(buf[:PragmaSize], Pragma) {
+		return Header{}, ErrNotCarV2
+	}
+
+	var h Header
+	copy(h.Characteristics[:], buf[PragmaSize:])
+	h.DataOffset = binary.LittleEndian.Uint64(buf[PragmaSize+16:])
+	h.DataSize = binary.LittleEndian.Uint64(buf[PragmaSize+24:])
+	h.IndexOffset = binary.LittleEndian.Uint64(buf[PragmaSize+32:])
+	if h.DataOffset < PragmaSize+HeaderSize {
+		return Header{}, fmt.Errorf("invalid CARv2 header: data offset %d overlaps the header", h.DataOffset)
+	}
+	if h.IndexOffset != 0 && h.IndexOffset < h.DataOffset+h.DataSize {
+		return Header{}, fmt.Errorf("invalid CARv2 header: index offset %d overlaps the data", h.IndexOffset)
+	}
+	return h, nil
+}
+
+// DataReader returns the CARv1 payload of r, which can be a CARv1 or a CARv2
+// file, and the header of r if it is a CARv2 file. Nothing past the payload
+// is read from r.
+func DataReader(r io.Reader) (io.Reader, *Header, error) {
+	br := bufio.NewReader(r)
+	pragma, err := br.Peek(PragmaSize)
+	if err != nil || !bytes.Equal(pragma, Pragma) {
+		// let the CARv1 reader deal with anything that isn't CARv2
+		return br, nil, nil
+	}
+
+	h, err := ReadHeader(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.CopyN(ioutil.Discard, br, int64(h.DataOffset-PragmaSize-HeaderSize)); err != nil {
+		return nil, nil, fmt.Errorf("CARv2 data payload: %w", err)
+	}
+	return io.LimitReader(br, int64(h.DataSize)), &h, nil
+}
+
+// PathProof reports whether the first of the two roots of the CARv1 payload
+// only proves the path to the second one, as in the files 'ipfs dag export'
+// writes for paths. This characteristic is specific to go-ipfs, and uses a
+// bit that the CARv2 specification reserves: other implementations ignore it.
+func (h Header) PathProof() bool {
+	return h.Characteristics[15]&pathProofBit != 0
+}
+
+// SetPathProof sets the PathProof characteristic.
+func (h *Header) SetPathProof() {
+	h.Characteristics[15] |= pathProofBit
+}
+
+const pathProofBit = 1
+
+// Wrap writes the CARv1 file v1 to w as a CARv2 file with an index. v1 is
+// read twice from its beginning: to index it, then to copy it.
+func Wrap(w io.Writer, v1 io.ReadSeeker) error {
+	return WrapCharacteristics(w, v1, [16]byte{})
+}
+
+// WrapCharacteristics is like Wrap, with the given characteristics in the
+// header of the CARv2 file.
+func WrapCharacteristics(w io.Writer, v1 io.ReadSeeker, characteristics [16]byte) error {
+	if _, err := v1.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	idx, size, err := BuildIndex(v1)
+	if err != nil {
+		return err
+	}
+	if _, err := v1.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	h := NewHeader(size)
+	h.Characteristics = characteristics
+	if _, err := h.WriteTo(w); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(w, v1, int64(size)); err != nil {
+		return err
+	}
+	_, err = idx.WriteTo(w)
+	return err
+}
+
+// BuildIndex indexes the blocks of the CARv1 payload read from r, and returns
+// the size of the payload.
+func BuildIndex(r io.Reader) (*Index, uint64, error) {
+	br := &countingReader{r: bufio.NewReader(r)}
+
+	// the CARv1 header
+	hlen, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading CARv1 header: %w", err)
+	}
+	if _, err := io.CopyN(ioutil.Discard, br, int64(hlen)); err != nil {
+		return nil, 0, fmt.Errorf("reading CARv1 header: %w", err)
+	}
+
+	idx := NewIndex()
+	var section []byte
+	for {
+		offset := br.n
+		slen, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			idx.Sort()
+			return idx, offset, nil
+		} else if err != nil {
+			return nil, 0, fmt.Errorf("reading section at offset %d: %w", offset, err)
+		}
+		if slen == 0 || slen > maxSectionSize {
+			return nil, 0, fmt.Errorf("invalid section length %d at offset %d", slen, offset)
+		}
+
+		if uint64(cap(section)) < slen {
+			section = make([]byte, slen)
+		}
+		section = section[:slen]
+		if _, err := io.ReadFull(br, section); err != nil {
+			return nil, 0, fmt.Errorf("reading section at offset %d: %w", offset, err)
+		}
+		_, c, err := cid.CidFromBytes(section)
+		if err != nil {
+			return nil, 0, fmt.Errorf("reading section at offset %d: %w", offset, err)
+		}
+		if err := idx.Insert(c, offset); err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+type countingReader struct {
+	r *bufio.Reader
+	n uint64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += uint64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
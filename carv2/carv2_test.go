@@ -0,0 +1,221 @@
+package carv2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	mdag "github.com/ipfs/go-merkledag"
+	mdtest "github.com/ipfs/go-merkledag/test"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+// testCarV1 returns a CARv1 file of a small DAG, and the CIDs of its blocks.
+func testCarV1(t *testing.T) ([]byte, []cid.Cid) {
+	ctx := context.Background()
+	ds := mdtest.Mock()
+
+	var cids []cid.Cid
+	root := new(mdag.ProtoNode)
+	for _, data := range []string{"foo", "bar", "baz"} {
+		nd := mdag.NodeWithData([]byte(data))
+		if err := ds.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		if err := root.AddNodeLink(data, nd); err != nil {
+			t.Fatal(err)
+		}
+		cids = append(cids, nd.Cid())
+	}
+	raw := mdag.NewRawNode([]byte("raw"))
+	if err := ds.AddMany(ctx, []ipld.Node{raw, root}); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.AddNodeLink("raw", raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Add(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	cids = append(cids, raw.Cid(), root.Cid())
+
+	var buf bytes.Buffer
+	if err := gocar.WriteCar(ctx, ds, []cid.Cid{root.Cid()}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), cids
+}
+
+func TestWrap(t *testing.T) {
+	v1, cids := testCarV1(t)
+
+	var v2 bytes.Buffer
+	if err := Wrap(&v2, bytes.NewReader(v1)); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := ReadHeader(bytes.NewReader(v2.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.DataOffset != PragmaSize+HeaderSize || h.DataSize != uint64(len(v1)) || h.IndexOffset != h.DataOffset+h.DataSize {
+		t.Fatalf("unexpected header: %+v", h)
+	}
+
+	data, dh, err := DataReader(bytes.NewReader(v2.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dh == nil || *dh != h {
+		t.Fatalf("DataReader returned header %+v, expected %+v", dh, h)
+	}
+	payload, err := ioutil.ReadAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(payload, v1) {
+		t.Fatal("payload differs from the CARv1 file")
+	}
+
+	idx, err := ReadIndex(bytes.NewReader(v2.Bytes()[h.IndexOffset:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Len() != len(cids) {
+		t.Fatalf("index has %d entries, expected %d", idx.Len(), len(cids))
+	}
+	for _, c := range cids {
+		offset, ok := idx.Get(c)
+		if !ok {
+			t.Fatalf("%s is not indexed", c)
+		}
+		section, err := carutil.LdRead(bufio.NewReader(bytes.NewReader(v1[offset:])))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, sc, err := cid.CidFromBytes(section)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sc.Equals(c) {
+			t.Fatalf("offset of %s points to %s", c, sc)
+		}
+	}
+
+	missing := mdag.NodeWithData([]byte("missing")).Cid()
+	if _, ok := idx.Get(missing); ok {
+		t.Fatal("found a block that isn't in the CAR")
+	}
+}
+
+func TestWrapCharacteristics(t *testing.T) {
+	v1, _ := testCarV1(t)
+
+	var h Header
+	h.SetPathProof()
+	var v2 bytes.Buffer
+	if err := WrapCharacteristics(&v2, bytes.NewReader(v1), h.Characteristics); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadHeader(bytes.NewReader(v2.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.PathProof() {
+		t.Fatalf("expected the PathProof characteristic to be set: %+v", got)
+	}
+
+	v2.Reset()
+	if err := Wrap(&v2, bytes.NewReader(v1)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = ReadHeader(bytes.NewReader(v2.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got.PathProof() {
+		t.Fatalf("expected the PathProof characteristic to be unset: %+v", got)
+	}
+}
+
+func TestDataReaderV1(t *testing.T) {
+	v1, _ := testCarV1(t)
+
+	data, h, err := DataReader(bytes.NewReader(v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h != nil {
+		t.Fatalf("CARv1 file has a CARv2 header: %+v", h)
+	}
+	payload, err := ioutil.ReadAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(payload, v1) {
+		t.Fatal("CARv1 file was not passed through")
+	}
+
+	if _, err := ReadHeader(bytes.NewReader(v1)); err != ErrNotCarV2 {
+		t.Fatalf("expected ErrNotCarV2, got %v", err)
+	}
+}
+
+func TestBuildIndexTruncated(t *testing.T) {
+	v1, _ := testCarV1(t)
+	if _, _, err := BuildIndex(bytes.NewReader(v1[:len(v1)-3])); err == nil {
+		t.Fatal("expected an error for a truncated CAR")
+	}
+}
+
+// TestIndexConcurrentGet is meant to be run with -race: the lookups don't
+// modify the index.
+func TestIndexConcurrentGet(t *testing.T) {
+	v1, cids := testCarV1(t)
+	built, _, err := BuildIndex(bytes.NewReader(v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v2 bytes.Buffer
+	if err := Wrap(&v2, bytes.NewReader(v1)); err != nil {
+		t.Fatal(err)
+	}
+	h, err := ReadHeader(bytes.NewReader(v2.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadIndex(bytes.NewReader(v2.Bytes()[h.IndexOffset:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, idx := range map[string]*Index{"built": built, "read": read} {
+		idx := idx
+		t.Run(name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			errs := make(chan error, 8*len(cids))
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for _, c := range cids {
+						if _, ok := idx.Get(c); !ok {
+							errs <- fmt.Errorf("%s is not indexed", c)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Fatal(err)
+			}
+		})
+	}
+}
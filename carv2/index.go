@@ -0,0 +1,175 @@
+package carv2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// IndexSorted is the multicodec of the car-index-sorted index format: index
+// entries are grouped by multihash digest length, and sorted by digest.
+const IndexSorted = 0x0400
+
+// Index maps the multihash digests of the blocks of a CARv1 payload to the
+// offsets of their sections in the payload. The blocks are inserted, then the
+// index is sorted once, after which the lookups are read-only and safe for
+// concurrent use.
+type Index struct {
+	buckets map[uint32][]indexEntry // by digest length
+}
+
+type indexEntry struct {
+	digest []byte
+	offset uint64
+}
+
+// NewIndex returns an empty index.
+func NewIndex() *Index {
+	return &Index{buckets: make(map[uint32][]indexEntry)}
+}
+
+// Insert records that the block c is in the section at offset. Sort must be
+// called once all the blocks are inserted.
+func (idx *Index) Insert(c cid.Cid, offset uint64) error {
+	dec, err := mh.Decode(c.Hash())
+	if err != nil {
+		return err
+	}
+	width := uint32(len(dec.Digest))
+	idx.buckets[width] = append(idx.buckets[width], indexEntry{dec.Digest, offset})
+	return nil
+}
+
+// Get returns the offset of the section of c, or false if c isn't indexed.
+func (idx *Index) Get(c cid.Cid) (uint64, bool) {
+	dec, err := mh.Decode(c.Hash())
+	if err != nil {
+		return 0, false
+	}
+	entries := idx.buckets[uint32(len(dec.Digest))]
+	i := sort.Search(len(entries), func(i int) bool {
+		return bytes.Compare(entries[i].digest, dec.Digest) >= 0
+	})
+	if i == len(entries) || !bytes.Equal(entries[i].digest, dec.Digest) {
+		return 0, false
+	}
+	return entries[i].offset, true
+}
+
+// Len returns the number of indexed sections.
+func (idx *Index) Len() int {
+	var n int
+	for _, entries := range idx.buckets {
+		n += len(entries)
+	}
+	return n
+}
+
+// Sort sorts the entries by digest, for Get and WriteTo.
+func (idx *Index) Sort() {
+	for _, entries := range idx.buckets {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i].digest, entries[j].digest) < 0
+		})
+	}
+}
+
+// WriteTo writes the index in the IndexSorted format, prefixed with its
+// multicodec, as found at the end of CARv2 files.
+func (idx *Index) WriteTo(w io.Writer) (int64, error) {
+	widths := make([]uint32, 0, len(idx.buckets))
+	for width := range idx.buckets {
+		widths = append(widths, width)
+	}
+	sort.Slice(widths, func(i, j int) bool { return widths[i] < widths[j] })
+
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+	var buf [binary.MaxVarintLen64]byte
+	cw.Write(buf[:binary.PutUvarint(buf[:], IndexSorted)])
+	binary.Write(cw, binary.LittleEndian, uint32(len(widths)))
+	for _, width := range widths {
+		entries := idx.buckets[width]
+		// entries are the digest followed by the offset
+		binary.Write(cw, binary.LittleEndian, width+8)
+		binary.Write(cw, binary.LittleEndian, uint64(len(entries))*uint64(width+8))
+		for _, e := range entries {
+			cw.Write(e.digest)
+			binary.Write(cw, binary.LittleEndian, e.offset)
+		}
+	}
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, bw.Flush()
+}
+
+// ReadIndex reads an index written by WriteTo.
+func ReadIndex(r io.Reader) (*Index, error) {
+	br := bufio.NewReader(r)
+	codec, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("reading index codec: %w", err)
+	}
+	if codec != IndexSorted {
+		return nil, fmt.Errorf("unsupported index codec 0x%x", codec)
+	}
+
+	var nwidths uint32
+	if err := binary.Read(br, binary.LittleEndian, &nwidths); err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+	idx := NewIndex()
+	for i := uint32(0); i < nwidths; i++ {
+		var (
+			width uint32
+			size  uint64
+		)
+		if err := binary.Read(br, binary.LittleEndian, &width); err != nil {
+			return nil, fmt.Errorf("reading index: %w", err)
+		}
+		if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+			return nil, fmt.Errorf("reading index: %w", err)
+		}
+		if width <= 8 || size%uint64(width) != 0 {
+			return nil, fmt.Errorf("invalid index bucket: width %d, size %d", width, size)
+		}
+
+		var entries []indexEntry
+		rec := make([]byte, width)
+		for n := uint64(0); n < size; n += uint64(width) {
+			if _, err := io.ReadFull(br, rec); err != nil {
+				return nil, fmt.Errorf("reading index: %w", err)
+			}
+			digest := make([]byte, width-8)
+			copy(digest, rec)
+			entries = append(entries, indexEntry{digest, binary.LittleEndian.Uint64(rec[width-8:])})
+		}
+		idx.buckets[width-8] = entries
+	}
+	// keep the lookups correct even if the writer didn't sort
+	idx.Sort()
+	return idx, nil
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,185 @@
+package carv2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("carv2")
+
+// IndexSuffix is appended to the path of a CAR file to find its separate
+// index, as written by 'ipfs dag index'.
+const IndexSuffix = ".idx"
+
+// ReadOnly gives access to the blocks of a CAR file on disk, through an index
+// of the file. It is safe for concurrent use.
+type ReadOnly struct {
+	f          *os.File
+	dataOffset int64
+	dataSize   int64
+	idx        *Index
+	hashOnRead int32 // atomic
+}
+
+// OpenReadOnly opens the CARv1 or CARv2 file at path. The index of the file
+// is, in order of preference: the index embedded in a CARv2 file, the index
+// at path+IndexSuffix, or an index built by scanning the file.
+func OpenReadOnly(path string) (*ReadOnly, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	ro, err := newReadOnly(f, path)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ro, nil
+}
+
+func newReadOnly(f *os.File, path string) (*ReadOnly, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	ro := &ReadOnly{f: f, dataSize: st.Size()}
+
+	h, err := ReadHeader(f)
+	switch err {
+	case nil:
+		ro.dataOffset = int64(h.DataOffset)
+		ro.dataSize = int64(h.DataSize)
+		if h.DataOffset+h.DataSize > uint64(st.Size()) {
+			return nil, fmt.Errorf("truncated CARv2 file: the data payload ends at %d, past the end of the file", h.DataOffset+h.DataSize)
+		}
+		if h.IndexOffset != 0 {
+			ro.idx, err = ReadIndex(io.NewSectionReader(f, int64(h.IndexOffset), st.Size()-int64(h.IndexOffset)))
+			if err != nil {
+				return nil, err
+			}
+			return ro, nil
+		}
+	case ErrNotCarV2:
+	default:
+		return nil, err
+	}
+
+	if idxf, err := os.Open(path + IndexSuffix); err == nil {
+		defer idxf.Close()
+		ro.idx, err = ReadIndex(idxf)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", idxf.Name(), err)
+		}
+		return ro, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	log.Infof("%s has no index, scanning it", path)
+	ro.idx, _, err = BuildIndex(ro.data())
+	if err != nil {
+		return nil, err
+	}
+	return ro, nil
+}
+
+func (ro *ReadOnly) data() *io.SectionReader {
+	return io.NewSectionReader(ro.f, ro.dataOffset, ro.dataSize)
+}
+
+// Len returns the number of blocks in the file.
+func (ro *ReadOnly) Len() int {
+	return ro.idx.Len()
+}
+
+// Has tells whether the block c is in the file.
+func (ro *ReadOnly) Has(c cid.Cid) (bool, error) {
+	_, err := ro.section(c)
+	if err == blockstore.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Get returns the block c, or blockstore.ErrNotFound.
+func (ro *ReadOnly) Get(c cid.Cid) (blocks.Block, error) {
+	data, err := ro.section(c)
+	if err != nil {
+		return nil, err
+	}
+	if atomic.LoadInt32(&ro.hashOnRead) == 1 {
+		rc, err := c.Prefix().Sum(data)
+		if err != nil {
+			return nil, err
+		}
+		if !rc.Equals(c) {
+			return nil, blockstore.ErrHashMismatch
+		}
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// GetSize returns the size of the block c, or blockstore.ErrNotFound.
+func (ro *ReadOnly) GetSize(c cid.Cid) (int, error) {
+	data, err := ro.section(c)
+	if err != nil {
+		return -1, err
+	}
+	return len(data), nil
+}
+
+// HashOnRead sets whether blocks are checked against their CID when read.
+func (ro *ReadOnly) HashOnRead(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&ro.hashOnRead, v)
+}
+
+// Close closes the file.
+func (ro *ReadOnly) Close() error {
+	return ro.f.Close()
+}
+
+// section returns the data of the block c in the file.
+func (ro *ReadOnly) section(c cid.Cid) ([]byte, error) {
+	offset, ok := ro.idx.Get(c)
+	if !ok {
+		return nil, blockstore.ErrNotFound
+	}
+	if int64(offset) >= ro.dataSize {
+		return nil, fmt.Errorf("index points past the data payload: offset %d", offset)
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(ro.f, ro.dataOffset+int64(offset), ro.dataSize-int64(offset)))
+	slen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading section at offset %d: %w", offset, err)
+	}
+	if slen == 0 || slen > maxSectionSize {
+		return nil, fmt.Errorf("invalid section length %d at offset %d", slen, offset)
+	}
+	section := make([]byte, slen)
+	if _, err := io.ReadFull(r, section); err != nil {
+		return nil, fmt.Errorf("reading section at offset %d: %w", offset, err)
+	}
+	n, sc, err := cid.CidFromBytes(section)
+	if err != nil {
+		return nil, fmt.Errorf("reading section at offset %d: %w", offset, err)
+	}
+	// the index only knows digests: the hash function can differ
+	if string(sc.Hash()) != string(c.Hash()) {
+		return nil, blockstore.ErrNotFound
+	}
+	return section[n:], nil
+}
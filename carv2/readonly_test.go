@@ -0,0 +1,106 @@
+package carv2
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	mdag "github.com/ipfs/go-merkledag"
+)
+
+func TestReadOnly(t *testing.T) {
+	v1, cids := testCarV1(t)
+	var v2 bytes.Buffer
+	if err := Wrap(&v2, bytes.NewReader(v1)); err != nil {
+		t.Fatal(err)
+	}
+	idx, _, err := BuildIndex(bytes.NewReader(v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var idxBuf bytes.Buffer
+	if _, err := idx.WriteTo(&idxBuf); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "carv2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string][]byte{
+		"v1.car":                    v1,
+		"indexed.car":               v1,
+		"indexed.car" + IndexSuffix: idxBuf.Bytes(),
+		"v2.car":                    v2.Bytes(),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"v1.car", "indexed.car", "v2.car"} {
+		t.Run(name, func(t *testing.T) {
+			ro, err := OpenReadOnly(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ro.Close()
+			ro.HashOnRead(true)
+
+			if ro.Len() != len(cids) {
+				t.Fatalf("got %d blocks, expected %d", ro.Len(), len(cids))
+			}
+			for _, c := range cids {
+				has, err := ro.Has(c)
+				if err != nil || !has {
+					t.Fatalf("%s not found: %v", c, err)
+				}
+				b, err := ro.Get(c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				size, err := ro.GetSize(c)
+				if err != nil || size != len(b.RawData()) {
+					t.Fatalf("got size %d (%v), expected %d", size, err, len(b.RawData()))
+				}
+			}
+
+			missing := mdag.NodeWithData([]byte("missing")).Cid()
+			if has, err := ro.Has(missing); err != nil || has {
+				t.Fatalf("found a block that isn't in the CAR: %v", err)
+			}
+			if _, err := ro.Get(missing); err != blockstore.ErrNotFound {
+				t.Fatalf("expected ErrNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestReadOnlyTruncated(t *testing.T) {
+	v1, _ := testCarV1(t)
+	var v2 bytes.Buffer
+	if err := Wrap(&v2, bytes.NewReader(v1)); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "carv2-*.car")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	// cut into the data payload
+	if _, err := f.Write(v2.Bytes()[:PragmaSize+HeaderSize+len(v1)/2]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if _, err := OpenReadOnly(f.Name()); err == nil {
+		t.Fatal("expected an error for a truncated CARv2 file")
+	}
+}
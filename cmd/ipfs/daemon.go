@@ -45,6 +45,8 @@ import (
 const (
 	adjustFDLimitKwd          = "manage-fdlimit"
 	enableGCKwd               = "enable-gc"
+	incrementalGCKwd          = "incremental-gc"
+	lruGCKwd                  = "lru-gc"
 	initOptionKwd             = "init"
 	initConfigOptionKwd       = "init-config"
 	initProfileOptionKwd      = "init-profile"
@@ -174,6 +176,8 @@ Headers.
 		cmds.BoolOption(unrestrictedApiAccessKwd, "Allow API access to unlisted hashes"),
 		cmds.BoolOption(unencryptTransportKwd, "Disable transport encryption (for debugging protocols)"),
 		cmds.BoolOption(enableGCKwd, "Enable automatic periodic repo garbage collection"),
+		cmds.BoolOption(incrementalGCKwd, "Have the garbage collections enabled with --enable-gc remove the blocks a few at a time, without blocking adds and pins. See 'ipfs repo gc --help'"),
+		cmds.BoolOption(lruGCKwd, "Have the garbage collections enabled with --enable-gc only evict the least recently used unpinned blocks, down to Datastore.StorageLRUTarget percent of Datastore.StorageMax (default: 10 below Datastore.StorageGCWatermark)"),
 		cmds.BoolOption(adjustFDLimitKwd, "Check and raise file descriptor limits if needed").WithDefault(true),
 		cmds.BoolOption(migrateKwd, "If true, assume yes at the migrate prompt. If false, assume no."),
 		cmds.BoolOption(enablePubSubKwd, "Instantiate the ipfs daemon with the experimental pubsub feature enabled."),
@@ -456,6 +460,12 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 		return err
 	}
 
+	// removal of the pins past their expiry time
+	expiryErrc := runPinExpiry(req, node)
+
+	// pins added in the background, see 'ipfs pin status'
+	node.PinQueue.Start()
+
 	// Add any files downloaded by migration.
 	if cacheMigrations || pinMigrations {
 		err = addMigrations(cctx.Context(), node, fetcher, pinMigrations)
@@ -542,7 +552,7 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 	// collect long-running errors and block for shutdown
 	// TODO(cryptix): our fuse currently doesn't follow this pattern for graceful shutdown
 	var errs error
-	for err := range merge(apiErrc, gwErrc, gcErrc) {
+	for err := range merge(apiErrc, gwErrc, gcErrc, expiryErrc) {
 		if err != nil {
 			errs = multierror.Append(errs, err)
 		}
@@ -822,18 +832,51 @@ func mountFuse(req *cmds.Request, cctx *oldcmds.Context) error {
 
 func maybeRunGC(req *cmds.Request, node *core.IpfsNode) (<-chan error, error) {
 	enableGC, _ := req.Options[enableGCKwd].(bool)
+	incremental, _ := req.Options[incrementalGCKwd].(bool)
+	lru, _ := req.Options[lruGCKwd].(bool)
+	for _, o := range []struct {
+		name string
+		set  bool
+	}{
+		{incrementalGCKwd, incremental},
+		{lruGCKwd, lru},
+	} {
+		if o.set && !enableGC {
+			return nil, fmt.Errorf("--%s only applies to the garbage collections enabled with --%s", o.name, enableGCKwd)
+		}
+	}
+	if incremental && lru {
+		return nil, fmt.Errorf("--%s and --%s cannot be used together: they are different kinds of garbage collection", incrementalGCKwd, lruGCKwd)
+	}
 	if !enableGC {
 		return nil, nil
 	}
 
+	periodicGC := corerepo.PeriodicGC
+	switch {
+	case lru:
+		periodicGC = corerepo.PeriodicLRUGC
+	case incremental:
+		periodicGC = corerepo.PeriodicIncrementalGC
+	}
+
 	errc := make(chan error)
 	go func() {
-		errc <- corerepo.PeriodicGC(req.Context, node)
+		errc <- periodicGC(req.Context, node)
 		close(errc)
 	}()
 	return errc, nil
 }
 
+func runPinExpiry(req *cmds.Request, node *core.IpfsNode) <-chan error {
+	errc := make(chan error)
+	go func() {
+		errc <- corerepo.PeriodicPinExpiry(req.Context, node)
+		close(errc)
+	}()
+	return errc
+}
+
 // merge does fan-in of multiple read-only error channels
 // taken from http://blog.golang.org/pipelines
 func merge(cs ...<-chan error) <-chan error {
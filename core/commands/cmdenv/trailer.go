@@ -0,0 +1,34 @@
+package cmdenv
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+type trailersKey struct{}
+
+type trailers struct {
+	mu     sync.Mutex
+	header http.Header
+}
+
+// WithTrailers returns a context through which the commands run for an HTTP
+// request can set the trailers of the response, whose header is h.
+func WithTrailers(ctx context.Context, h http.Header) context.Context {
+	return context.WithValue(ctx, trailersKey{}, &trailers{header: h})
+}
+
+// SetTrailer sets an HTTP trailer of the response, if the command runs for
+// a request of the HTTP API. It must only be called once the output was
+// emitted, and does nothing otherwise.
+func SetTrailer(ctx context.Context, key, value string) {
+	t, ok := ctx.Value(trailersKey{}).(*trailers)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// trailers don't have to be declared when set with this prefix
+	t.header.Set(http.TrailerPrefix+key, value)
+}
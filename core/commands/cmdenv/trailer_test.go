@@ -0,0 +1,34 @@
+package cmdenv
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetTrailer(t *testing.T) {
+	// without an HTTP request, trailers are ignored
+	SetTrailer(context.Background(), "X-Test", "ignored")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithTrailers(r.Context(), w.Header())
+		w.Write([]byte("body"))
+		w.(http.Flusher).Flush()
+		SetTrailer(ctx, "X-Test", "done")
+	}))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if _, err := ioutil.ReadAll(res.Body); err != nil {
+		t.Fatal(err)
+	}
+	if v := res.Trailer.Get("X-Test"); v != "done" {
+		t.Fatalf("expected trailer done, got %q", v)
+	}
+}
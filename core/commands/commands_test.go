@@ -104,6 +104,11 @@ func TestCommands(t *testing.T) {
 		"/dag/export",
 		"/dag/put",
 		"/dag/import",
+		"/dag/index",
+		"/dag/diff",
+		"/dag/mount-car",
+		"/dag/mounted-cars",
+		"/dag/unmount-car",
 		"/dag/resolve",
 		"/dag/stat",
 		"/dht",
@@ -192,6 +197,7 @@ func TestCommands(t *testing.T) {
 		"/pin/remote/service/ls",
 		"/pin/remote/service/rm",
 		"/pin/rm",
+		"/pin/status",
 		"/pin/update",
 		"/pin/verify",
 		"/ping",
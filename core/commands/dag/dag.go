@@ -1,8 +1,11 @@
 package dagcmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
+	"text/tabwriter"
 
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
 
@@ -10,15 +13,24 @@ import (
 	cidenc "github.com/ipfs/go-cidutil/cidenc"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	ipfspath "github.com/ipfs/go-path"
-	//gipfree "github.com/ipld/go-ipld-prime/impl/free"
-	//gipselector "github.com/ipld/go-ipld-prime/traversal/selector"
-	//gipselectorbuilder "github.com/ipld/go-ipld-prime/traversal/selector/builder"
 )
 
 const (
-	progressOptionName = "progress"
-	silentOptionName   = "silent"
-	pinRootsOptionName = "pin-roots"
+	progressOptionName    = "progress"
+	silentOptionName      = "silent"
+	pinRootsOptionName    = "pin-roots"
+	selectorOptionName    = "selector"
+	carVersionOptionName  = "car-version"
+	wrapOptionName        = "wrap"
+	verifyOptionName      = "verify"
+	batchOptionName       = "batch"
+	outputCodecOptionName = "output-codec"
+	localOnlyOptionName   = "local-only"
+	verboseOptionName     = "verbose"
+	schemaOptionName      = "schema"
+	schemaTypeOptionName  = "schema-type"
+	resumeFromOptionName  = "resume-from"
+	pathProofOptionName   = "path-proof"
 )
 
 // DagCmd provides a subset of commands for interacting with ipld dag objects
@@ -39,12 +51,20 @@ to deprecate and replace the existing 'ipfs object' command moving forward.
 		"import":  DagImportCmd,
 		"export":  DagExportCmd,
 		"stat":    DagStatCmd,
+		"index":   DagIndexCmd,
+		"diff":    DagDiffCmd,
+
+		"mount-car":    DagMountCarCmd,
+		"unmount-car":  DagUnmountCarCmd,
+		"mounted-cars": DagMountedCarsCmd,
 	},
 }
 
 // OutputObject is the output type of 'dag put' command
 type OutputObject struct {
 	Cid cid.Cid
+	// Label is the label of the object, with --batch.
+	Label string `json:",omitempty"`
 }
 
 // ResolveOutput is the output type of 'dag resolve' command
@@ -55,13 +75,36 @@ type ResolveOutput struct {
 
 // CarImportOutput is the output type of the 'dag import' commands
 type CarImportOutput struct {
-	Root RootMeta
+	Root  *RootMeta       `json:",omitempty"`
+	Stats *CarImportStats `json:",omitempty"`
+}
+
+// CarImportStats counts the blocks read by 'dag import --verify'
+type CarImportStats struct {
+	BlockCount uint64
+	// SkippedCount is the number of blocks that were already present, as
+	// when an interrupted import is resumed.
+	SkippedCount uint64
+}
+
+// CarMountOutput is the output type of the 'dag mount-car' and
+// 'dag mounted-cars' commands
+type CarMountOutput struct {
+	Path   string
+	Blocks int
+	Error  string `json:",omitempty"`
 }
 
 // RootMeta is the metadata for a root pinning response
 type RootMeta struct {
 	Cid         cid.Cid
 	PinErrorMsg string
+	// Missing lists, with --verify, the blocks of the DAG of the root that
+	// are neither in the .car files nor in the blockstore.
+	Missing []cid.Cid `json:",omitempty"`
+	// Proof is set for the roots that only prove the path to another root,
+	// which are left alone.
+	Proof bool `json:",omitempty"`
 }
 
 // DagPutCmd is a command for adding a dag node
@@ -71,6 +114,56 @@ var DagPutCmd = &cmds.Command{
 		ShortDescription: `
 'ipfs dag put' accepts input from a file or stdin and parses it
 into an object of the specified format.
+`,
+		LongDescription: `
+'ipfs dag put' accepts input from a file or stdin and parses it
+into an object of the specified format.
+
+With --input-enc=dag-json, the input is read as in the dag-json spec, as
+'ipfs dag get --output-codec=dag-json' writes it: integers are kept exact,
+and {"/": {"bytes": "<base64>"}} is read as bytes.
+
+With --batch, the input is newline-delimited JSON records, one object per
+line, added through a single batch:
+
+  {"label": "<label>", "object": <object>}
+
+The label is optional, and defaults to the line number of the record,
+counting the lines of all the files in order: the first line of a second
+file of 3 lines is labeled 4. Objects are read as JSON, or as dag-json with
+--input-enc=dag-json. Objects can link to the objects of earlier records, in
+the same file or in earlier files, with {"/": "@<label>"}. The label and the
+CID of every object are output, in the order of the input, once all the
+objects are written:
+
+  > ipfs dag put --batch <<EOF
+  {"label": "alice", "object": {"name": "Alice"}}
+  {"label": "bob", "object": {"name": "Bob", "friends": [{"/": "@alice"}]}}
+  EOF
+  alice   bafyreia...
+  bob     bafyreib...
+
+With --schema, every object is checked against a type of an IPLD Schema
+before it is added, and objects that don't conform to it are rejected with
+the paths of the values that don't. The schema is either the name of a
+schema stored in the repo, as $IPFS_PATH/schemas/<name>.json, or the CID
+or IPFS path of a schema object. Schemas are in their JSON form, the data
+model of the schema DSL:
+
+  {"types": {
+    "Record": {"struct": {
+      "fields": {
+        "name": {"type": "String"},
+        "tags": {"type": {"list": {"valueType": "String"}}, "optional": true}
+      },
+      "representation": {"map": {}}
+    }}
+  }}
+
+The type is given with --schema-type, and can be left out if the schema
+has a single type. Links are checked to be links, but the objects they
+point to are not fetched. Note that the json input encoding reads all
+numbers as floats: use --input-enc=dag-json for objects with Int values.
 `,
 	},
 	Arguments: []cmds.Argument{
@@ -81,6 +174,9 @@ into an object of the specified format.
 		cmds.StringOption("input-enc", "Format that the input object will be.").WithDefault("json"),
 		cmds.BoolOption("pin", "Pin this object when adding."),
 		cmds.StringOption("hash", "Hash function to use").WithDefault(""),
+		cmds.BoolOption(batchOptionName, "Read newline-delimited JSON records of labeled objects, see the help text."),
+		cmds.StringOption(schemaOptionName, "Reject the objects that don't conform to this IPLD Schema: a name, CID or IPFS path, see the help text."),
+		cmds.StringOption(schemaTypeOptionName, "Type of the schema that the objects must conform to."),
 	},
 	Run:  dagPut,
 	Type: OutputObject{},
@@ -90,6 +186,10 @@ into an object of the specified format.
 			if err != nil {
 				return err
 			}
+			if out.Label != "" {
+				fmt.Fprintf(w, "%s\t%s\n", out.Label, enc.Encode(out.Cid))
+				return nil
+			}
 			fmt.Fprintln(w, enc.Encode(out.Cid))
 			return nil
 		}),
@@ -103,11 +203,32 @@ var DagGetCmd = &cmds.Command{
 		ShortDescription: `
 'ipfs dag get' fetches a DAG node from IPFS and prints it out in the specified
 format.
+`,
+		LongDescription: `
+'ipfs dag get' fetches a DAG node from IPFS and prints it out in the specified
+format.
+
+By default, the node is printed as JSON, in a form that depends on its codec
+and that doesn't tell bytes from strings. With --output-codec, the node, or
+the value at the end of the path, is encoded with an IPLD codec instead:
+
+  dag-json  JSON as in the dag-json spec: links are {"/": "<cid>"} and bytes
+            are {"/": {"bytes": "<base64>"}}.
+  dag-cbor  CBOR, the encoding of dag-cbor blocks.
+  raw       the bytes of the block as they are, or the value at the end of
+            the path if it is bytes.
+
+The output of dag-json and dag-cbor can be added back with 'ipfs dag put',
+with --input-enc=dag-json or --input-enc=cbor respectively. Blocks of
+dag-cbor are then added back as they were.
 `,
 	},
 	Arguments: []cmds.Argument{
 		cmds.StringArg("ref", true, false, "The object to get").EnableStdin(),
 	},
+	Options: []cmds.Option{
+		cmds.StringOption(outputCodecOptionName, "Encode the output with an IPLD codec: dag-json, dag-cbor or raw."),
+	},
 	Run: dagGet,
 }
 
@@ -157,8 +278,11 @@ var DagResolveCmd = &cmds.Command{
 }
 
 type importResult struct {
-	roots map[cid.Cid]struct{}
-	err   error
+	roots   map[cid.Cid]struct{}
+	proofs  map[cid.Cid]cid.Cid
+	blocks  uint64
+	skipped uint64
+	err     error
 }
 
 // DagImportCmd is a command for importing a car to ipfs
@@ -178,12 +302,31 @@ Note:
   The pinning of the roots happens after all car files are processed,
   permitting import of DAGs spanning multiple files.
 
+  The first root of the CARv2 files that 'ipfs dag export --car-version=2'
+  writes for a path only proves the path to the second root: if its DAG is
+  incomplete, but leads to the second root, it is neither pinned nor counted
+  as incomplete. CARv1 files can't tell, --path-proof treats the first of
+  two roots of every file as such a proof.
+
   Pinning takes place in offline-mode exclusively, one root at a time.
   If the combination of blocks from the imported CAR files and what is
   currently present in the blockstore does not represent a complete DAG,
   pinning of that individual root will fail.
 
-Maximum supported CAR version: 1
+  With --verify, the DAG of every root is walked once all car files are
+  processed, and the blocks missing from it are listed. Incomplete roots
+  are not pinned, and the command fails if any root is incomplete. Only
+  the missing blocks linked from blocks that are present can be listed: the
+  rest of the DAG under them is unknown.
+
+  Blocks already in the blockstore are skipped, and the blocks read from a
+  truncated or otherwise broken .car file are kept: importing the file
+  again resumes an interrupted import. Blocks that are not pinned may be
+  garbage collected in between.
+
+CARv2 files are imported from their data payload, their index is ignored.
+
+Maximum supported CAR version: 2
 `,
 	},
 	Arguments: []cmds.Argument{
@@ -192,6 +335,8 @@ Maximum supported CAR version: 1
 	Options: []cmds.Option{
 		cmds.BoolOption(silentOptionName, "No output."),
 		cmds.BoolOption(pinRootsOptionName, "Pin optional roots listed in the .car headers after importing.").WithDefault(true),
+		cmds.BoolOption(verifyOptionName, "Check that the DAG of every root is complete, and list the missing blocks."),
+		cmds.BoolOption(pathProofOptionName, "Treat the first of two roots of the CARv1 files as the proof of the path to the second, as 'ipfs dag export' writes for a path."),
 	},
 	Type: CarImportOutput{},
 	Run:  dagImport,
@@ -208,19 +353,45 @@ Maximum supported CAR version: 1
 				return err
 			}
 
-			if event.Root.PinErrorMsg != "" {
-				event.Root.PinErrorMsg = fmt.Sprintf("FAILED: %s", event.Root.PinErrorMsg)
+			if event.Stats != nil {
+				_, err = fmt.Fprintf(w, "Imported %d blocks, %d were already present\n", event.Stats.BlockCount, event.Stats.SkippedCount)
+				return err
+			}
+
+			if doPin, _ := req.Options[pinRootsOptionName].(bool); doPin {
+				if event.Root.Proof {
+					event.Root.PinErrorMsg = "skipped: proof of the path to another root"
+				} else if event.Root.PinErrorMsg != "" {
+					event.Root.PinErrorMsg = fmt.Sprintf("FAILED: %s", event.Root.PinErrorMsg)
+				} else {
+					event.Root.PinErrorMsg = "success"
+				}
+
+				_, err = fmt.Fprintf(
+					w,
+					"Pinned root\t%s\t%s\n",
+					enc.Encode(event.Root.Cid),
+					event.Root.PinErrorMsg,
+				)
 			} else {
-				event.Root.PinErrorMsg = "success"
+				status := "complete"
+				if event.Root.Proof {
+					status = "proof of the path to another root"
+				} else if len(event.Root.Missing) > 0 {
+					status = fmt.Sprintf("incomplete: %d blocks missing", len(event.Root.Missing))
+				}
+				_, err = fmt.Fprintf(w, "Root\t%s\t%s\n", enc.Encode(event.Root.Cid), status)
+			}
+			if err != nil {
+				return err
 			}
 
-			_, err = fmt.Fprintf(
-				w,
-				"Pinned root\t%s\t%s\n",
-				enc.Encode(event.Root.Cid),
-				event.Root.PinErrorMsg,
-			)
-			return err
+			for _, c := range event.Root.Missing {
+				if _, err := fmt.Fprintf(w, "Missing block\t%s\n", enc.Encode(c)); err != nil {
+					return err
+				}
+			}
+			return nil
 		}),
 	},
 }
@@ -233,13 +404,57 @@ var DagExportCmd = &cmds.Command{
 'ipfs dag export' fetches a DAG and streams it out as a well-formed .car file.
 Note that at present only single root selections / .car files are supported.
 The output of blocks happens in strict DAG-traversal, first-seen, order.
+`,
+		LongDescription: `
+'ipfs dag export' fetches a DAG and streams it out as a well-formed .car file.
+Note that at present only single root selections / .car files are supported.
+The output of blocks happens in strict DAG-traversal, first-seen, order.
+
+The root can be a CID or a path, such as /ipfs/<cid>/sub/dir. For a path,
+the .car file has two roots, the CID the path starts from and the one it
+resolves to, and starts with the blocks traversed to resolve the path
+(parent directories, HAMT shards, etc.), so that the path can be verified
+from the first root. With --car-version=2, the CARv2 header marks the first
+root as such a proof, and 'ipfs dag import' only pins the second one. CARv1
+files can't tell, they are imported with 'ipfs dag import --path-proof'.
+
+By default the whole DAG under the root is exported. --selector limits the
+export to the blocks visited by an IPLD selector, given as dag-json. For
+example, to export a dag-pb block and the blocks it links to directly:
+
+  > ipfs dag export --selector='{"R":{"l":{"depth":4},":>":{"a":{">":{"@":{}}}}}}' <cid>
+
+Selectors walk the IPLD data model, where the links of a dag-pb node are the
+"Hash" fields of the entries of its "Links" list: a dag-pb block is three
+levels of recursion away from its children.
+
+--car-version=2 writes a CARv2 file, with an index of its blocks at the end.
+As the index is only known once the DAG was traversed, the export is
+buffered in a temporary file and only starts streaming once complete.
+
+The blocks of a DAG are always exported in the same order, so interrupted
+CARv1 exports can be resumed: --resume-from=<n> leaves out the header and
+the first n blocks, and --resume-from=<cid> the header and the blocks up to
+the one with that CID, so that the output can be appended to what was
+received, once a partial block at its end is cut off. Over the HTTP API,
+the response ends with a trailer with the progress of the export:
+
+  X-Car-Progress: blocks=<n>; last=<cid>; complete=<true|false>
+
+where n is the number of blocks exported so far, skipped ones included,
+which is the index to resume from once all of them were received. Otherwise,
+cut off the partial block at the end of the output, if any, and resume with
+--resume-from=<cid>, the CID of the last complete block.
 `,
 	},
 	Arguments: []cmds.Argument{
-		cmds.StringArg("root", true, false, "CID of a root to recursively export").EnableStdin(),
+		cmds.StringArg("root", true, false, "CID or path of a root to recursively export").EnableStdin(),
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(progressOptionName, "p", "Display progress on CLI. Defaults to true when STDERR is a TTY."),
+		cmds.StringOption(selectorOptionName, "dag-json encoded IPLD selector of the blocks to export. Defaults to the whole DAG."),
+		cmds.IntOption(carVersionOptionName, "CAR format version to write: 1, or 2 for a CARv2 file with an index.").WithDefault(1),
+		cmds.StringOption(resumeFromOptionName, "Resume an export: leave out the header and the blocks before this block index, or up to this CID."),
 	},
 	Run: dagExport,
 	PostRun: cmds.PostRunMap{
@@ -247,10 +462,217 @@ The output of blocks happens in strict DAG-traversal, first-seen, order.
 	},
 }
 
+// DagIndexCmd is a command for indexing the blocks of a car
+var DagIndexCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Build an index of the blocks of a .car file.",
+		ShortDescription: `
+'ipfs dag index' reads a .car file (version 1 or 2) and writes an index of
+the blocks in it to stdout, in the car-index-sorted format of CARv2 files.
+The index maps each block to the offset of its section in the CARv1 data
+payload, so that blocks can be read without scanning the file. It can be
+stored next to a CARv1 file, for tools that accept a separate index.
+
+With --wrap, the output is instead a CARv2 file with the data payload of the
+input and the index embedded at its end.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.FileArg("path", true, false, "The path of a .car file.").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(wrapOptionName, "Output a CARv2 file with the index embedded instead of the index alone."),
+	},
+	Run: dagIndex,
+}
+
+// DagMountCarCmd is a command for serving the blocks of a car from the node
+var DagMountCarCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Serve the blocks of a .car file without importing them.",
+		ShortDescription: `
+'ipfs dag mount-car' attaches a .car file (version 1 or 2) to the node as a
+read-only blockstore, layered under the blockstore of the repo: its blocks
+can be read, and are served over bitswap and the gateway, without being
+copied into the repo.
+`,
+		LongDescription: `
+'ipfs dag mount-car' attaches a .car file (version 1 or 2) to the node as a
+read-only blockstore, layered under the blockstore of the repo: its blocks
+can be read, and are served over bitswap and the gateway, without being
+copied into the repo.
+
+Blocks are located through the index of the file: the index embedded in a
+CARv2 file, or the index in <path>.idx next to it, as written by
+'ipfs dag index'. Other files are scanned every time they are mounted, which
+includes every start of the daemon for large files. 'ipfs dag index --wrap'
+turns a CARv1 file into an indexed CARv2 file.
+
+Mounted files are listed in the Datastore.CarMounts config key, as absolute
+paths, and mounted again when the node starts. The list can be edited with
+'ipfs config' while the daemon is stopped.
+
+Mounted blocks are not announced to the network, neither when the file is
+mounted nor by the reprovider: they are only served to the peers that already
+know where to look, such as peers connected to the node that ask for them.
+Use 'ipfs dht provide' to announce a root of the file. Mounted blocks are not
+listed by 'ipfs refs local' either, and never garbage collected. The file must
+not change while it is mounted.
+
+Mounted blocks are not part of the repo: blocks that are added, fetched or
+imported are still written to it. Pinning an object of the file doesn't copy
+its blocks, and the file can't be unmounted while they are pinned: import
+the file with 'ipfs dag import' to keep them.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, false, "The path of a .car file."),
+	},
+	PreRun: absCarPath,
+	Run:    dagMountCar,
+	Type:   CarMountOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *CarMountOutput) error {
+			_, err := fmt.Fprintf(w, "mounted %s: %d blocks\n", out.Path, out.Blocks)
+			return err
+		}),
+	},
+}
+
+// DagUnmountCarCmd is a command for detaching a mounted car from the node
+var DagUnmountCarCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Stop serving the blocks of a mounted .car file.",
+		ShortDescription: `
+'ipfs dag unmount-car' detaches a .car file mounted with 'ipfs dag mount-car'
+and removes it from the mount list. It fails if a recursive or direct pin has
+blocks that are only in the file, which would be lost: to find them, the
+pinned DAGs are walked as far as they are local.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, false, "The path of a mounted .car file."),
+	},
+	PreRun: absCarPath,
+	Run:    dagUnmountCar,
+}
+
+// DagMountedCarsCmd is a command for listing the mounted cars
+var DagMountedCarsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the mounted .car files.",
+		ShortDescription: `
+'ipfs dag mounted-cars' lists the .car files mounted with 'ipfs dag mount-car',
+with their number of blocks, or why they couldn't be mounted.
+`,
+	},
+	Run:  dagMountedCars,
+	Type: CarMountOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *CarMountOutput) error {
+			var err error
+			if out.Error != "" {
+				_, err = fmt.Fprintf(w, "%s\tFAILED: %s\n", out.Path, out.Error)
+			} else {
+				_, err = fmt.Fprintf(w, "%s\t%d blocks\n", out.Path, out.Blocks)
+			}
+			return err
+		}),
+	},
+}
+
+// DagDiffChange is the output type of the 'dag diff' command: a value
+// added, removed or changed at Path. Values are encoded as JSON, with links
+// as {"/": "<cid>"}.
+type DagDiffChange struct {
+	Type   string
+	Path   string
+	Before json.RawMessage `json:",omitempty"`
+	After  json.RawMessage `json:",omitempty"`
+}
+
+// DagDiffCmd is a command for comparing two dags
+var DagDiffCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Display the differences between two DAGs.",
+		ShortDescription: `
+'ipfs dag diff' walks two DAGs side by side and lists the values added,
+removed or changed between them, by path. DAGs of any codec can be
+compared: dag-pb, dag-cbor, dag-json, git, raw...
+`,
+		LongDescription: `
+'ipfs dag diff' walks two DAGs side by side and lists the values added,
+removed or changed between them, by path. DAGs of any codec can be
+compared: dag-pb, dag-cbor, dag-json, git, raw...
+
+Paths go through links, as in 'ipfs dag get'. Links to the same CID are not
+followed, so only the parts of the DAGs that differ are fetched and
+compared. Blocks that differ in a way their paths don't show, such as raw
+blocks or the chunks of files, are reported as changed, from one CID to the
+other. In DAGs where a block can be reached from several paths, a pair of
+blocks is only compared at the first path it is found at.
+
+Each line of the output is a change:
+
+  + <path>: <value>            added
+  - <path>: <value>            removed
+  ~ <path>: <before> => <after>  changed
+
+Values are shown as JSON, with links as {"/": "<cid>"}.
+
+Example:
+
+  > ipfs dag diff $SNAPSHOT_A $SNAPSHOT_B
+  ~ /meta/version: 1 => 2
+  + /records/12: {"/":"bafyreib..."}
+  ~ /records/3/name: "foo" => "bar"
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("before", true, false, "The DAG to diff against."),
+		cmds.StringArg("after", true, false, "The DAG to diff."),
+	},
+	Run:  dagDiff,
+	Type: DagDiffChange{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *DagDiffChange) error {
+			var err error
+			switch out.Type {
+			case diffAdded:
+				_, err = fmt.Fprintf(w, "+ %s: %s\n", out.Path, out.After)
+			case diffRemoved:
+				_, err = fmt.Fprintf(w, "- %s: %s\n", out.Path, out.Before)
+			default:
+				_, err = fmt.Fprintf(w, "~ %s: %s => %s\n", out.Path, out.Before, out.After)
+			}
+			return err
+		}),
+	},
+}
+
 // DagStat is a dag stat command response
 type DagStat struct {
+	// Size and NumBlocks count every block once
 	Size      uint64
 	NumBlocks int64
+	// TotalSize counts blocks as many times as they are linked to
+	TotalSize uint64 `json:",omitempty"`
+	// MaxDepth is the number of links on the longest path from the root
+	MaxDepth    int                      `json:",omitempty"`
+	Codecs      map[string]*DagStatCount `json:",omitempty"`
+	Multihashes map[string]*DagStatCount `json:",omitempty"`
+	// MissingBlocks is the number of blocks, with --local-only, that are
+	// linked from the DAG but not in the blockstore. MissingSize is their
+	// size according to the links to them, which only dag-pb records: it
+	// includes the blocks under them, duplicates included.
+	MissingBlocks int64  `json:",omitempty"`
+	MissingSize   uint64 `json:",omitempty"`
+}
+
+// DagStatCount counts the blocks of a kind in a dag stat command response
+type DagStatCount struct {
+	Blocks int64
+	Size   uint64
 }
 
 func (s *DagStat) String() string {
@@ -266,6 +688,25 @@ var DagStatCmd = &cmds.Command{
 Statistics include size and number of blocks.
 
 Note: This command skips duplicate blocks in reporting both size and the number of blocks
+`,
+		LongDescription: `
+'ipfs dag stat' fetches a DAG and returns various statistics about it.
+Statistics include size and number of blocks.
+
+Note: This command skips duplicate blocks in reporting both size and the number of blocks
+
+With --verbose, the statistics also include:
+  - the size of the DAG counting every block as many times as it is linked
+    to, which shows how much is saved by deduplication,
+  - the number of links on the longest path from the root,
+  - the number of blocks and their size by codec and by hash function.
+They are always included in the JSON output.
+
+With --local-only, the DAG is walked in the blockstore only, without
+fetching anything: the statistics are the ones of the blocks that are
+already there, and the blocks that are missing are counted. Their size is
+only known for dag-pb DAGs, as their links record the size of the blocks
+under them, which gives an estimate of what is left to fetch.
 `,
 	},
 	Arguments: []cmds.Argument{
@@ -273,6 +714,8 @@ Note: This command skips duplicate blocks in reporting both size and the number
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(progressOptionName, "p", "Return progressive data while reading through the DAG").WithDefault(true),
+		cmds.BoolOption(localOnlyOptionName, "Only walk the blocks already in the blockstore, and count the missing ones."),
+		cmds.BoolOption(verboseOptionName, "v", "Also print the total size with duplicates, the depth, and the breakdown by codec and hash function."),
 	},
 	Run:  dagStat,
 	Type: DagStat{},
@@ -286,7 +729,48 @@ Note: This command skips duplicate blocks in reporting both size and the number
 				"%v\n",
 				event,
 			)
-			return err
+			if err != nil {
+				return err
+			}
+
+			if localOnly, _ := req.Options[localOnlyOptionName].(bool); localOnly {
+				if event.MissingBlocks == 0 {
+					fmt.Fprintln(w, "Complete: all the blocks are local")
+				} else if event.MissingSize > 0 {
+					fmt.Fprintf(w, "MissingBlocks: %d, MissingSize: %d (according to the links)\n", event.MissingBlocks, event.MissingSize)
+				} else {
+					fmt.Fprintf(w, "MissingBlocks: %d\n", event.MissingBlocks)
+				}
+			}
+
+			if verbose, _ := req.Options[verboseOptionName].(bool); !verbose {
+				return nil
+			}
+			fmt.Fprintf(w, "TotalSize: %d (with duplicates), MaxDepth: %d\n", event.TotalSize, event.MaxDepth)
+			for _, group := range []struct {
+				title  string
+				counts map[string]*DagStatCount
+			}{
+				{"Codecs", event.Codecs},
+				{"Multihashes", event.Multihashes},
+			} {
+				names := make([]string, 0, len(group.counts))
+				for name := range group.counts {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+
+				fmt.Fprintf(w, "%s:\n", group.title)
+				tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+				for _, name := range names {
+					count := group.counts[name]
+					fmt.Fprintf(tw, "  %s\tBlocks: %d\tSize: %d\n", name, count.Blocks, count.Size)
+				}
+				if err := tw.Flush(); err != nil {
+					return err
+				}
+			}
+			return nil
 		}),
 	},
 }
@@ -0,0 +1,296 @@
+package dagcmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/interface-go-ipfs-core/path"
+
+	cid "github.com/ipfs/go-cid"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	ipld "github.com/ipfs/go-ipld-format"
+	mdag "github.com/ipfs/go-merkledag"
+)
+
+const (
+	diffAdded   = "added"
+	diffRemoved = "removed"
+	diffChanged = "changed"
+)
+
+func dagDiff(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+	api, err := cmdenv.GetApi(env, req)
+	if err != nil {
+		return err
+	}
+
+	nodeGetter := mdag.NewSession(req.Context, api.Dag())
+	var roots [2]ipld.Node
+	for i, arg := range req.Arguments {
+		rp, err := api.ResolvePath(req.Context, path.New(arg))
+		if err != nil {
+			return err
+		}
+		if len(rp.Remainder()) > 0 {
+			return fmt.Errorf("%s: cannot diff anything other than a DAG with a root CID", arg)
+		}
+		roots[i], err = nodeGetter.Get(req.Context, rp.Cid())
+		if err != nil {
+			return err
+		}
+	}
+
+	d := &differ{
+		ctx:  req.Context,
+		ng:   nodeGetter,
+		seen: make(map[[2]cid.Cid]struct{}),
+		emit: func(change *DagDiffChange) error { return res.Emit(change) },
+	}
+	return d.diffNodes("/", roots[0], roots[1])
+}
+
+// differ walks two DAGs side by side, following the links that differ.
+type differ struct {
+	ctx     context.Context
+	ng      ipld.NodeGetter
+	seen    map[[2]cid.Cid]struct{} // pairs of blocks already compared
+	emit    func(*DagDiffChange) error
+	emitted int
+}
+
+// diffNodes reports the changes between the blocks a and b, found at p.
+func (d *differ) diffNodes(p string, a, b ipld.Node) error {
+	if a.Cid().Equals(b.Cid()) {
+		return nil
+	}
+	// in DAGs that aren't trees, the same pair of blocks can be reached from
+	// many paths: only the first one is reported
+	pair := [2]cid.Cid{a.Cid(), b.Cid()}
+	if _, ok := d.seen[pair]; ok {
+		return nil
+	}
+	d.seen[pair] = struct{}{}
+	if err := d.ctx.Err(); err != nil {
+		return err
+	}
+
+	// values of different codecs don't compare
+	if a.Cid().Type() != b.Cid().Type() {
+		return d.report(diffChanged, p, a.Cid(), b.Cid())
+	}
+
+	before := d.emitted
+	pathsA, pathsB := treePaths(a), treePaths(b)
+	innerA, innerB := innerPaths(pathsA), innerPaths(pathsB)
+
+	union := make([]string, 0, len(pathsA)+len(pathsB))
+	for tp := range pathsA {
+		union = append(union, tp)
+	}
+	for tp := range pathsB {
+		if _, ok := pathsA[tp]; !ok {
+			union = append(union, tp)
+		}
+	}
+	sort.Strings(union)
+
+	// paths that were reported as a whole, with everything under them
+	done := make(map[string]struct{})
+	for _, tp := range union {
+		if underAny(done, tp) {
+			continue
+		}
+		full := joinDiffPath(p, tp)
+
+		va, inA := resolveValue(a, tp, pathsA)
+		vb, inB := resolveValue(b, tp, pathsB)
+		switch {
+		case !inA && !inB:
+			continue
+		case !inB:
+			done[tp] = struct{}{}
+			if err := d.report(diffRemoved, full, va, nil); err != nil {
+				return err
+			}
+			continue
+		case !inA:
+			done[tp] = struct{}{}
+			if err := d.report(diffAdded, full, nil, vb); err != nil {
+				return err
+			}
+			continue
+		}
+
+		la, isLinkA := asLink(va)
+		lb, isLinkB := asLink(vb)
+		_, isInnerA := innerA[tp]
+		_, isInnerB := innerB[tp]
+		switch {
+		case isLinkA && isLinkB:
+			done[tp] = struct{}{}
+			if la.Equals(lb) {
+				// shared subtree
+				continue
+			}
+			na, err := d.ng.Get(d.ctx, la)
+			if err != nil {
+				return err
+			}
+			nb, err := d.ng.Get(d.ctx, lb)
+			if err != nil {
+				return err
+			}
+			if err := d.diffNodes(full, na, nb); err != nil {
+				return err
+			}
+		case isInnerA && isInnerB:
+			// the values under it are compared one by one
+		case isLinkA != isLinkB || isInnerA != isInnerB:
+			done[tp] = struct{}{}
+			if err := d.report(diffChanged, full, va, vb); err != nil {
+				return err
+			}
+		default:
+			if !bytes.Equal(jsonValue(va), jsonValue(vb)) {
+				if err := d.report(diffChanged, full, va, vb); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// the blocks differ in a way their paths don't show, as raw blocks
+	// or the data of dag-pb nodes do
+	if d.emitted == before {
+		return d.report(diffChanged, p, a.Cid(), b.Cid())
+	}
+	return nil
+}
+
+func (d *differ) report(typ, p string, before, after interface{}) error {
+	change := &DagDiffChange{Type: typ, Path: p}
+	if typ != diffAdded {
+		change.Before = jsonValue(before)
+	}
+	if typ != diffRemoved {
+		change.After = jsonValue(after)
+	}
+	d.emitted++
+	return d.emit(change)
+}
+
+// treePaths returns the paths inside of the block nd.
+func treePaths(nd ipld.Node) map[string]struct{} {
+	if pn, ok := nd.(*mdag.ProtoNode); ok {
+		// unnamed links, as the ones of files, can't be told apart by path:
+		// such nodes are compared as a whole
+		for _, l := range pn.Links() {
+			if l.Name == "" {
+				return nil
+			}
+		}
+	}
+	paths := make(map[string]struct{})
+	for _, tp := range nd.Tree("", -1) {
+		paths[tp] = struct{}{}
+	}
+	return paths
+}
+
+// innerPaths returns the paths of paths that have other paths under them.
+func innerPaths(paths map[string]struct{}) map[string]struct{} {
+	inner := make(map[string]struct{})
+	for tp := range paths {
+		for i := strings.LastIndexByte(tp, '/'); i > 0; i = strings.LastIndexByte(tp[:i], '/') {
+			inner[tp[:i]] = struct{}{}
+		}
+	}
+	return inner
+}
+
+// underAny tells whether tp is below one of the paths in done.
+func underAny(done map[string]struct{}, tp string) bool {
+	for i := strings.IndexByte(tp, '/'); i > 0; i = nextSlash(tp, i) {
+		if _, ok := done[tp[:i]]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func nextSlash(s string, i int) int {
+	j := strings.IndexByte(s[i+1:], '/')
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
+
+func joinDiffPath(p, tp string) string {
+	return strings.TrimSuffix(p, "/") + "/" + tp
+}
+
+// resolveValue returns the value at the path tp inside of nd, if tp is one
+// of its paths. Paths that the codec lists but can't resolve are ignored.
+func resolveValue(nd ipld.Node, tp string, paths map[string]struct{}) (interface{}, bool) {
+	if _, ok := paths[tp]; !ok {
+		return nil, false
+	}
+	v, rest, err := nd.Resolve(strings.Split(tp, "/"))
+	if err != nil || len(rest) > 0 {
+		return nil, false
+	}
+	return v, true
+}
+
+func asLink(v interface{}) (cid.Cid, bool) {
+	switch v := v.(type) {
+	case cid.Cid:
+		return v, true
+	case *ipld.Link:
+		return v.Cid, true
+	default:
+		return cid.Undef, false
+	}
+}
+
+// jsonValue encodes v as JSON, with links as {"/": "<cid>"} like dag-json.
+func jsonValue(v interface{}) json.RawMessage {
+	out, err := json.Marshal(plainValue(v))
+	if err != nil {
+		out, _ = json.Marshal(fmt.Sprintf("<%s>", err))
+	}
+	return out
+}
+
+func plainValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *ipld.Link:
+		return v.Cid
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = plainValue(val)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[k] = plainValue(val)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = plainValue(item)
+		}
+		return l
+	default:
+		return v
+	}
+}
@@ -1,54 +1,88 @@
 package dagcmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cheggaaa/pb"
+	blocks "github.com/ipfs/go-block-format"
 	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipfs/carv2"
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	ipld "github.com/ipfs/go-ipld-format"
 	mdag "github.com/ipfs/go-merkledag"
+	ipfspath "github.com/ipfs/go-path"
+	"github.com/ipfs/go-path/resolver"
+	uio "github.com/ipfs/go-unixfs/io"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	ipldprime "github.com/ipld/go-ipld-prime"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
 )
 
 func dagExport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+	api, err := cmdenv.GetApi(env, req)
+	if err != nil {
+		return err
+	}
 
-	c, err := cid.Decode(req.Arguments[0])
+	var sel ipldprime.Node
+	if s, ok := req.Options[selectorOptionName].(string); ok {
+		sel, err = parseSelector(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	rp, err := api.ResolvePath(req.Context, ipath.New(req.Arguments[0]))
 	if err != nil {
-		return fmt.Errorf(
-			"unable to parse root specification (currently only bare CIDs are supported): %s",
-			err,
-		)
+		return fmt.Errorf("unable to resolve root specification: %s", err)
 	}
+	c := rp.Cid()
 
-	api, err := cmdenv.GetApi(env, req)
+	ng := mdag.NewSession(req.Context, api.Dag())
+
+	// blocks between the root of the path and c, written before the DAG
+	// so that the path can be verified
+	proof, err := pathProof(req.Context, ng, rp)
 	if err != nil {
 		return err
 	}
 
-	// Code disabled until descent-issue in go-ipld-prime is fixed
-	// https://github.com/ribasushi/gip-muddle-up
-	//
-	// sb := gipselectorbuilder.NewSelectorSpecBuilder(gipfree.NodeBuilder())
-	// car := gocar.NewSelectiveCar(
-	// 	req.Context,
-	// 	<needs to be fixed to take format.NodeGetter as well>,
-	// 	[]gocar.Dag{gocar.Dag{
-	// 		Root: c,
-	// 		Selector: sb.ExploreRecursive(
-	// 			gipselector.RecursionLimitNone(),
-	// 			sb.ExploreAll(sb.ExploreRecursiveEdge()),
-	// 		).Node(),
-	// 	}},
-	// )
-	// ...
-	// if err := car.Write(pipeW); err != nil {}
+	progress := &exportProgress{}
+	if from, ok := req.Options[resumeFromOptionName].(string); ok {
+		if err := progress.resumeFrom(from); err != nil {
+			return err
+		}
+	}
+
+	writeCar := func(w io.Writer) error {
+		return writeCarWithProof(req.Context, ng, c, proof, sel, progress, w)
+	}
+	switch v, _ := req.Options[carVersionOptionName].(int); v {
+	case 1:
+	case 2:
+		if progress.resuming() {
+			return fmt.Errorf("--%s only works with CARv1 exports", resumeFromOptionName)
+		}
+		writeCar = asCarV2(writeCar, len(proof) > 0)
+	default:
+		return fmt.Errorf("unsupported CAR version %d: expected 1 or 2", v)
+	}
 
 	pipeR, pipeW := io.Pipe()
 
@@ -61,15 +95,7 @@ func dagExport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 			close(errCh)
 		}()
 
-		if err := gocar.WriteCar(
-			req.Context,
-			mdag.NewSession(
-				req.Context,
-				api.Dag(),
-			),
-			[]cid.Cid{c},
-			pipeW,
-		); err != nil {
+		if err := writeCar(pipeW); err != nil {
 			errCh <- err
 		}
 	}()
@@ -80,6 +106,10 @@ func dagExport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 	}
 
 	err = <-errCh
+	if err == nil {
+		err = progress.check()
+	}
+	cmdenv.SetTrailer(req.Context, exportProgressTrailer, progress.trailer(err == nil))
 
 	// minimal user friendliness
 	if err != nil &&
@@ -94,10 +124,223 @@ func dagExport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 			}
 		}
 	}
+	if err != nil && progress.Blocks > progress.From {
+		// the output may end with a partial block, and blocks that were
+		// written may not have been received: only the client knows the
+		// last block it has
+		err = fmt.Errorf("%s, the export stopped after %d blocks: cut off the partial block at the end of the output, if any, and resume it with --%s=<cid of the last complete block>", err, progress.Blocks, resumeFromOptionName)
+	}
 
 	return err
 }
 
+// exportProgressTrailer is the HTTP trailer of 'dag export' responses over
+// the HTTP API with the progress of the export, as in
+//
+//	X-Car-Progress: blocks=<n>; last=<cid>; complete=<true|false>
+const exportProgressTrailer = "X-Car-Progress"
+
+// exportProgress counts the blocks of an export, which are always in the
+// same order for a DAG, and skips the ones before the block it resumes
+// from.
+type exportProgress struct {
+	// From is the index of the first block to write, or the index of the
+	// block after the After block once it is found
+	From  int
+	After cid.Cid
+	// Blocks is the number of blocks of the export so far, written or not,
+	// and Last the last of them.
+	Blocks int
+	Last   cid.Cid
+}
+
+// resumeFrom sets the block to resume from: a block index, or the CID of
+// the last block that was received.
+func (p *exportProgress) resumeFrom(from string) error {
+	if i, err := strconv.Atoi(from); err == nil {
+		if i < 0 {
+			return fmt.Errorf("invalid --%s: negative block index %d", resumeFromOptionName, i)
+		}
+		p.From = i
+		return nil
+	}
+	c, err := cid.Decode(from)
+	if err != nil {
+		return fmt.Errorf("invalid --%s: expected a block index or a CID, got %q", resumeFromOptionName, from)
+	}
+	p.After = c
+	p.From = math.MaxInt32
+	return nil
+}
+
+func (p *exportProgress) resuming() bool {
+	return p.From > 0
+}
+
+// next counts the block c, and tells whether it is to be written.
+func (p *exportProgress) next(c cid.Cid) bool {
+	write := p.Blocks >= p.From
+	p.Blocks++
+	p.Last = c
+	if p.After.Defined() && c.Equals(p.After) {
+		p.From = p.Blocks
+	}
+	return write
+}
+
+// check returns why the export couldn't be resumed, once complete.
+func (p *exportProgress) check() error {
+	if p.After.Defined() && p.From == math.MaxInt32 {
+		return fmt.Errorf("cannot resume after block %s: it is not part of the export", p.After)
+	}
+	if p.Blocks < p.From {
+		return fmt.Errorf("cannot resume from block %d: the export only has %d blocks", p.From, p.Blocks)
+	}
+	return nil
+}
+
+func (p *exportProgress) trailer(complete bool) string {
+	last := "none"
+	if p.Last.Defined() {
+		last = p.Last.String()
+	}
+	return fmt.Sprintf("blocks=%d; last=%s; complete=%t", p.Blocks, last, complete)
+}
+
+// parseSelector parses a dag-json encoded IPLD selector.
+func parseSelector(s string) (ipldprime.Node, error) {
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagjson.Decode(nb, strings.NewReader(s)); err != nil {
+		return nil, fmt.Errorf("selector is not valid dag-json: %s", err)
+	}
+	sel := nb.Build()
+	if _, err := selector.ParseSelector(sel); err != nil {
+		return nil, fmt.Errorf("invalid selector: %s", err)
+	}
+	return sel, nil
+}
+
+// recordingNodeGetter remembers the nodes it was asked for, in order.
+type recordingNodeGetter struct {
+	ipld.NodeGetter
+	nodes []ipld.Node
+}
+
+func (r *recordingNodeGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	nd, err := r.NodeGetter.Get(ctx, c)
+	if err == nil {
+		r.nodes = append(r.nodes, nd)
+	}
+	return nd, err
+}
+
+// pathProof returns the blocks traversed to resolve rp from its root, not
+// including the block it resolves to: intermediate directories, HAMT shards,
+// and so on. Paths without segments have no proof.
+func pathProof(ctx context.Context, ng ipld.NodeGetter, rp ipath.Resolved) ([]ipld.Node, error) {
+	resolveOnce := uio.ResolveUnixfsOnce
+	if rp.Namespace() == "ipld" {
+		resolveOnce = resolver.ResolveSingle
+	}
+	rec := &recordingNodeGetter{NodeGetter: ng}
+	r := &resolver.Resolver{DAG: rec, ResolveOnce: resolveOnce}
+	if _, _, err := r.ResolveToLastNode(ctx, ipfspath.Path(rp.String())); err != nil {
+		return nil, err
+	}
+
+	proof := rec.nodes[:0]
+	for _, nd := range rec.nodes {
+		if !nd.Cid().Equals(rp.Cid()) {
+			proof = append(proof, nd)
+		}
+	}
+	return proof, nil
+}
+
+// writeCarWithProof writes a CARv1 of the DAG of root: the proof blocks
+// first, then the blocks matched by sel in traversal order, or the whole DAG
+// in depth-first order if sel is nil, as gocar.WriteCar does. Blocks are
+// written once. The roots of the header are root, preceded by the root of the
+// path when there is a proof, which starts with it. When resuming, the header
+// and the blocks before the one progress resumes from are left out.
+func writeCarWithProof(ctx context.Context, ng ipld.NodeGetter, root cid.Cid, proof []ipld.Node, sel ipldprime.Node, progress *exportProgress, w io.Writer) error {
+	roots := []cid.Cid{root}
+	if len(proof) > 0 {
+		roots = []cid.Cid{proof[0].Cid(), root}
+	}
+	if !progress.resuming() {
+		if err := gocar.WriteHeader(&gocar.CarHeader{Roots: roots, Version: 1}, w); err != nil {
+			return fmt.Errorf("failed to write car header: %s", err)
+		}
+	}
+	written := cid.NewSet()
+	write := func(c cid.Cid, data []byte) error {
+		if !written.Visit(c) || !progress.next(c) {
+			return nil
+		}
+		return carutil.LdWrite(w, c.Bytes(), data)
+	}
+	for _, nd := range proof {
+		if err := write(nd.Cid(), nd.RawData()); err != nil {
+			return err
+		}
+	}
+
+	if sel == nil {
+		// Walk visits shared subtrees once, which selectors don't
+		return mdag.Walk(ctx, func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+			nd, err := ng.Get(ctx, c)
+			if err != nil {
+				return nil, err
+			}
+			if err := write(c, nd.RawData()); err != nil {
+				return nil, err
+			}
+			return nd.Links(), nil
+		}, root, cid.NewSet().Visit)
+	}
+
+	sc := gocar.NewSelectiveCar(ctx, readStore{ctx, ng}, []gocar.Dag{{Root: root, Selector: sel}})
+	// the header was written above: only keep the blocks of the traversal
+	return sc.Write(ioutil.Discard, func(b gocar.Block) error {
+		return write(b.BlockCID, b.Data)
+	})
+}
+
+// asCarV2 turns a function writing a CARv1 file into one writing it as a
+// CARv2 file with an index, marked with the PathProof characteristic if
+// pathProof is set. The CARv1 file is buffered in a temporary file, as the
+// header of the CARv2 file depends on its size.
+func asCarV2(writeCar func(io.Writer) error, pathProof bool) func(io.Writer) error {
+	return func(w io.Writer) error {
+		tmp, err := ioutil.TempFile("", "ipfs-car-*.car")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if err := writeCar(tmp); err != nil {
+			return err
+		}
+		var h carv2.Header
+		if pathProof {
+			h.SetPathProof()
+		}
+		return carv2.WrapCharacteristics(w, tmp, h.Characteristics)
+	}
+}
+
+// readStore is the gocar.ReadStore of a NodeGetter.
+type readStore struct {
+	ctx context.Context
+	ng  ipld.NodeGetter
+}
+
+func (s readStore) Get(c cid.Cid) (blocks.Block, error) {
+	return s.ng.Get(s.ctx, c)
+}
+
 func finishCLIExport(res cmds.Response, re cmds.ResponseEmitter) error {
 
 	var showProgress bool
@@ -1,12 +1,22 @@
 package dagcmd
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"strings"
 
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/coredag"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	"github.com/ipfs/interface-go-ipfs-core/path"
 
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
 	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 )
 
 func dagGet(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
@@ -15,11 +25,31 @@ func dagGet(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) e
 		return err
 	}
 
+	codec, _ := req.Options[outputCodecOptionName].(string)
+	switch codec {
+	case "", "dag-json", "dag-cbor", "raw":
+	default:
+		return fmt.Errorf("unsupported output codec %q: expected dag-json, dag-cbor or raw", codec)
+	}
+
 	rp, err := api.ResolvePath(req.Context, path.New(req.Arguments[0]))
 	if err != nil {
 		return err
 	}
 
+	if codec != "" {
+		// decoded by go-ipld-prime, which knows more codecs than Dag().Get
+		blk, err := getBlock(req.Context, api, rp.Cid())
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := encodeValue(&buf, blk, rp.Remainder(), codec); err != nil {
+			return err
+		}
+		return res.Emit(&buf)
+	}
+
 	obj, err := api.Dag().Get(req.Context, rp.Cid())
 	if err != nil {
 		return err
@@ -36,3 +66,49 @@ func dagGet(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) e
 	}
 	return cmds.EmitOnce(res, &out)
 }
+
+// getBlock returns the block c.
+func getBlock(ctx context.Context, api coreiface.CoreAPI, c cid.Cid) (blocks.Block, error) {
+	r, err := api.Block().Get(ctx, path.IpfsPath(c))
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// encodeValue writes the value at the path rem inside of the block nd,
+// encoded with codec.
+func encodeValue(w io.Writer, nd blocks.Block, rem string, codec string) error {
+	if codec == "raw" && rem == "" {
+		// the block as it is, whatever its codec
+		_, err := w.Write(nd.RawData())
+		return err
+	}
+
+	value, err := coredag.DecodeValue(nd, rem)
+	if err != nil {
+		return err
+	}
+
+	switch codec {
+	case "dag-json":
+		if err := coredag.EncodeDagJSON(w, value); err != nil {
+			return err
+		}
+		_, err = w.Write([]byte{'\n'})
+		return err
+	case "dag-cbor":
+		return dagcbor.Encode(value, w)
+	default:
+		data, err := value.AsBytes()
+		if err != nil {
+			return fmt.Errorf("cannot output a %s as raw bytes", value.Kind())
+		}
+		_, err = w.Write(data)
+		return err
+	}
+}
@@ -1,12 +1,15 @@
 package dagcmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 
 	cid "github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	files "github.com/ipfs/go-ipfs-files"
+	"github.com/ipfs/go-ipfs/carv2"
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	ipld "github.com/ipfs/go-ipld-format"
 	iface "github.com/ipfs/interface-go-ipfs-core"
@@ -46,9 +49,10 @@ func dagImport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 	defer unlocker.Unlock()
 
 	doPinRoots, _ := req.Options[pinRootsOptionName].(bool)
+	doVerify, _ := req.Options[verifyOptionName].(bool)
 
 	retCh := make(chan importResult, 1)
-	go importWorker(req, res, api, retCh)
+	go importWorker(req, res, api, node.BaseBlocks, retCh)
 
 	done := <-retCh
 	if done.err != nil {
@@ -62,11 +66,36 @@ func dagImport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 	//
 	// The boolean value indicates whether we have encountered the root within the car file's
 	roots := done.roots
+	proofs, err := proofRoots(req.Context, node.Blockstore, done.proofs)
+	if err != nil {
+		return err
+	}
+
+	var failedPins, incomplete int
+	if doVerify && !doPinRoots {
+		for c := range roots {
+			if _, ok := proofs[c]; ok {
+				if err := res.Emit(&CarImportOutput{Root: &RootMeta{Cid: c, Proof: true}}); err != nil {
+					return err
+				}
+				continue
+			}
+			missing, err := missingBlocks(req.Context, node.Blockstore, c)
+			if err != nil {
+				return err
+			}
+			if len(missing) > 0 {
+				incomplete++
+			}
+			if err := res.Emit(&CarImportOutput{Root: &RootMeta{Cid: c, Missing: missing}}); err != nil {
+				return err
+			}
+		}
+	}
 
 	// opportunistic pinning: try whatever sticks
 	if doPinRoots {
 
-		var failedPins int
 		for c := range roots {
 
 			// We need to re-retrieve a block, convert it to ipld, and feed it
@@ -86,8 +115,27 @@ func dagImport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 			// if err := api.Pin().Add(req.Context, rp, options.Pin.Recursive(true)); err != nil {
 
 			ret := RootMeta{Cid: c}
+			if _, ok := proofs[c]; ok {
+				ret.Proof = true
+				if err := res.Emit(&CarImportOutput{Root: &ret}); err != nil {
+					return err
+				}
+				continue
+			}
 
-			if block, err := node.Blockstore.Get(c); err != nil {
+			if doVerify {
+				// pinning an incomplete DAG fails anyway, without
+				// telling which blocks are missing
+				ret.Missing, err = missingBlocks(req.Context, node.Blockstore, c)
+				if err != nil {
+					return err
+				}
+			}
+
+			if len(ret.Missing) > 0 {
+				incomplete++
+				ret.PinErrorMsg = fmt.Sprintf("incomplete DAG: %d blocks missing", len(ret.Missing))
+			} else if block, err := node.Blockstore.Get(c); err != nil {
 				ret.PinErrorMsg = err.Error()
 			} else if nd, err := ipld.Decode(block); err != nil {
 				ret.PinErrorMsg = err.Error()
@@ -101,24 +149,112 @@ func dagImport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 				failedPins++
 			}
 
-			if err := res.Emit(&CarImportOutput{Root: ret}); err != nil {
+			if err := res.Emit(&CarImportOutput{Root: &ret}); err != nil {
 				return err
 			}
 		}
+	}
+
+	if doVerify {
+		stats := &CarImportStats{BlockCount: done.blocks, SkippedCount: done.skipped}
+		if err := res.Emit(&CarImportOutput{Stats: stats}); err != nil {
+			return err
+		}
+	}
+
+	if failedPins > 0 {
+		return fmt.Errorf(
+			"unable to pin all roots: %d out of %d failed",
+			failedPins,
+			len(roots),
+		)
+	}
+	if incomplete > 0 {
+		return fmt.Errorf("%d out of %d roots are incomplete", incomplete, len(roots))
+	}
+
+	return nil
+}
+
+// missingBlocks walks the DAG of root in bs, and returns the blocks linked
+// from the DAG that are not in bs. The DAG below a missing block is unknown.
+func missingBlocks(ctx context.Context, bs blockstore.Blockstore, root cid.Cid) ([]cid.Cid, error) {
+	var missing []cid.Cid
+	err := walkLocal(ctx, bs, root, func(c cid.Cid, found bool) bool {
+		if !found {
+			missing = append(missing, c)
+		}
+		return true
+	})
+	return missing, err
+}
 
-		if failedPins > 0 {
-			return fmt.Errorf(
-				"unable to pin all roots: %d out of %d failed",
-				failedPins,
-				len(roots),
-			)
+// proofRoots returns the candidate proof roots whose DAG is incomplete in bs,
+// but leads to the root they are the proof of: they only prove the path to
+// it. The candidates are the first roots of the .car files marked as path
+// exports, which map to the second root.
+func proofRoots(ctx context.Context, bs blockstore.Blockstore, candidates map[cid.Cid]cid.Cid) (map[cid.Cid]struct{}, error) {
+	proofs := make(map[cid.Cid]struct{})
+	for root, target := range candidates {
+		var incomplete, leads bool
+		err := walkLocal(ctx, bs, root, func(c cid.Cid, found bool) bool {
+			if !found {
+				incomplete = true
+			} else if c.Equals(target) {
+				leads = true
+			}
+			return !(incomplete && leads)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if incomplete && leads {
+			proofs[root] = struct{}{}
 		}
 	}
+	return proofs, nil
+}
 
+// walkLocal walks the DAG of root in bs, calling f with each of its blocks
+// once, and whether it is in bs. The DAG below a missing block is unknown.
+// The walk stops when f returns false.
+func walkLocal(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, f func(c cid.Cid, found bool) bool) error {
+	seen := cid.NewSet()
+	stack := []cid.Cid{root}
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !seen.Visit(c) {
+			continue
+		}
+
+		block, err := bs.Get(c)
+		if err == blockstore.ErrNotFound {
+			if !f(c, false) {
+				return nil
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !f(c, true) {
+			return nil
+		}
+		nd, err := ipld.Decode(block)
+		if err != nil {
+			return err
+		}
+		for _, l := range nd.Links() {
+			stack = append(stack, l.Cid)
+		}
+	}
 	return nil
 }
 
-func importWorker(req *cmds.Request, re cmds.ResponseEmitter, api iface.CoreAPI, ret chan importResult) {
+func importWorker(req *cmds.Request, re cmds.ResponseEmitter, api iface.CoreAPI, bs blockstore.Blockstore, ret chan importResult) {
 
 	// this is *not* a transaction
 	// it is simply a way to relieve pressure on the blockstore
@@ -126,6 +262,9 @@ func importWorker(req *cmds.Request, re cmds.ResponseEmitter, api iface.CoreAPI,
 	batch := ipld.NewBatch(req.Context, api.Dag())
 
 	roots := make(map[cid.Cid]struct{})
+	proofs := make(map[cid.Cid]cid.Cid)
+	var blocks, skipped uint64
+	pathProof, _ := req.Options[pathProofOptionName].(bool)
 
 	it := req.Files.Entries()
 	for it.Next() {
@@ -145,7 +284,12 @@ func importWorker(req *cmds.Request, re cmds.ResponseEmitter, api iface.CoreAPI,
 		err := func() error {
 			defer file.Close()
 
-			car, err := gocar.NewCarReader(file)
+			// CARv2 files are imported from their CARv1 payload
+			data, h, err := carv2.DataReader(file)
+			if err != nil {
+				return err
+			}
+			car, err := gocar.NewCarReader(data)
 			if err != nil {
 				return err
 			}
@@ -158,14 +302,30 @@ func importWorker(req *cmds.Request, re cmds.ResponseEmitter, api iface.CoreAPI,
 			for _, c := range car.Header.Roots {
 				roots[c] = struct{}{}
 			}
+			// the first root of a path export only proves the path to the
+			// second one, which only CARv2 files can tell
+			if len(car.Header.Roots) == 2 && (pathProof || h != nil && h.PathProof()) {
+				proofs[car.Header.Roots[0]] = car.Header.Roots[1]
+			}
 
 			for {
 				block, err := car.Next()
 				if err != nil && err != io.EOF {
-					return err
+					return fmt.Errorf("%s: %w (the blocks read so far were kept, import the file again to resume)", it.Name(), err)
 				} else if block == nil {
 					break
 				}
+				blocks++
+
+				// resuming an import: no need to write the block again.
+				// bs only has the blocks of the repo, not the ones of the
+				// mounted CAR files, which are copied.
+				if has, err := bs.Has(block.Cid()); err != nil {
+					return err
+				} else if has {
+					skipped++
+					continue
+				}
 
 				// the double-decode is suboptimal, but we need it for batching
 				nd, err := ipld.Decode(block)
@@ -182,6 +342,9 @@ func importWorker(req *cmds.Request, re cmds.ResponseEmitter, api iface.CoreAPI,
 		}()
 
 		if err != nil {
+			// keep what was read, for the import to be resumed. The
+			// import failed anyway, ignore the error if any.
+			batch.Commit()
 			ret <- importResult{err: err}
 			return
 		}
@@ -197,5 +360,5 @@ func importWorker(req *cmds.Request, re cmds.ResponseEmitter, api iface.CoreAPI,
 		return
 	}
 
-	ret <- importResult{roots: roots}
+	ret <- importResult{roots: roots, proofs: proofs, blocks: blocks, skipped: skipped}
 }
@@ -0,0 +1,84 @@
+package dagcmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/ipfs/go-ipfs/carv2"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+)
+
+func dagIndex(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+	it := req.Files.Entries()
+	if !it.Next() {
+		if err := it.Err(); err != nil {
+			return err
+		}
+		return errors.New("expected a .car file")
+	}
+	file := files.FileFromEntry(it)
+	if file == nil {
+		return errors.New("expected a file handle")
+	}
+	defer file.Close()
+
+	data, h, err := carv2.DataReader(file)
+	if err != nil {
+		return err
+	}
+
+	if wrap, _ := req.Options[wrapOptionName].(bool); wrap {
+		// keep the characteristics when rewrapping a CARv2 file
+		var characteristics [16]byte
+		if h != nil {
+			characteristics = h.Characteristics
+		}
+		return wrapCar(res, data, characteristics)
+	}
+
+	idx, _, err := carv2.BuildIndex(data)
+	if err != nil {
+		return fmt.Errorf("unable to index car: %s", err)
+	}
+	var buf bytes.Buffer
+	if _, err := idx.WriteTo(&buf); err != nil {
+		return err
+	}
+	return res.Emit(&buf)
+}
+
+// wrapCar emits the CARv1 payload read from data as a CARv2 file with an
+// index and the given characteristics.
+func wrapCar(res cmds.ResponseEmitter, data io.Reader, characteristics [16]byte) error {
+	tmp, err := ioutil.TempFile("", "ipfs-car-*.car")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, data); err != nil {
+		return err
+	}
+
+	pipeR, pipeW := io.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		err := carv2.WrapCharacteristics(pipeW, tmp, characteristics)
+		pipeW.CloseWithError(err)
+		errCh <- err
+	}()
+
+	if err := res.Emit(pipeR); err != nil {
+		pipeR.Close() // ignore the error if any
+		return err
+	}
+	// tmp is only removed once it was streamed out
+	return <-errCh
+}
@@ -0,0 +1,146 @@
+package dagcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/ipfs/go-ipfs/blocks/carstore"
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+
+	"github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+)
+
+func getCarMounts(env cmds.Environment) (*carstore.Store, error) {
+	n, err := cmdenv.GetNode(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.CarMounts == nil {
+		return nil, errors.New("CAR files can't be mounted in this repo")
+	}
+	return n.CarMounts, nil
+}
+
+// absCarPath makes the path argument absolute on the client, as the daemon
+// may run from another directory.
+func absCarPath(req *cmds.Request, env cmds.Environment) error {
+	abs, err := filepath.Abs(req.Arguments[0])
+	if err != nil {
+		return err
+	}
+	req.Arguments[0] = abs
+	return nil
+}
+
+func dagMountCar(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+	cars, err := getCarMounts(env)
+	if err != nil {
+		return err
+	}
+	m, err := cars.Mount(req.Arguments[0])
+	if err != nil {
+		return err
+	}
+	return cmds.EmitOnce(res, &CarMountOutput{Path: m.Path, Blocks: m.Blocks})
+}
+
+func dagUnmountCar(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+	n, err := cmdenv.GetNode(env)
+	if err != nil {
+		return err
+	}
+	cars, err := getCarMounts(env)
+	if err != nil {
+		return err
+	}
+	path := req.Arguments[0]
+
+	// no pins are added until the file is unmounted
+	defer n.Blockstore.PinLock().Unlock()
+
+	pinned, err := pinNeedingMount(req.Context, n, cars, path)
+	if err != nil {
+		return err
+	}
+	if pinned.Defined() {
+		return fmt.Errorf("cannot unmount %s: the pin %s has blocks that are only in it. Copy them into the repo with 'ipfs dag import %s', or remove the pin, first", path, pinned, path)
+	}
+	return cars.Unmount(path)
+}
+
+// pinNeedingMount returns a recursive or direct pin with blocks that are
+// only in the mounted file at path, or cid.Undef if there is none. The
+// DAGs of the recursive pins are walked as far as they are local.
+func pinNeedingMount(ctx context.Context, n *core.IpfsNode, cars *carstore.Store, path string) (cid.Cid, error) {
+	direct, err := n.Pinning.DirectKeys(ctx)
+	if err != nil {
+		return cid.Undef, err
+	}
+	for _, c := range direct {
+		only, err := cars.OnlyIn(path, c)
+		if err != nil || only {
+			return c, err
+		}
+	}
+
+	recursive, err := n.Pinning.RecursiveKeys(ctx)
+	if err != nil {
+		return cid.Undef, err
+	}
+	ng := merkledag.NewDAGService(blockservice.New(n.Blockstore, offline.Exchange(n.Blockstore)))
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		links, err := ipld.GetLinks(ctx, ng, c)
+		if err == ipld.ErrNotFound {
+			// missing blocks aren't lost by unmounting
+			return nil, nil
+		}
+		return links, err
+	}
+	visited := cid.NewSet()
+	for _, root := range recursive {
+		var found bool
+		var walkErr error
+		err := merkledag.Walk(ctx, getLinks, root, func(c cid.Cid) bool {
+			if found || walkErr != nil || !visited.Visit(c) {
+				return false
+			}
+			found, walkErr = cars.OnlyIn(path, c)
+			return !found && walkErr == nil
+		})
+		if walkErr != nil {
+			err = walkErr
+		}
+		if err != nil {
+			return cid.Undef, err
+		}
+		if found {
+			return root, nil
+		}
+	}
+	return cid.Undef, nil
+}
+
+func dagMountedCars(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+	cars, err := getCarMounts(env)
+	if err != nil {
+		return err
+	}
+	for _, m := range cars.Mounts() {
+		out := &CarMountOutput{Path: m.Path, Blocks: m.Blocks}
+		if m.Err != nil {
+			out.Error = m.Err.Error()
+		}
+		if err := res.Emit(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,12 +1,20 @@
 package dagcmd
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"strconv"
+	"strings"
 
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	"github.com/ipfs/go-ipfs/core/coredag"
 
+	cid "github.com/ipfs/go-cid"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	files "github.com/ipfs/go-ipfs-files"
 	ipld "github.com/ipfs/go-ipld-format"
@@ -23,6 +31,11 @@ func dagPut(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) e
 	format, _ := req.Options["format"].(string)
 	hash, _ := req.Options["hash"].(string)
 	dopin, _ := req.Options["pin"].(bool)
+	batch, _ := req.Options[batchOptionName].(bool)
+
+	if batch && ienc != "json" && ienc != "dag-json" {
+		return fmt.Errorf("--%s requires --input-enc=json or dag-json", batchOptionName)
+	}
 
 	// mhType tells inputParser which hash should be used. MaxUint64 means 'use
 	// default hash' (sha256 for cbor, sha1 for git..)
@@ -36,18 +49,35 @@ func dagPut(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) e
 		}
 	}
 
+	validate, err := schemaValidator(req, env, api)
+	if err != nil {
+		return err
+	}
+
 	var adder ipld.NodeAdder = api.Dag()
 	if dopin {
 		adder = api.Dag().Pinning()
 	}
 	b := ipld.NewBatch(req.Context, adder)
 
+	labels := &batchLabels{cids: make(map[string]cid.Cid)}
+	// the CIDs are emitted once the objects are written
+	var outputs []*OutputObject
+
 	it := req.Files.Entries()
 	for it.Next() {
 		file := files.FileFromEntry(it)
 		if file == nil {
 			return fmt.Errorf("expected a regular file")
 		}
+		if batch {
+			out, err := putBatch(req, b, file, ienc, format, mhType, labels, validate)
+			if err != nil {
+				return err
+			}
+			outputs = append(outputs, out...)
+			continue
+		}
 		nds, err := coredag.ParseInputs(ienc, format, file, mhType, -1)
 		if err != nil {
 			return err
@@ -55,6 +85,11 @@ func dagPut(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) e
 		if len(nds) == 0 {
 			return fmt.Errorf("no node returned from ParseInputs")
 		}
+		if validate != nil {
+			if err := validate(nds[0]); err != nil {
+				return err
+			}
+		}
 
 		for _, nd := range nds {
 			err := b.Add(req.Context, nd)
@@ -63,10 +98,7 @@ func dagPut(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) e
 			}
 		}
 
-		cid := nds[0].Cid()
-		if err := res.Emit(&OutputObject{Cid: cid}); err != nil {
-			return err
-		}
+		outputs = append(outputs, &OutputObject{Cid: nds[0].Cid()})
 	}
 	if it.Err() != nil {
 		return it.Err()
@@ -76,5 +108,138 @@ func dagPut(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) e
 		return err
 	}
 
+	for _, out := range outputs {
+		if err := res.Emit(out); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// batchRecord is a line of the input of 'dag put --batch'.
+type batchRecord struct {
+	Label  string          `json:"label"`
+	Object json.RawMessage `json:"object"`
+}
+
+// batchLabels are the labels of the objects of a batch, shared by all the
+// files. Records without a label are labeled with their line number, counting
+// the lines of all the files in order, so that they stay unique.
+type batchLabels struct {
+	cids  map[string]cid.Cid
+	lines int // lines of the previous files
+}
+
+// putBatch adds the objects of the newline-delimited records read from r, and
+// returns their CIDs. Links of the form {"/": "@<label>"} point to the
+// objects of earlier records. Objects are checked with validate, unless it is
+// nil.
+func putBatch(req *cmds.Request, b *ipld.Batch, r io.Reader, ienc, format string, mhType uint64, labels *batchLabels, validate func(ipld.Node) error) ([]*OutputObject, error) {
+	var outputs []*OutputObject
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			c, label, perr := putRecord(req, b, data, ienc, format, mhType, labels.cids, strconv.Itoa(labels.lines+line), validate)
+			if perr != nil {
+				return nil, fmt.Errorf("line %d: %w", line, perr)
+			}
+			labels.cids[label] = c
+			outputs = append(outputs, &OutputObject{Cid: c, Label: label})
+		}
+		if err == io.EOF {
+			if len(data) == 0 {
+				line--
+			}
+			labels.lines += line
+			return outputs, nil
+		}
+	}
+}
+
+func putRecord(req *cmds.Request, b *ipld.Batch, data []byte, ienc, format string, mhType uint64, labels map[string]cid.Cid, defaultLabel string, validate func(ipld.Node) error) (cid.Cid, string, error) {
+	var rec batchRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return cid.Undef, "", err
+	}
+	if len(rec.Object) == 0 {
+		return cid.Undef, "", errors.New("record has no object")
+	}
+	if rec.Label == "" {
+		rec.Label = defaultLabel
+	}
+	if _, ok := labels[rec.Label]; ok {
+		return cid.Undef, "", fmt.Errorf("label %q is already used", rec.Label)
+	}
+
+	obj := []byte(rec.Object)
+	// most objects have no labeled links: skip the second decoding then
+	if bytes.Contains(obj, []byte(`"@`)) {
+		dec := json.NewDecoder(bytes.NewReader(obj))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return cid.Undef, "", err
+		}
+		v, err := resolveLabels(v, labels)
+		if err != nil {
+			return cid.Undef, "", err
+		}
+		if obj, err = json.Marshal(v); err != nil {
+			return cid.Undef, "", err
+		}
+	}
+
+	nds, err := coredag.ParseInputs(ienc, format, bytes.NewReader(obj), mhType, -1)
+	if err != nil {
+		return cid.Undef, "", err
+	}
+	if len(nds) == 0 {
+		return cid.Undef, "", fmt.Errorf("no node returned from ParseInputs")
+	}
+	if validate != nil {
+		if err := validate(nds[0]); err != nil {
+			return cid.Undef, "", err
+		}
+	}
+	for _, nd := range nds {
+		if err := b.Add(req.Context, nd); err != nil {
+			return cid.Undef, "", err
+		}
+	}
+	return nds[0].Cid(), rec.Label, nil
+}
+
+// resolveLabels replaces the links to labels in v by links to the CIDs of
+// the labeled objects.
+func resolveLabels(v interface{}, labels map[string]cid.Cid) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if target, ok := v["/"].(string); ok && len(v) == 1 && strings.HasPrefix(target, "@") {
+			c, ok := labels[target[1:]]
+			if !ok {
+				return nil, fmt.Errorf("link to unknown label %q: objects can only link to earlier ones", target[1:])
+			}
+			return map[string]interface{}{"/": c.String()}, nil
+		}
+		for k, val := range v {
+			val, err := resolveLabels(val, labels)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = val
+		}
+	case []interface{}:
+		for i, val := range v {
+			val, err := resolveLabels(val, labels)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = val
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,122 @@
+package dagcmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/coredag"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/path"
+
+	cid "github.com/ipfs/go-cid"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	ipld "github.com/ipfs/go-ipld-format"
+	ipldprime "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+)
+
+// schemasDir is the directory of the repo with the named schemas of
+// 'ipfs dag put --schema', one <name>.json file for each.
+const schemasDir = "schemas"
+
+// schemaValidator returns the function that checks the objects given to
+// 'ipfs dag put' against the schema of the --schema option, or nil if there
+// is none.
+func schemaValidator(req *cmds.Request, env cmds.Environment, api coreiface.CoreAPI) (func(ipld.Node) error, error) {
+	ref, _ := req.Options[schemaOptionName].(string)
+	typeName, _ := req.Options[schemaTypeOptionName].(string)
+	if ref == "" {
+		if typeName != "" {
+			return nil, fmt.Errorf("--%s requires --%s", schemaTypeOptionName, schemaOptionName)
+		}
+		return nil, nil
+	}
+
+	schema, err := loadSchema(req, env, api, ref)
+	if err != nil {
+		return nil, fmt.Errorf("schema %s: %w", ref, err)
+	}
+	if typeName == "" {
+		names := schema.TypeNames()
+		if len(names) != 1 {
+			return nil, fmt.Errorf("schema %s has %d types, choose one with --%s: %s", ref, len(names), schemaTypeOptionName, strings.Join(names, ", "))
+		}
+		typeName = names[0]
+	}
+	if !schema.HasType(typeName) {
+		return nil, fmt.Errorf("schema %s has no type %s", ref, typeName)
+	}
+
+	ienc, _ := req.Options["input-enc"].(string)
+	return func(nd ipld.Node) error {
+		value, err := coredag.DecodeValue(nd, "")
+		if err != nil {
+			return err
+		}
+		if err := schema.Validate(value, typeName); err != nil {
+			if ienc == "json" && intsAsFloats(err) {
+				return fmt.Errorf("object does not conform to %s: %w (--input-enc=json reads all numbers as floats: use --input-enc=dag-json for Int values)", typeName, err)
+			}
+			return fmt.Errorf("object does not conform to %s: %w", typeName, err)
+		}
+		return nil
+	}, nil
+}
+
+// intsAsFloats tells whether some of the values that err is about are
+// floats where ints are expected.
+func intsAsFloats(err error) bool {
+	var errs coredag.ValidationErrors
+	if !errors.As(err, &errs) {
+		return false
+	}
+	for _, e := range errs {
+		if e.Expected == ipldprime.Kind_Int && e.Got == ipldprime.Kind_Float {
+			return true
+		}
+	}
+	return false
+}
+
+// loadSchema reads a schema from the repo if ref is a name, or from the
+// object at the IPFS path ref otherwise.
+func loadSchema(req *cmds.Request, env cmds.Environment, api coreiface.CoreAPI, ref string) (*coredag.Schema, error) {
+	if _, err := cid.Decode(ref); err != nil && !strings.Contains(ref, "/") {
+		cfgRoot, err := cmdenv.GetConfigRoot(env)
+		if err != nil {
+			return nil, err
+		}
+		file := filepath.Join(cfgRoot, schemasDir, ref+".json")
+		data, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no schema named %s: %s does not exist", ref, file)
+		} else if err != nil {
+			return nil, err
+		}
+		return coredag.LoadSchema(data)
+	}
+
+	rp, err := api.ResolvePath(req.Context, path.New(ref))
+	if err != nil {
+		return nil, err
+	}
+	nd, err := api.Dag().Get(req.Context, rp.Cid())
+	if err != nil {
+		return nil, err
+	}
+	value, err := coredag.DecodeValue(nd, rp.Remainder())
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := dagjson.Encode(value, &buf); err != nil {
+		return nil, err
+	}
+	return coredag.LoadSchema(buf.Bytes())
+}
@@ -3,24 +3,36 @@ package dagcmd
 import (
 	"fmt"
 	"io"
+	"math"
 	"os"
 
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	"github.com/ipfs/go-ipfs/core/commands/e"
-	"github.com/ipfs/go-merkledag/traverse"
+	"github.com/ipfs/go-ipfs/core/coredag"
+	"github.com/ipfs/interface-go-ipfs-core/options"
 	"github.com/ipfs/interface-go-ipfs-core/path"
 
+	cid "github.com/ipfs/go-cid"
 	cmds "github.com/ipfs/go-ipfs-cmds"
+	ipld "github.com/ipfs/go-ipld-format"
 	mdag "github.com/ipfs/go-merkledag"
+	mh "github.com/multiformats/go-multihash"
 )
 
 func dagStat(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 	progressive := req.Options[progressOptionName].(bool)
+	localOnly, _ := req.Options[localOnlyOptionName].(bool)
 
 	api, err := cmdenv.GetApi(env, req)
 	if err != nil {
 		return err
 	}
+	if localOnly {
+		api, err = api.WithOptions(options.Api.Offline(true))
+		if err != nil {
+			return err
+		}
+	}
 
 	rp, err := api.ResolvePath(req.Context, path.New(req.Arguments[0]))
 	if err != nil {
@@ -31,41 +43,134 @@ func dagStat(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment)
 		return fmt.Errorf("cannot return size for anything other than a DAG with a root CID")
 	}
 
-	nodeGetter := mdag.NewSession(req.Context, api.Dag())
-	obj, err := nodeGetter.Get(req.Context, rp.Cid())
-	if err != nil {
-		return err
+	s := &statWalker{
+		ng:        mdag.NewSession(req.Context, api.Dag()),
+		localOnly: localOnly,
+		visited:   make(map[cid.Cid]*statBlock),
+		stats: &DagStat{
+			Codecs:      make(map[string]*DagStatCount),
+			Multihashes: make(map[string]*DagStatCount),
+		},
+	}
+	if progressive {
+		s.progress = func() error {
+			return res.Emit(&DagStat{Size: s.stats.Size, NumBlocks: s.stats.NumBlocks})
+		}
 	}
 
-	dagstats := &DagStat{}
-	err = traverse.Traverse(obj, traverse.Options{
-		DAG:   nodeGetter,
-		Order: traverse.DFSPre,
-		Func: func(current traverse.State) error {
-			dagstats.Size += uint64(len(current.Node.RawData()))
-			dagstats.NumBlocks++
-
-			if progressive {
-				if err := res.Emit(dagstats); err != nil {
-					return err
-				}
-			}
-			return nil
-		},
-		ErrFunc:        nil,
-		SkipDuplicates: true,
-	})
+	root, err := s.walk(req, rp.Cid(), 0)
 	if err != nil {
 		return fmt.Errorf("error traversing DAG: %w", err)
 	}
+	s.stats.TotalSize = root.totalSize
+	s.stats.MaxDepth = root.depth
 
-	if !progressive {
-		if err := res.Emit(dagstats); err != nil {
-			return err
+	return res.Emit(s.stats)
+}
+
+// statBlock is what the walk remembers of a block, for the blocks that link
+// to it again.
+type statBlock struct {
+	// totalSize is the size of the DAG under the block, counting
+	// duplicates as many times as they are linked to.
+	totalSize uint64
+	// depth is the number of links on the longest path under the block.
+	depth int
+}
+
+type statWalker struct {
+	ng        ipld.NodeGetter
+	localOnly bool
+	visited   map[cid.Cid]*statBlock
+	stats     *DagStat
+	progress  func() error
+}
+
+// walk visits the DAG under c, each block once.
+func (s *statWalker) walk(req *cmds.Request, c cid.Cid, linkSize uint64) (*statBlock, error) {
+	if b, ok := s.visited[c]; ok {
+		return b, nil
+	}
+
+	nd, err := s.ng.Get(req.Context, c)
+	if s.localOnly && err == ipld.ErrNotFound {
+		s.stats.MissingBlocks++
+		s.stats.MissingSize += linkSize
+		b := &statBlock{}
+		s.visited[c] = b
+		return b, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	size := uint64(len(nd.RawData()))
+	s.stats.Size += size
+	s.stats.NumBlocks++
+	s.stats.count(nd.Cid(), size)
+	if s.progress != nil {
+		if err := s.progress(); err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	b := &statBlock{totalSize: size}
+	for _, l := range nd.Links() {
+		child, err := s.walk(req, l.Cid, l.Size)
+		if err != nil {
+			return nil, err
+		}
+		b.totalSize = addSaturating(b.totalSize, child.totalSize)
+		if child.depth+1 > b.depth {
+			b.depth = child.depth + 1
+		}
+	}
+	s.visited[c] = b
+	return b, nil
+}
+
+func (s *DagStat) count(c cid.Cid, size uint64) {
+	prefix := c.Prefix()
+	for _, kv := range []struct {
+		m   map[string]*DagStatCount
+		key string
+	}{
+		{s.Codecs, codecName(prefix.Codec)},
+		{s.Multihashes, multihashName(prefix.MhType)},
+	} {
+		count, ok := kv.m[kv.key]
+		if !ok {
+			count = &DagStatCount{}
+			kv.m[kv.key] = count
+		}
+		count.Blocks++
+		count.Size += size
+	}
+}
+
+func codecName(code uint64) string {
+	if name, ok := cid.CodecToStr[code]; ok {
+		return name
+	}
+	if code == coredag.DagJSON {
+		return "dag-json"
+	}
+	return fmt.Sprintf("0x%x", code)
+}
+
+func multihashName(code uint64) string {
+	if name, ok := mh.Codes[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", code)
+}
+
+// addSaturating adds sizes that can overflow in DAGs where the same
+// subtrees are linked to over and over.
+func addSaturating(a, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+	return a + b
 }
 
 func finishCLIStat(res cmds.Response, re cmds.ResponseEmitter) error {
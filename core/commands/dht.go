@@ -277,6 +277,13 @@ var provideRefDhtCmd = &cmds.Command{
 			if err != nil {
 				return err
 			}
+			if !has && nd.CarMounts != nil {
+				// blocks of the mounted CAR files can be provided too
+				has, err = nd.CarMounts.HasMounted(c)
+				if err != nil {
+					return err
+				}
+			}
 
 			if !has {
 				return fmt.Errorf("block %s not found locally, cannot provide", c)
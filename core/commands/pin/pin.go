@@ -22,6 +22,8 @@ import (
 	core "github.com/ipfs/go-ipfs/core"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
+	"github.com/ipfs/go-ipfs/core/coreapi"
+	"github.com/ipfs/go-ipfs/pinmeta"
 )
 
 var PinCmd = &cmds.Command{
@@ -35,6 +37,7 @@ var PinCmd = &cmds.Command{
 		"ls":     listPinCmd,
 		"verify": verifyPinCmd,
 		"update": updatePinCmd,
+		"status": statusPinCmd,
 		"remote": remotePinCmd,
 	},
 }
@@ -51,12 +54,36 @@ type AddPinOutput struct {
 const (
 	pinRecursiveOptionName = "recursive"
 	pinProgressOptionName  = "progress"
+	pinMetaOptionName      = "meta"
+	pinExpiresOptionName   = "expires"
 )
 
 var addPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline:          "Pin objects to local storage.",
 		ShortDescription: "Stores an IPFS object(s) from a given path locally to disk.",
+		LongDescription: `
+Stores an IPFS object(s) from a given path locally to disk.
+
+Pins can be given a name with --name, and key/value metadata with one --meta
+key=value option for each pair, to tell them apart later with the filters of
+'ipfs pin ls'. Pinning an object again replaces its name and metadata.
+
+With --expires, the pins are removed automatically once the given duration
+(like 72h) has passed, or at the given RFC 3339 time (like
+2021-12-31T23:59:59Z). Expired pins are removed by the daemon, every minute
+and when it starts.
+
+With --background, the pins are added to the local pin queue and the command
+returns immediately. The daemon then fetches and pins them, retrying failed
+attempts, even across restarts. Use 'ipfs pin status' to follow them.
+
+Example:
+	$ ipfs pin add --name=nightly-backup --meta=job=backup --expires=168h <cid>
+	pinned <cid> recursively
+	$ ipfs pin add --background <cid>
+	queued <cid> recursively
+`,
 	},
 
 	Arguments: []cmds.Argument{
@@ -65,6 +92,10 @@ var addPinCmd = &cmds.Command{
 	Options: []cmds.Option{
 		cmds.BoolOption(pinRecursiveOptionName, "r", "Recursively pin the object linked to by the specified object(s).").WithDefault(true),
 		cmds.BoolOption(pinProgressOptionName, "Show progress"),
+		cmds.StringOption(pinNameOptionName, "An optional name for the pin(s)."),
+		cmds.StringsOption(pinMetaOptionName, "Metadata of the pin(s), as key=value. Can be given several times."),
+		cmds.StringOption(pinExpiresOptionName, "Remove the pin(s) after this duration (like 72h) or at this RFC 3339 time."),
+		cmds.BoolOption(pinBackgroundOptionName, "Add to the local pin queue and return immediately, see 'ipfs pin status'."),
 	},
 	Type: AddPinOutput{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
@@ -76,6 +107,12 @@ var addPinCmd = &cmds.Command{
 		// set recursive flag
 		recursive, _ := req.Options[pinRecursiveOptionName].(bool)
 		showProgress, _ := req.Options[pinProgressOptionName].(bool)
+		background, _ := req.Options[pinBackgroundOptionName].(bool)
+
+		info, err := pinInfoFromOptions(req)
+		if err != nil {
+			return err
+		}
 
 		if err := req.ParseBodyArgs(); err != nil {
 			return err
@@ -86,8 +123,20 @@ var addPinCmd = &cmds.Command{
 			return err
 		}
 
+		if background {
+			if showProgress {
+				return fmt.Errorf("--%s and --%s cannot be used together, see 'ipfs pin status'", pinBackgroundOptionName, pinProgressOptionName)
+			}
+			queued, err := pinQueueMany(req.Context, api, enc, req.Arguments, recursive, info)
+			if err != nil {
+				return err
+			}
+
+			return cmds.EmitOnce(res, &AddPinOutput{Pins: queued})
+		}
+
 		if !showProgress {
-			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive, info)
 			if err != nil {
 				return err
 			}
@@ -105,7 +154,7 @@ var addPinCmd = &cmds.Command{
 
 		ch := make(chan pinResult, 1)
 		go func() {
-			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive, info)
 			ch <- pinResult{pins: added, err: err}
 		}()
 
@@ -145,8 +194,13 @@ var addPinCmd = &cmds.Command{
 				pintype = "directly"
 			}
 
+			verb := "pinned"
+			if background, _ := req.Options[pinBackgroundOptionName].(bool); background {
+				verb = "queued"
+			}
+
 			for _, k := range out.Pins {
-				fmt.Fprintf(w, "pinned %s %s\n", k, pintype)
+				fmt.Fprintf(w, "%s %s %s\n", verb, k, pintype)
 			}
 
 			return nil
@@ -181,7 +235,15 @@ var addPinCmd = &cmds.Command{
 	},
 }
 
-func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool) ([]string, error) {
+func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool, info *pinmeta.Info) ([]string, error) {
+	var pins coreapi.PinInfoAPI
+	if info != nil {
+		var err error
+		if pins, err = pinInfoAPI(api); err != nil {
+			return nil, err
+		}
+	}
+
 	added := make([]string, len(paths))
 	for i, b := range paths {
 		rp, err := api.ResolvePath(ctx, path.New(b))
@@ -189,7 +251,12 @@ func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder,
 			return nil, err
 		}
 
-		if err := api.Pin().Add(ctx, rp, options.Pin.Recursive(recursive)); err != nil {
+		if info != nil {
+			err = pins.AddWithInfo(ctx, rp, info, options.Pin.Recursive(recursive))
+		} else {
+			err = api.Pin().Add(ctx, rp, options.Pin.Recursive(recursive))
+		}
+		if err != nil {
 			return nil, err
 		}
 		added[i] = enc.Encode(rp.Cid())
@@ -198,6 +265,24 @@ func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder,
 	return added, nil
 }
 
+func pinQueueMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool, info *pinmeta.Info) ([]string, error) {
+	pins, err := pinQueueAPI(api)
+	if err != nil {
+		return nil, err
+	}
+
+	queued := make([]string, len(paths))
+	for i, b := range paths {
+		job, err := pins.Queue(ctx, path.New(b), info, options.Pin.Recursive(recursive))
+		if err != nil {
+			return nil, err
+		}
+		queued[i] = enc.Encode(job.Cid)
+	}
+
+	return queued, nil
+}
+
 var rmPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Remove pinned objects from local storage.",
@@ -300,6 +385,17 @@ With arguments, the command fails if any of the arguments is not a pinned
 object. And if --type=<type> is additionally used, the command will also fail
 if any of the arguments is not of the specified type.
 
+Use --name=<name> and --meta=<key>=<value> to only list the pins with that
+name and metadata, as given to 'ipfs pin add'. All the --meta pairs must
+match. Only direct and recursive pins have names and metadata, which are
+listed after the type, with their expiry time, in the JSON output; the text
+output shows the names.
+
+Use --status=<status>[,<status>...] to list the pins added with
+'ipfs pin add --background' that have one of the given statuses, among
+queued, pinning, pinned and failed, instead of the pins of the node. The
+status is listed after the type. See 'ipfs pin status' for more details.
+
 Example:
 	$ echo "hello" | ipfs add -q
 	QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN
@@ -324,6 +420,9 @@ Example:
 		cmds.StringOption(pinTypeOptionName, "t", "The type of pinned keys to list. Can be \"direct\", \"indirect\", \"recursive\", or \"all\".").WithDefault("all"),
 		cmds.BoolOption(pinQuietOptionName, "q", "Write just hashes of objects."),
 		cmds.BoolOption(pinStreamOptionName, "s", "Enable streaming of pins as they are discovered."),
+		cmds.StringOption(pinNameOptionName, "Only list the pins with this name."),
+		cmds.StringsOption(pinMetaOptionName, "Only list the pins with this metadata, as key=value. Can be given several times."),
+		cmds.DelimitedStringsOption(",", pinStatusOptionName, "List the pins of the pin queue with the specified statuses (queued,pinning,pinned,failed)."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env, req)
@@ -333,6 +432,7 @@ Example:
 
 		typeStr, _ := req.Options[pinTypeOptionName].(string)
 		stream, _ := req.Options[pinStreamOptionName].(bool)
+		statuses, _ := req.Options[pinStatusOptionName].([]string)
 
 		switch typeStr {
 		case "all", "direct", "indirect", "recursive":
@@ -341,21 +441,28 @@ Example:
 			return err
 		}
 
+		filter, err := pinFilterFromOptions(req)
+		if err != nil {
+			return err
+		}
+
 		// For backward compatibility, we accumulate the pins in the same output type as before.
 		emit := res.Emit
 		lgcList := map[string]PinLsType{}
 		if !stream {
 			emit = func(v interface{}) error {
 				obj := v.(*PinLsOutputWrapper)
-				lgcList[obj.PinLsObject.Cid] = PinLsType{Type: obj.PinLsObject.Type}
+				lgcList[obj.PinLsObject.Cid] = obj.PinLsObject.lsType()
 				return nil
 			}
 		}
 
-		if len(req.Arguments) > 0 {
-			err = pinLsKeys(req, typeStr, api, emit)
+		if len(statuses) > 0 {
+			err = pinLsQueue(req, typeStr, statuses, filter, api, emit)
+		} else if len(req.Arguments) > 0 {
+			err = pinLsKeys(req, typeStr, filter, api, emit)
 		} else {
-			err = pinLsAll(req, typeStr, api, emit)
+			err = pinLsAll(req, typeStr, filter, api, emit)
 		}
 		if err != nil {
 			return err
@@ -390,7 +497,7 @@ Example:
 				if quiet {
 					fmt.Fprintf(w, "%s\n", out.PinLsObject.Cid)
 				} else {
-					fmt.Fprintf(w, "%s %s\n", out.PinLsObject.Cid, out.PinLsObject.Type)
+					formatPinLs(w, out.PinLsObject.Cid, out.PinLsObject.lsType())
 				}
 				return nil
 			}
@@ -399,7 +506,7 @@ Example:
 				if quiet {
 					fmt.Fprintf(w, "%s\n", k)
 				} else {
-					fmt.Fprintf(w, "%s %s\n", k, v.Type)
+					formatPinLs(w, k, v)
 				}
 			}
 
@@ -421,18 +528,61 @@ type PinLsList struct {
 	Keys map[string]PinLsType
 }
 
-// PinLsType contains the type of a pin
+// PinLsType contains the type of a pin, its status in the pin queue when
+// listed with --status, and its name, metadata and expiry time if it has any
 type PinLsType struct {
-	Type string
+	Type    string
+	Status  string            `json:",omitempty"`
+	Name    string            `json:",omitempty"`
+	Meta    map[string]string `json:",omitempty"`
+	Expires *time.Time        `json:",omitempty"`
 }
 
 // PinLsObject contains the description of a pin
 type PinLsObject struct {
-	Cid  string `json:",omitempty"`
-	Type string `json:",omitempty"`
+	Cid     string            `json:",omitempty"`
+	Type    string            `json:",omitempty"`
+	Status  string            `json:",omitempty"`
+	Name    string            `json:",omitempty"`
+	Meta    map[string]string `json:",omitempty"`
+	Expires *time.Time        `json:",omitempty"`
+}
+
+func (o PinLsObject) lsType() PinLsType {
+	return PinLsType{
+		Type:    o.Type,
+		Status:  o.Status,
+		Name:    o.Name,
+		Meta:    o.Meta,
+		Expires: o.Expires,
+	}
 }
 
-func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit func(value interface{}) error) error {
+// formatPinLs writes a pin in the text output of pin ls.
+func formatPinLs(w io.Writer, c string, t PinLsType) {
+	fmt.Fprintf(w, "%s %s", c, t.Type)
+	if t.Status != "" {
+		fmt.Fprintf(w, " %s", t.Status)
+	}
+	if t.Name != "" {
+		fmt.Fprintf(w, " %s", t.Name)
+	}
+	fmt.Fprintln(w)
+}
+
+// newPinLsObject returns the description of a pin, with its record if it
+// has one.
+func newPinLsObject(c, pinType string, info *pinmeta.Info) PinLsObject {
+	obj := PinLsObject{Cid: c, Type: pinType}
+	if info != nil {
+		obj.Name = info.Name
+		obj.Meta = info.Meta
+		obj.Expires = info.Expires
+	}
+	return obj
+}
+
+func pinLsKeys(req *cmds.Request, typeStr string, filter pinmeta.Filter, api coreiface.CoreAPI, emit func(value interface{}) error) error {
 	enc, err := cmdenv.GetCidEncoder(req)
 	if err != nil {
 		return err
@@ -449,6 +599,8 @@ func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fu
 		panic("unhandled pin type")
 	}
 
+	pins, _ := api.Pin().(coreapi.PinInfoAPI)
+
 	for _, p := range req.Arguments {
 		rp, err := api.ResolvePath(req.Context, path.New(p))
 		if err != nil {
@@ -464,17 +616,26 @@ func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fu
 			return fmt.Errorf("path '%s' is not pinned", p)
 		}
 
+		var info *pinmeta.Info
 		switch pinType {
-		case "direct", "indirect", "recursive", "internal":
+		case "direct", "recursive":
+			if pins == nil {
+				break
+			}
+			if info, err = pins.Info(req.Context, rp); err != nil {
+				return err
+			}
+		case "indirect", "internal":
 		default:
 			pinType = "indirect through " + pinType
 		}
 
+		if !filter.Match(info) {
+			return fmt.Errorf("path '%s' is not pinned with the given name and metadata", p)
+		}
+
 		err = emit(&PinLsOutputWrapper{
-			PinLsObject: PinLsObject{
-				Type: pinType,
-				Cid:  enc.Encode(rp.Cid()),
-			},
+			PinLsObject: newPinLsObject(enc.Encode(rp.Cid()), pinType, info),
 		})
 		if err != nil {
 			return err
@@ -484,7 +645,7 @@ func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fu
 	return nil
 }
 
-func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit func(value interface{}) error) error {
+func pinLsAll(req *cmds.Request, typeStr string, filter pinmeta.Filter, api coreiface.CoreAPI, emit func(value interface{}) error) error {
 	enc, err := cmdenv.GetCidEncoder(req)
 	if err != nil {
 		return err
@@ -497,29 +658,47 @@ func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fun
 		return err
 	}
 
-	opt, err := options.Pin.Ls.Type(typeStr)
-	if err != nil {
-		panic("unhandled pin type")
-	}
-
-	pins, err := api.Pin().Ls(req.Context, opt)
-	if err != nil {
-		return err
+	types := []string{typeStr}
+	if !filter.Empty() {
+		// only direct and recursive pins have names and metadata, so
+		// there is no need to walk the DAGs for the indirect ones
+		switch typeStr {
+		case "all":
+			types = []string{"recursive", "direct"}
+		case "indirect":
+			return nil
+		}
 	}
 
-	for p := range pins {
-		if err := p.Err(); err != nil {
-			return err
+	for _, typeStr := range types {
+		opt, err := options.Pin.Ls.Type(typeStr)
+		if err != nil {
+			panic("unhandled pin type")
 		}
-		err = emit(&PinLsOutputWrapper{
-			PinLsObject: PinLsObject{
-				Type: p.Type(),
-				Cid:  enc.Encode(p.Path().Cid()),
-			},
-		})
+
+		pins, err := api.Pin().Ls(req.Context, opt)
 		if err != nil {
 			return err
 		}
+
+		for p := range pins {
+			if err := p.Err(); err != nil {
+				return err
+			}
+			var info *pinmeta.Info
+			if pi, ok := p.(coreapi.PinWithInfo); ok {
+				info = pi.Info()
+			}
+			if !filter.Match(info) {
+				continue
+			}
+			err = emit(&PinLsOutputWrapper{
+				PinLsObject: newPinLsObject(enc.Encode(p.Path().Cid()), p.Type(), info),
+			})
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
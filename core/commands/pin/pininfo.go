@@ -0,0 +1,92 @@
+package pin
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+
+	"github.com/ipfs/go-ipfs/core/coreapi"
+	"github.com/ipfs/go-ipfs/pinmeta"
+)
+
+// pinInfoAPI returns the PinAPI of api with the names, metadata and expiry
+// times of the pins.
+func pinInfoAPI(api coreiface.CoreAPI) (coreapi.PinInfoAPI, error) {
+	pins, ok := api.Pin().(coreapi.PinInfoAPI)
+	if !ok {
+		return nil, fmt.Errorf("pin names, metadata and expiry are not supported by this node")
+	}
+	return pins, nil
+}
+
+// pinInfoFromOptions returns the record given to 'ipfs pin add' with the
+// --name, --meta and --expires options, or nil if there is none.
+func pinInfoFromOptions(req *cmds.Request) (*pinmeta.Info, error) {
+	name, _ := req.Options[pinNameOptionName].(string)
+	metaOpts, _ := req.Options[pinMetaOptionName].([]string)
+	expires, _ := req.Options[pinExpiresOptionName].(string)
+
+	meta, err := parseMeta(metaOpts)
+	if err != nil {
+		return nil, err
+	}
+	info := &pinmeta.Info{Name: name, Meta: meta}
+	if expires != "" {
+		t, err := parseExpires(expires, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		info.Expires = &t
+	}
+	if info.Empty() {
+		return nil, nil
+	}
+	return info, nil
+}
+
+// pinFilterFromOptions returns the filter given to 'ipfs pin ls' with the
+// --name and --meta options.
+func pinFilterFromOptions(req *cmds.Request) (pinmeta.Filter, error) {
+	name, _ := req.Options[pinNameOptionName].(string)
+	metaOpts, _ := req.Options[pinMetaOptionName].([]string)
+
+	meta, err := parseMeta(metaOpts)
+	if err != nil {
+		return pinmeta.Filter{}, err
+	}
+	return pinmeta.Filter{Name: name, Meta: meta}, nil
+}
+
+// parseMeta parses the key=value pairs of the --meta options.
+func parseMeta(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	meta := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid --%s %q: expected key=value", pinMetaOptionName, pair)
+		}
+		meta[kv[0]] = kv[1]
+	}
+	return meta, nil
+}
+
+// parseExpires parses the --expires option, either a duration from now or an
+// RFC 3339 time.
+func parseExpires(s string, now time.Time) (time.Time, error) {
+	var t time.Time
+	if d, err := time.ParseDuration(s); err == nil {
+		t = now.Add(d)
+	} else if t, err = time.Parse(time.RFC3339, s); err != nil {
+		return time.Time{}, fmt.Errorf("invalid --%s %q: expected a duration like 72h or an RFC 3339 time like 2006-01-02T15:04:05Z", pinExpiresOptionName, s)
+	}
+	if !t.After(now) {
+		return time.Time{}, fmt.Errorf("invalid --%s %q: the pin would already be expired", pinExpiresOptionName, s)
+	}
+	return t.UTC().Truncate(time.Second), nil
+}
@@ -0,0 +1,238 @@
+package pin
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	cidenc "github.com/ipfs/go-cidutil/cidenc"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/path"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/coreapi"
+	"github.com/ipfs/go-ipfs/pinmeta"
+	"github.com/ipfs/go-ipfs/pinqueue"
+)
+
+// pinQueueAPI returns the PinAPI of api with the queue of the pins added in
+// the background.
+func pinQueueAPI(api coreiface.CoreAPI) (coreapi.PinQueueAPI, error) {
+	pins, ok := api.Pin().(coreapi.PinQueueAPI)
+	if !ok {
+		return nil, fmt.Errorf("pinning in the background is not supported by this node")
+	}
+	return pins, nil
+}
+
+// PinJobOutput is the status of a pin added with 'ipfs pin add --background'
+type PinJobOutput struct {
+	Cid      string
+	Status   string
+	Type     string
+	Name     string     `json:",omitempty"`
+	Attempts int        `json:",omitempty"`
+	Error    string     `json:",omitempty"`
+	Retry    *time.Time `json:",omitempty"`
+	Created  time.Time
+	Updated  time.Time
+}
+
+func newPinJobOutput(enc cidenc.Encoder, job pinqueue.Job) *PinJobOutput {
+	out := &PinJobOutput{
+		Cid:      enc.Encode(job.Cid),
+		Status:   string(job.Status),
+		Type:     pinJobType(job),
+		Attempts: job.Attempts,
+		Error:    job.Error,
+		Retry:    job.Retry,
+		Created:  job.Created,
+		Updated:  job.Updated,
+	}
+	if job.Info != nil {
+		out.Name = job.Info.Name
+	}
+	return out
+}
+
+func pinJobType(job pinqueue.Job) string {
+	if job.Recursive {
+		return "recursive"
+	}
+	return "direct"
+}
+
+var statusPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the status of the pins added in the background.",
+		ShortDescription: `
+Shows the status of the pins added with 'ipfs pin add --background', or of
+the given ones: queued, pinning, pinned, or failed once all the attempts
+failed or the pin expired. The error of the last failed attempt is shown
+after the status.
+`,
+		LongDescription: `
+Shows the status of the pins added with 'ipfs pin add --background', or of
+the given ones: queued, pinning, pinned, or failed once all the attempts
+failed or the pin expired. The error of the last failed attempt is shown
+after the status.
+
+The pins are fetched by the daemon, a few at a time, and failed attempts are
+retried with an increasing delay. The pins that are done are shown for a day,
+and then dropped from the queue. The limits are set in the Pinning.Queue
+section of the config, see 'ipfs config' and docs/config.md:
+
+  Workers        how many pins are fetched at the same time (default: 4)
+  Attempts       how many times a pin is attempted before it fails
+                 (default: 5)
+  RetryDelay     the delay before the first retry, doubled for each of the
+                 next ones (default: "1m")
+  MaxRetryDelay  the longest delay between retries (default: "1h")
+  Retention      how long the pins that are done are shown (default: "24h")
+
+The queue is kept in the repo, so that the pins in progress are resumed when
+the daemon restarts. Adding a failed pin again queues it again, and
+'ipfs pin rm' removes a pin from the queue, stopping it if it is in progress.
+Use 'ipfs pin ls --status' to list the pins of the queue with given statuses.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("ipfs-path", false, true, "Path to the object(s) whose status to show."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+		pins, err := pinQueueAPI(api)
+		if err != nil {
+			return err
+		}
+
+		jobs, err := pins.Jobs(req.Context)
+		if err != nil {
+			return err
+		}
+
+		if len(req.Arguments) == 0 {
+			for _, job := range jobs {
+				if err := res.Emit(newPinJobOutput(enc, job)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		byCid := make(map[cid.Cid]pinqueue.Job, len(jobs))
+		for _, job := range jobs {
+			byCid[job.Cid] = job
+		}
+		for _, p := range req.Arguments {
+			rp, err := api.ResolvePath(req.Context, path.New(p))
+			if err != nil {
+				return err
+			}
+			job, ok := byCid[rp.Cid()]
+			if !ok {
+				return fmt.Errorf("path '%s' is not in the pin queue", p)
+			}
+			if err := res.Emit(newPinJobOutput(enc, job)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: PinJobOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *PinJobOutput) error {
+			fmt.Fprintf(w, "%s %s", out.Cid, out.Status)
+			if out.Error == pinqueue.ExpiredError {
+				fmt.Fprintf(w, ": %s", out.Error)
+			} else if out.Error != "" {
+				fmt.Fprintf(w, ": attempt %d failed: %s", out.Attempts, out.Error)
+				if out.Retry != nil {
+					fmt.Fprintf(w, ", retrying at %s", out.Retry.Format(time.RFC3339))
+				}
+			}
+			fmt.Fprintln(w)
+			return nil
+		}),
+	},
+}
+
+// pinLsQueue lists the pins of the queue with the given statuses, for
+// 'ipfs pin ls --status'.
+func pinLsQueue(req *cmds.Request, typeStr string, statuses []string, filter pinmeta.Filter, api coreiface.CoreAPI, emit func(value interface{}) error) error {
+	enc, err := cmdenv.GetCidEncoder(req)
+	if err != nil {
+		return err
+	}
+
+	want := make(map[pinqueue.Status]bool, len(statuses))
+	for _, s := range statuses {
+		st, err := pinqueue.ParseStatus(s)
+		if err != nil {
+			return err
+		}
+		want[st] = true
+	}
+
+	pins, err := pinQueueAPI(api)
+	if err != nil {
+		return err
+	}
+	jobs, err := pins.Jobs(req.Context)
+	if err != nil {
+		return err
+	}
+
+	match := func(job pinqueue.Job) bool {
+		if !want[job.Status] || !filter.Match(job.Info) {
+			return false
+		}
+		return typeStr == "all" || typeStr == pinJobType(job)
+	}
+	emitJob := func(job pinqueue.Job) error {
+		obj := newPinLsObject(enc.Encode(job.Cid), pinJobType(job), job.Info)
+		obj.Status = string(job.Status)
+		return emit(&PinLsOutputWrapper{PinLsObject: obj})
+	}
+
+	if len(req.Arguments) == 0 {
+		for _, job := range jobs {
+			if !match(job) {
+				continue
+			}
+			if err := emitJob(job); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	byCid := make(map[cid.Cid]pinqueue.Job, len(jobs))
+	for _, job := range jobs {
+		byCid[job.Cid] = job
+	}
+	for _, p := range req.Arguments {
+		rp, err := api.ResolvePath(req.Context, path.New(p))
+		if err != nil {
+			return err
+		}
+		job, ok := byCid[rp.Cid()]
+		if !ok || !match(job) {
+			return fmt.Errorf("path '%s' is not in the pin queue with the given status", p)
+		}
+		if err := emitJob(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -12,8 +12,10 @@ import (
 	"text/tabwriter"
 
 	humanize "github.com/dustin/go-humanize"
+	core "github.com/ipfs/go-ipfs/core"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+	"github.com/ipfs/go-ipfs/gc"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
 
 	cid "github.com/ipfs/go-cid"
@@ -46,11 +48,30 @@ var RepoCmd = &cmds.Command{
 type GcResult struct {
 	Key   cid.Cid
 	Error string `json:",omitempty"`
+
+	// With --dry-run, the size of the object. The last result has no Key,
+	// and the total size and number of objects.
+	Size    uint64 `json:",omitempty"`
+	Objects uint64 `json:",omitempty"`
+
+	// With --explain, the pins and roots which keep the object.
+	KeptBy []GcRetention `json:",omitempty"`
+}
+
+// GcRetention is a pin or a root which keeps an object from being garbage
+// collected, with the path from it to the object.
+type GcRetention struct {
+	Type string
+	Name string `json:",omitempty"`
+	Path []cid.Cid
 }
 
 const (
 	repoStreamErrorsOptionName = "stream-errors"
 	repoQuietOptionName        = "quiet"
+	repoIncrementalOptionName  = "incremental"
+	repoDryRunOptionName       = "dry-run"
+	repoExplainOptionName      = "explain"
 )
 
 var repoGcCmd = &cmds.Command{
@@ -60,11 +81,35 @@ var repoGcCmd = &cmds.Command{
 'ipfs repo gc' is a plumbing command that will sweep the local
 set of stored objects and remove ones that are not pinned in
 order to reclaim hard disk space.
+`,
+		LongDescription: `
+'ipfs repo gc' is a plumbing command that will sweep the local
+set of stored objects and remove ones that are not pinned in
+order to reclaim hard disk space.
+
+Adding and pinning objects waits for the garbage collection to
+finish. On large repos, use --incremental to only block them for
+short periods: the objects to keep are found first, and the
+others are then removed a few at a time. The objects added, read
+or pinned while it runs are kept until the next collection.
+Only the removal is incremental: every collection still reads all
+the pinned objects to find the ones to keep, and an incremental
+one takes longer.
+
+Use --dry-run to list the objects that would be removed, and how
+much space would be freed, without removing anything. Use
+--explain to show why objects are kept instead: the pins, or the
+MFS root of 'ipfs files', which lead to each of them. Neither
+blocks adds and pins, so the objects being added at the same time
+may be listed, and neither can be combined with --incremental.
 `,
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(repoStreamErrorsOptionName, "Stream errors."),
 		cmds.BoolOption(repoQuietOptionName, "q", "Write minimal output."),
+		cmds.BoolOption(repoIncrementalOptionName, "Do not block adds and pins while collecting."),
+		cmds.BoolOption(repoDryRunOptionName, "Only list the objects that would be removed."),
+		cmds.StringsOption(repoExplainOptionName, "Show why the given CIDs are kept, without collecting."),
 	},
 	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := cmdenv.GetNode(env)
@@ -72,9 +117,54 @@ order to reclaim hard disk space.
 			return err
 		}
 
+		incremental, _ := req.Options[repoIncrementalOptionName].(bool)
+		dryRun, _ := req.Options[repoDryRunOptionName].(bool)
+		explain, _ := req.Options[repoExplainOptionName].([]string)
+		for _, o := range []struct {
+			name string
+			set  bool
+		}{
+			{repoDryRunOptionName, dryRun},
+			{repoExplainOptionName, len(explain) > 0},
+		} {
+			if o.set && incremental {
+				return fmt.Errorf("--%s and --%s cannot be used together: --%s doesn't block adds and pins either", o.name, repoIncrementalOptionName, o.name)
+			}
+		}
+
+		if len(explain) > 0 {
+			return repoGcExplain(req, re, n, explain)
+		}
+
 		streamErrors, _ := req.Options[repoStreamErrorsOptionName].(bool)
 
-		gcOutChan := corerepo.GarbageCollectAsync(n, req.Context)
+		gcAsync := corerepo.GarbageCollectAsync
+		switch {
+		case dryRun:
+			gcAsync = corerepo.GarbageCollectDryRunAsync
+		case incremental:
+			gcAsync = corerepo.GarbageCollectIncrementalAsync
+		}
+		gcOutChan := gcAsync(n, req.Context)
+
+		var total GcResult
+		emitKey := func(k cid.Cid) error {
+			if !dryRun {
+				return re.Emit(&GcResult{Key: k})
+			}
+			// not a use of the block, see gc.DryRun
+			size, err := gc.Untracked(n.Blockstore).GetSize(k)
+			if err == bstore.ErrNotFound {
+				// removed in the meantime
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			total.Size += uint64(size)
+			total.Objects++
+			return re.Emit(&GcResult{Key: k, Size: uint64(size)})
+		}
 
 		if streamErrors {
 			errs := false
@@ -85,7 +175,7 @@ order to reclaim hard disk space.
 					}
 					errs = true
 				} else {
-					if err := re.Emit(&GcResult{Key: res.KeyRemoved}); err != nil {
+					if err := emitKey(res.KeyRemoved); err != nil {
 						return err
 					}
 				}
@@ -98,26 +188,45 @@ order to reclaim hard disk space.
 				// Nothing to do with this error, really. This
 				// most likely means that the client is gone but
 				// we still need to let the GC finish.
-				_ = re.Emit(&GcResult{Key: k})
+				_ = emitKey(k)
 			})
 			if err != nil {
 				return err
 			}
 		}
 
+		if dryRun {
+			return re.Emit(&total)
+		}
 		return nil
 	},
 	Type: GcResult{},
 	Encoders: cmds.EncoderMap{
 		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, gcr *GcResult) error {
 			quiet, _ := req.Options[repoQuietOptionName].(bool)
+			dryRun, _ := req.Options[repoDryRunOptionName].(bool)
 
 			if gcr.Error != "" {
 				_, err := fmt.Fprintf(w, "Error: %s\n", gcr.Error)
 				return err
 			}
 
+			if explain, _ := req.Options[repoExplainOptionName].([]string); len(explain) > 0 {
+				return formatGcRetentions(w, gcr)
+			}
+
+			if dryRun && !gcr.Key.Defined() {
+				if quiet {
+					return nil
+				}
+				_, err := fmt.Fprintf(w, "would free %d bytes (%s) from %d objects\n", gcr.Size, humanize.Bytes(gcr.Size), gcr.Objects)
+				return err
+			}
+
 			prefix := "removed "
+			if dryRun {
+				prefix = "would remove "
+			}
 			if quiet {
 				prefix = ""
 			}
@@ -128,6 +237,86 @@ order to reclaim hard disk space.
 	},
 }
 
+// repoGcExplain emits why the given objects are kept, for
+// 'ipfs repo gc --explain'.
+func repoGcExplain(req *cmds.Request, re cmds.ResponseEmitter, n *core.IpfsNode, args []string) error {
+	keys := make([]cid.Cid, 0, len(args))
+	for _, arg := range args {
+		c, err := cid.Decode(arg)
+		if err != nil {
+			return fmt.Errorf("invalid CID %q: %s", arg, err)
+		}
+		has, err := n.Blockstore.Has(c)
+		if err != nil {
+			return err
+		}
+		if !has {
+			return fmt.Errorf("%s is not in the repo", c)
+		}
+		keys = append(keys, c)
+	}
+
+	retentions, err := corerepo.ExplainGC(n, req.Context, keys)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		out := &GcResult{Key: k}
+		for _, r := range retentions[k] {
+			kept := GcRetention{
+				Type: string(r.Kind),
+				Path: r.Path,
+			}
+			if r.Kind == gc.BestEffortRoot {
+				kept.Type = "mfs"
+			}
+			if r.Kind == gc.RecursivePin || r.Kind == gc.DirectPin {
+				info, err := n.PinMeta.Get(r.Root())
+				if err != nil {
+					return err
+				}
+				if info != nil {
+					kept.Name = info.Name
+				}
+			}
+			out.KeptBy = append(out.KeptBy, kept)
+		}
+		if err := re.Emit(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatGcRetentions(w io.Writer, gcr *GcResult) error {
+	if len(gcr.KeptBy) == 0 {
+		_, err := fmt.Fprintf(w, "%s would be removed\n", gcr.Key)
+		return err
+	}
+	for _, kept := range gcr.KeptBy {
+		what := kept.Type + " pin"
+		if kept.Type == "mfs" {
+			what = "MFS root"
+		}
+		fmt.Fprintf(w, "%s is kept by the %s %s", gcr.Key, what, kept.Path[0])
+		if kept.Name != "" {
+			fmt.Fprintf(w, " (%s)", kept.Name)
+		}
+		if len(kept.Path) > 1 {
+			path := make([]string, len(kept.Path))
+			for i, c := range kept.Path {
+				path[i] = c.String()
+			}
+			fmt.Fprintf(w, ": %s", strings.Join(path, " -> "))
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 const (
 	repoSizeOnlyOptionName = "size-only"
 	repoHumanOptionName    = "human"
@@ -42,12 +42,16 @@ import (
 	ma "github.com/multiformats/go-multiaddr"
 	madns "github.com/multiformats/go-multiaddr-dns"
 
+	"github.com/ipfs/go-ipfs/blocks/carstore"
 	"github.com/ipfs/go-ipfs/core/bootstrap"
 	"github.com/ipfs/go-ipfs/core/node"
 	"github.com/ipfs/go-ipfs/core/node/libp2p"
+	"github.com/ipfs/go-ipfs/denylist"
 	"github.com/ipfs/go-ipfs/fuse/mount"
 	"github.com/ipfs/go-ipfs/p2p"
 	"github.com/ipfs/go-ipfs/peering"
+	"github.com/ipfs/go-ipfs/pinmeta"
+	"github.com/ipfs/go-ipfs/pinqueue"
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-namesys"
 	ipnsrp "github.com/ipfs/go-namesys/republisher"
@@ -74,6 +78,7 @@ type IpfsNode struct {
 	Blockstore      bstore.GCBlockstore       // the block store (lower level)
 	Filestore       *filestore.Filestore      `optional:"true"` // the filestore blockstore
 	BaseBlocks      node.BaseBlocks           // the raw blockstore, no filestore wrapping
+	CarMounts       *carstore.Store           `optional:"true"` // CAR files mounted under the blockstore
 	GCLocker        bstore.GCLocker           // the locker used to protect the blockstore during gc
 	Blocks          bserv.BlockService        // the block service, get/add blocks.
 	DAG             ipld.DAGService           // the merkle dag service, get/add objects.
@@ -82,6 +87,9 @@ type IpfsNode struct {
 	Discovery       discovery.Service         `optional:"true"`
 	FilesRoot       *mfs.Root
 	RecordValidator record.Validator
+	Denylist        *denylist.Denylist `optional:"true"` // content the node refuses to resolve
+	PinMeta         *pinmeta.Store     // names, metadata and expiry of the pins
+	PinQueue        *pinqueue.Queue    // pins added in the background
 
 	// Online
 	PeerHost      p2phost.Host            `optional:"true"` // the network host (server+client)
@@ -92,6 +100,7 @@ type IpfsNode struct {
 	DNSResolver   *madns.Resolver         // the DNS resolver
 	Exchange      exchange.Interface      // the block exchange + strategy (bitswap)
 	Namesys       namesys.NameSystem      // the name system, resolves paths to hashes
+	IpnsRecords   *node.IpnsRecords       // the IPNS records the name system last resolved
 	Provider      provider.System         // the value provider system
 	IpnsRepub     *ipnsrp.Republisher     `optional:"true"`
 	GraphExchange graphsync.GraphExchange `optional:"true"`
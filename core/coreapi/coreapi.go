@@ -40,6 +40,9 @@ import (
 
 	"github.com/ipfs/go-ipfs/core"
 	"github.com/ipfs/go-ipfs/core/node"
+	"github.com/ipfs/go-ipfs/denylist"
+	"github.com/ipfs/go-ipfs/pinmeta"
+	"github.com/ipfs/go-ipfs/pinqueue"
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-namesys"
 )
@@ -54,6 +57,8 @@ type CoreAPI struct {
 	blockstore blockstore.GCBlockstore
 	baseBlocks blockstore.Blockstore
 	pinning    pin.Pinner
+	pinMeta    *pinmeta.Store
+	pinQueue   *pinqueue.Queue
 
 	blocks bserv.BlockService
 	dag    ipld.DAGService
@@ -71,6 +76,8 @@ type CoreAPI struct {
 
 	pubSub *pubsub.PubSub
 
+	denylist *denylist.Denylist
+
 	checkPublishAllowed func() error
 	checkOnline         func(allowOffline bool) error
 
@@ -166,6 +173,8 @@ func (api *CoreAPI) WithOptions(opts ...options.ApiOption) (coreiface.CoreAPI, e
 		blockstore: n.Blockstore,
 		baseBlocks: n.BaseBlocks,
 		pinning:    n.Pinning,
+		pinMeta:    n.PinMeta,
+		pinQueue:   n.PinQueue,
 
 		blocks: n.Blocks,
 		dag:    n.DAG,
@@ -182,6 +191,8 @@ func (api *CoreAPI) WithOptions(opts ...options.ApiOption) (coreiface.CoreAPI, e
 
 		pubSub: n.PubSub,
 
+		denylist: n.Denylist,
+
 		nd:         n,
 		parentOpts: settings,
 	}
@@ -237,6 +248,9 @@ func (api *CoreAPI) WithOptions(opts ...options.ApiOption) (coreiface.CoreAPI, e
 		subApi.dag = dag.NewDAGService(subApi.blocks)
 	}
 
+	// blocked nodes are neither fetched nor traversed, through any API
+	subApi.dag = subApi.denylist.DAGService(subApi.dag)
+
 	return subApi, nil
 }
 
@@ -42,12 +42,19 @@ func (api *CoreAPI) ResolvePath(ctx context.Context, p path.Path) (path.Resolved
 	}
 
 	ipath := ipfspath.Path(p.String())
+	// check the name before resolving it, and the resulting /ipfs/ path after
+	if err := api.denylist.CheckPath(ipath); err != nil {
+		return nil, err
+	}
 	ipath, err := resolve.ResolveIPNS(ctx, api.namesys, ipath)
 	if err == resolve.ErrNoNamesys {
 		return nil, coreiface.ErrOffline
 	} else if err != nil {
 		return nil, err
 	}
+	if err := api.denylist.CheckPath(ipath); err != nil {
+		return nil, err
+	}
 
 	var resolveOnce resolver.ResolveOnce
 
@@ -61,6 +68,7 @@ func (api *CoreAPI) ResolvePath(ctx context.Context, p path.Path) (path.Resolved
 	}
 
 	r := &resolver.Resolver{
+		// api.dag doesn't fetch nor traverse the nodes on the denylist
 		DAG:         api.dag,
 		ResolveOnce: resolveOnce,
 	}
@@ -69,6 +77,9 @@ func (api *CoreAPI) ResolvePath(ctx context.Context, p path.Path) (path.Resolved
 	if err != nil {
 		return nil, err
 	}
+	if err := api.denylist.CheckCid(node); err != nil {
+		return nil, err
+	}
 
 	root, err := cid.Parse(ipath.Segments()[1])
 	if err != nil {
@@ -3,6 +3,7 @@ package coreapi
 import (
 	"context"
 	"fmt"
+	"time"
 
 	bserv "github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
@@ -12,11 +13,66 @@ import (
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	caopts "github.com/ipfs/interface-go-ipfs-core/options"
 	"github.com/ipfs/interface-go-ipfs-core/path"
+
+	"github.com/ipfs/go-ipfs/pinmeta"
+	"github.com/ipfs/go-ipfs/pinqueue"
 )
 
 type PinAPI CoreAPI
 
+// PinInfoAPI extends the PinAPI with the names, metadata and expiry times of
+// the local pins, see the pinmeta package. The pins listed by Ls implement
+// PinWithInfo.
+type PinInfoAPI interface {
+	coreiface.PinAPI
+
+	// AddWithInfo pins the object like Add, and records info for the pin,
+	// replacing any previous record.
+	AddWithInfo(ctx context.Context, p path.Path, info *pinmeta.Info, opts ...caopts.PinAddOption) error
+
+	// Info returns the record of the pin of the object, or nil if there is
+	// none.
+	Info(ctx context.Context, p path.Path) (*pinmeta.Info, error)
+
+	// RemoveExpired removes the pins that expired at now, along with their
+	// jobs in the pin queue, and returns their CIDs. The jobs of the queued
+	// pins that expired before they were pinned fail.
+	RemoveExpired(ctx context.Context, now time.Time) ([]cid.Cid, error)
+}
+
+// PinWithInfo is a pin listed by PinInfoAPI.Ls.
+type PinWithInfo interface {
+	coreiface.Pin
+
+	// Info returns the record of the pin, or nil if there is none. Indirect
+	// pins never have one.
+	Info() *pinmeta.Info
+}
+
+// PinQueueAPI extends the PinAPI with the queue of the pins added in the
+// background, see the pinqueue package. Rm also removes from the queue the
+// pins that are not pinned yet, or failed.
+type PinQueueAPI interface {
+	coreiface.PinAPI
+
+	// Queue queues the object to be pinned in the background, with info
+	// if it isn't nil, and returns its job.
+	Queue(ctx context.Context, p path.Path, info *pinmeta.Info, opts ...caopts.PinAddOption) (pinqueue.Job, error)
+
+	// Jobs returns the pins of the queue, in the order they were queued.
+	Jobs(ctx context.Context) ([]pinqueue.Job, error)
+}
+
+var (
+	_ PinInfoAPI  = (*PinAPI)(nil)
+	_ PinQueueAPI = (*PinAPI)(nil)
+)
+
 func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOption) error {
+	return api.AddWithInfo(ctx, p, nil, opts...)
+}
+
+func (api *PinAPI) AddWithInfo(ctx context.Context, p path.Path, info *pinmeta.Info, opts ...caopts.PinAddOption) error {
 	dagNode, err := api.core().ResolveNode(ctx, p)
 	if err != nil {
 		return fmt.Errorf("pin: %s", err)
@@ -38,7 +94,53 @@ func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOp
 		return err
 	}
 
-	return api.pinning.Flush(ctx)
+	if err := api.pinning.Flush(ctx); err != nil {
+		return err
+	}
+
+	// pinning again without a record keeps the previous one
+	if info.Empty() {
+		return nil
+	}
+	return api.pinMeta.Put(dagNode.Cid(), info)
+}
+
+func (api *PinAPI) Info(ctx context.Context, p path.Path) (*pinmeta.Info, error) {
+	rp, err := api.core().ResolvePath(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return api.pinMeta.Get(rp.Cid())
+}
+
+func (api *PinAPI) RemoveExpired(ctx context.Context, now time.Time) ([]cid.Cid, error) {
+	// first, so that a pin in progress doesn't complete after its removal
+	api.pinQueue.Expire(now)
+	removed, err := api.pinMeta.RemoveExpired(ctx, api.pinning, api.blockstore, now)
+	for _, c := range removed {
+		if _, _, qErr := api.pinQueue.Remove(c); qErr != nil && err == nil {
+			err = fmt.Errorf("removing expired pin %s from the pin queue: %w", c, qErr)
+		}
+	}
+	return removed, err
+}
+
+func (api *PinAPI) Queue(ctx context.Context, p path.Path, info *pinmeta.Info, opts ...caopts.PinAddOption) (pinqueue.Job, error) {
+	rp, err := api.core().ResolvePath(ctx, p)
+	if err != nil {
+		return pinqueue.Job{}, fmt.Errorf("pin: %s", err)
+	}
+
+	settings, err := caopts.PinAddOptions(opts...)
+	if err != nil {
+		return pinqueue.Job{}, err
+	}
+
+	return api.pinQueue.Add(rp.Cid(), settings.Recursive, info)
+}
+
+func (api *PinAPI) Jobs(ctx context.Context) ([]pinqueue.Job, error) {
+	return api.pinQueue.Jobs(), nil
 }
 
 func (api *PinAPI) Ls(ctx context.Context, opts ...caopts.PinLsOption) (<-chan coreiface.Pin, error) {
@@ -92,10 +194,24 @@ func (api *PinAPI) Rm(ctx context.Context, p path.Path, opts ...caopts.PinRmOpti
 	defer api.blockstore.PinLock().Unlock()
 
 	if err = api.pinning.Unpin(ctx, rp.Cid(), settings.Recursive); err != nil {
+		// a pin that is still in the queue, or failed, is removed from it
+		// instead. A job in progress is canceled while we hold the pin
+		// lock, and doesn't pin once it gets it.
+		job, ok, rmErr := api.pinQueue.Remove(rp.Cid())
+		if !ok || job.Status == pinqueue.Pinned {
+			return err
+		}
+		return rmErr
+	}
+
+	if err := api.pinning.Flush(ctx); err != nil {
 		return err
 	}
 
-	return api.pinning.Flush(ctx)
+	if _, _, err := api.pinQueue.Remove(rp.Cid()); err != nil {
+		return err
+	}
+	return api.pinMeta.Delete(rp.Cid())
 }
 
 func (api *PinAPI) Update(ctx context.Context, from path.Path, to path.Path, opts ...caopts.PinUpdateOption) error {
@@ -121,7 +237,19 @@ func (api *PinAPI) Update(ctx context.Context, from path.Path, to path.Path, opt
 		return err
 	}
 
-	return api.pinning.Flush(ctx)
+	if err := api.pinning.Flush(ctx); err != nil {
+		return err
+	}
+
+	// the record follows the pin, or is copied when the old pin is kept
+	if settings.Unpin {
+		return api.pinMeta.Move(fp.Cid(), tp.Cid())
+	}
+	info, err := api.pinMeta.Get(fp.Cid())
+	if err != nil || info == nil {
+		return err
+	}
+	return api.pinMeta.Put(tp.Cid(), info)
 }
 
 type pinStatus struct {
@@ -203,6 +331,7 @@ func (api *PinAPI) Verify(ctx context.Context) (<-chan coreiface.PinStatus, erro
 type pinInfo struct {
 	pinType string
 	path    path.Resolved
+	info    *pinmeta.Info
 	err     error
 }
 
@@ -214,6 +343,10 @@ func (p *pinInfo) Type() string {
 	return p.pinType
 }
 
+func (p *pinInfo) Info() *pinmeta.Info {
+	return p.info
+}
+
 func (p *pinInfo) Err() error {
 	return p.err
 }
@@ -226,14 +359,20 @@ func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string) <-chan coreifac
 	out := make(chan coreiface.Pin, 1)
 
 	keys := cid.NewSet()
+	var infos map[cid.Cid]*pinmeta.Info
 
 	AddToResultKeys := func(keyList []cid.Cid, typeStr string) error {
 		for _, c := range keyList {
 			if keys.Visit(c) {
+				var info *pinmeta.Info
+				if typeStr != "indirect" {
+					info = infos[c]
+				}
 				select {
 				case out <- &pinInfo{
 					pinType: typeStr,
 					path:    path.IpldPath(c),
+					info:    info,
 				}:
 				case <-ctx.Done():
 					return ctx.Err()
@@ -254,6 +393,13 @@ func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string) <-chan coreifac
 
 		var dkeys, rkeys []cid.Cid
 		var err error
+		if typeStr != "indirect" {
+			infos, err = api.pinMeta.All()
+			if err != nil {
+				out <- &pinInfo{err: err}
+				return
+			}
+		}
 		if typeStr == "recursive" || typeStr == "all" {
 			rkeys, err = api.pinning.RecursiveKeys(ctx)
 			if err != nil {
@@ -0,0 +1,87 @@
+package coredag
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	ipldcbor "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// DagJSON is the multicodec of dag-json blocks. go-cid doesn't define it yet.
+const DagJSON = 0x0129
+
+// dagJSONCborParser parses dag-json into a dag-cbor node. Unlike the "json"
+// input encoding, it keeps integers exact and reads bytes, written as
+// {"/": {"bytes": "<base64>"}}, as 'ipfs dag get --output-codec=dag-json'
+// writes them.
+func dagJSONCborParser(r io.Reader, mhType uint64, mhLen int) ([]ipld.Node, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	obj, err := fromDagJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := ipldcbor.WrapObject(obj, mhType, mhLen)
+	if err != nil {
+		return nil, err
+	}
+	return []ipld.Node{nd}, nil
+}
+
+// fromDagJSON converts a value decoded by encoding/json to the values
+// go-ipld-cbor encodes: links to cid.Cid, bytes to []byte and numbers to
+// int64, uint64 or float64.
+func fromDagJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if slash, ok := v["/"]; ok && len(v) == 1 {
+			switch slash := slash.(type) {
+			case string:
+				return cid.Decode(slash)
+			case map[string]interface{}:
+				if b64, ok := slash["bytes"].(string); ok && len(slash) == 1 {
+					// the spec has no padding, be lenient
+					return base64.RawStdEncoding.DecodeString(strings.TrimRight(b64, "="))
+				}
+			}
+			return nil, errors.New(`invalid dag-json: "/" is reserved for links and bytes`)
+		}
+		for k, val := range v {
+			val, err := fromDagJSON(val)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = val
+		}
+		return v, nil
+	case []interface{}:
+		for i, val := range v {
+			val, err := fromDagJSON(val)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = val
+		}
+		return v, nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return u, nil
+		}
+		return v.Float64()
+	default:
+		return v, nil
+	}
+}
@@ -22,6 +22,7 @@ var DefaultInputEncParsers = InputEncParsers{
 	"raw":      defaultRawParsers,
 	"cbor":     defaultCborParsers,
 	"protobuf": defaultProtobufParsers,
+	"dag-json": defaultDagJSONParsers,
 }
 
 var defaultJSONParsers = FormatParsers{
@@ -42,6 +43,11 @@ var defaultRawParsers = FormatParsers{
 	"raw": rawRawParser,
 }
 
+var defaultDagJSONParsers = FormatParsers{
+	"cbor":     dagJSONCborParser,
+	"dag-cbor": dagJSONCborParser,
+}
+
 var defaultCborParsers = FormatParsers{
 	"cbor":     cborRawParser,
 	"dag-cbor": cborRawParser,
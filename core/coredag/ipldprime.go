@@ -0,0 +1,78 @@
+package coredag
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	ipldprime "github.com/ipld/go-ipld-prime"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	_ "github.com/ipld/go-ipld-prime/codec/raw"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/polydawn/refmt/json"
+	"github.com/polydawn/refmt/shared"
+	"github.com/polydawn/refmt/tok"
+)
+
+// DecodeValue decodes the block b with go-ipld-prime, and returns the value
+// at the path rem inside of it.
+func DecodeValue(b blocks.Block, rem string) (ipldprime.Node, error) {
+	c := b.Cid()
+	decode, err := multicodec.LookupDecoder(c.Prefix().Codec)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %s", c, err)
+	}
+	var nb ipldprime.NodeBuilder
+	if c.Prefix().Codec == cid.DagProtobuf {
+		nb = dagpb.Type.PBNode.NewBuilder()
+	} else {
+		nb = basicnode.Prototype.Any.NewBuilder()
+	}
+	if err := decode(nb, bytes.NewReader(b.RawData())); err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %s", c, err)
+	}
+	value := nb.Build()
+	if rem == "" {
+		return value, nil
+	}
+	return traversal.Get(value, ipldprime.ParsePath(rem))
+}
+
+// EncodeDagJSON writes value as in the dag-json spec.
+func EncodeDagJSON(w io.Writer, value ipldprime.Node) error {
+	enc := json.NewEncoder(w, json.EncodeOptions{})
+	return dagjson.Marshal(value, dagJSONBytes{enc}, true)
+}
+
+// dagJSONBytes writes bytes as {"/": {"bytes": "<base64>"}}, as the dag-json
+// spec wants them: the dag-json encoder writes them as plain base64 strings,
+// which can't be told apart from strings.
+type dagJSONBytes struct {
+	shared.TokenSink
+}
+
+func (s dagJSONBytes) Step(tk *tok.Token) (bool, error) {
+	if tk.Type != tok.TBytes {
+		return s.TokenSink.Step(tk)
+	}
+	for _, t := range []tok.Token{
+		{Type: tok.TMapOpen, Length: 1},
+		{Type: tok.TString, Str: "/"},
+		{Type: tok.TMapOpen, Length: 1},
+		{Type: tok.TString, Str: "bytes"},
+		{Type: tok.TString, Str: base64.RawStdEncoding.EncodeToString(tk.Bytes)},
+		{Type: tok.TMapClose},
+	} {
+		if _, err := s.TokenSink.Step(&t); err != nil {
+			return false, err
+		}
+	}
+	return s.TokenSink.Step(&tok.Token{Type: tok.TMapClose})
+}
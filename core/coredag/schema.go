@@ -0,0 +1,840 @@
+package coredag
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	ipldprime "github.com/ipld/go-ipld-prime"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+)
+
+// Schema is an IPLD Schema, read from its JSON form: the data model of the
+// schema DSL, as in
+//
+//	{"types": {
+//	  "Record": {"struct": {
+//	    "fields": {
+//	      "name": {"type": "String"},
+//	      "tags": {"type": {"list": {"valueType": "String"}}, "optional": true}
+//	    },
+//	    "representation": {"map": {}}
+//	  }}
+//	}}
+//
+// Schemas can use the types of the prelude (Bool, String, Bytes, Int, Float,
+// Map, List, Link and Any), and all the kinds of types, but not all their
+// representations: maps have to be represented as maps, lists as lists,
+// structs as maps or tuples, and unions as keyed, kinded, envelope or
+// inline unions.
+type Schema struct {
+	types   map[string]*schemaType
+	defined []string // names of the types that aren't in the prelude
+}
+
+type schemaType struct {
+	name string // empty for inline definitions
+	kind string
+
+	// map and list
+	keyType       typeRef
+	valueType     typeRef
+	valueNullable bool
+
+	// struct, enum and union
+	repr string
+
+	// struct
+	fields []*structField
+
+	// enum: member for each representation value
+	members map[string]string
+
+	// union: member type for each key, kind or discriminant
+	table           map[string]string
+	discriminantKey string
+	contentKey      string
+
+	// copy
+	fromType string
+}
+
+type structField struct {
+	name     string
+	key      string // name in the representation
+	typ      typeRef
+	optional bool
+	nullable bool
+	implicit bool
+}
+
+// typeRef is either the name of a type, or an inline definition.
+type typeRef struct {
+	name   string
+	inline *schemaType
+}
+
+var scalarKinds = map[string]ipldprime.Kind{
+	"bool":   ipldprime.Kind_Bool,
+	"string": ipldprime.Kind_String,
+	"bytes":  ipldprime.Kind_Bytes,
+	"int":    ipldprime.Kind_Int,
+	"float":  ipldprime.Kind_Float,
+	"link":   ipldprime.Kind_Link,
+}
+
+func preludeTypes() map[string]*schemaType {
+	return map[string]*schemaType{
+		"Bool":   {name: "Bool", kind: "bool"},
+		"String": {name: "String", kind: "string"},
+		"Bytes":  {name: "Bytes", kind: "bytes"},
+		"Int":    {name: "Int", kind: "int"},
+		"Float":  {name: "Float", kind: "float"},
+		"Link":   {name: "Link", kind: "link"},
+		"Any":    {name: "Any", kind: "any"},
+		"Map":    {name: "Map", kind: "map", keyType: typeRef{name: "String"}, valueType: typeRef{name: "Any"}, valueNullable: true},
+		"List":   {name: "List", kind: "list", valueType: typeRef{name: "Any"}, valueNullable: true},
+	}
+}
+
+// LoadSchema reads the JSON form of a schema.
+func LoadSchema(data []byte) (*Schema, error) {
+	var doc struct {
+		Types map[string]json.RawMessage `json:"types"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if len(doc.Types) == 0 {
+		return nil, fmt.Errorf("invalid schema: no types")
+	}
+
+	s := &Schema{types: preludeTypes()}
+	for name, raw := range doc.Types {
+		t, err := parseTypeDefn(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema: type %s: %w", name, err)
+		}
+		t.name = name
+		s.types[name] = t
+		s.defined = append(s.defined, name)
+	}
+	sort.Strings(s.defined)
+	for _, name := range s.defined {
+		if err := s.check(s.types[name]); err != nil {
+			return nil, fmt.Errorf("invalid schema: type %s: %w", name, err)
+		}
+	}
+	return s, nil
+}
+
+// TypeNames returns the names of the types the schema defines, without the
+// ones of the prelude.
+func (s *Schema) TypeNames() []string {
+	return append([]string(nil), s.defined...)
+}
+
+// HasType tells whether the schema has the type name, defined or from the
+// prelude.
+func (s *Schema) HasType(name string) bool {
+	_, ok := s.types[name]
+	return ok
+}
+
+// keyedObject reads a JSON object with a single entry, as keyed unions are.
+func keyedObject(raw json.RawMessage) (string, json.RawMessage, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return "", nil, err
+	}
+	if len(m) != 1 {
+		return "", nil, fmt.Errorf("expected an object with a single key, got %d keys", len(m))
+	}
+	for k, v := range m {
+		return k, v, nil
+	}
+	panic("unreachable")
+}
+
+func parseTypeDefn(raw json.RawMessage) (*schemaType, error) {
+	kind, body, err := keyedObject(raw)
+	if err != nil {
+		return nil, err
+	}
+	t := &schemaType{kind: kind}
+
+	switch kind {
+	case "bool", "string", "bytes", "int", "float", "link":
+		// a link's expectedType is about another block: it isn't checked
+		return t, nil
+
+	case "map":
+		var d struct {
+			KeyType        string                     `json:"keyType"`
+			ValueType      json.RawMessage            `json:"valueType"`
+			ValueNullable  bool                       `json:"valueNullable"`
+			Representation map[string]json.RawMessage `json:"representation"`
+		}
+		if err := json.Unmarshal(body, &d); err != nil {
+			return nil, err
+		}
+		if err := onlyRepr(d.Representation, "map"); err != nil {
+			return nil, err
+		}
+		t.keyType = typeRef{name: d.KeyType}
+		t.valueNullable = d.ValueNullable
+		t.valueType, err = parseTypeRef(d.ValueType)
+		return t, err
+
+	case "list":
+		var d struct {
+			ValueType      json.RawMessage            `json:"valueType"`
+			ValueNullable  bool                       `json:"valueNullable"`
+			Representation map[string]json.RawMessage `json:"representation"`
+		}
+		if err := json.Unmarshal(body, &d); err != nil {
+			return nil, err
+		}
+		if err := onlyRepr(d.Representation, "list"); err != nil {
+			return nil, err
+		}
+		t.valueNullable = d.ValueNullable
+		t.valueType, err = parseTypeRef(d.ValueType)
+		return t, err
+
+	case "struct":
+		return t, parseStruct(t, body)
+
+	case "enum":
+		return t, parseEnum(t, body)
+
+	case "union":
+		return t, parseUnion(t, body)
+
+	case "copy":
+		var d struct {
+			FromType string `json:"fromType"`
+		}
+		if err := json.Unmarshal(body, &d); err != nil {
+			return nil, err
+		}
+		t.fromType = d.FromType
+		return t, nil
+
+	default:
+		return nil, fmt.Errorf("unknown kind %q", kind)
+	}
+}
+
+func parseTypeRef(raw json.RawMessage) (typeRef, error) {
+	if len(raw) == 0 {
+		return typeRef{}, fmt.Errorf("missing valueType")
+	}
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		return typeRef{name: name}, nil
+	}
+	t, err := parseTypeDefn(raw)
+	if err != nil {
+		return typeRef{}, err
+	}
+	return typeRef{inline: t}, nil
+}
+
+// onlyRepr checks that a representation, if there is one, is the one
+// supported.
+func onlyRepr(repr map[string]json.RawMessage, supported string) error {
+	for k := range repr {
+		if k != supported {
+			return fmt.Errorf("the %s representation is not supported", k)
+		}
+	}
+	return nil
+}
+
+func parseStruct(t *schemaType, body json.RawMessage) error {
+	var d struct {
+		Fields map[string]struct {
+			Type     json.RawMessage `json:"type"`
+			Optional bool            `json:"optional"`
+			Nullable bool            `json:"nullable"`
+		} `json:"fields"`
+		Representation json.RawMessage `json:"representation"`
+	}
+	if err := json.Unmarshal(body, &d); err != nil {
+		return err
+	}
+
+	byName := make(map[string]*structField, len(d.Fields))
+	for name, f := range d.Fields {
+		typ, err := parseTypeRef(f.Type)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", name, err)
+		}
+		sf := &structField{name: name, key: name, typ: typ, optional: f.Optional, nullable: f.Nullable}
+		byName[name] = sf
+		t.fields = append(t.fields, sf)
+	}
+	sort.Slice(t.fields, func(i, j int) bool { return t.fields[i].name < t.fields[j].name })
+
+	t.repr = "map"
+	if len(d.Representation) == 0 {
+		return nil
+	}
+	repr, body, err := keyedObject(d.Representation)
+	if err != nil {
+		return fmt.Errorf("representation: %w", err)
+	}
+	t.repr = repr
+	switch repr {
+	case "map":
+		var r struct {
+			Fields map[string]struct {
+				Rename   string          `json:"rename"`
+				Implicit json.RawMessage `json:"implicit"`
+			} `json:"fields"`
+		}
+		if err := json.Unmarshal(body, &r); err != nil {
+			return fmt.Errorf("representation: %w", err)
+		}
+		for name, details := range r.Fields {
+			sf, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("representation: unknown field %s", name)
+			}
+			if details.Rename != "" {
+				sf.key = details.Rename
+			}
+			sf.implicit = len(details.Implicit) > 0
+		}
+	case "tuple":
+		var r struct {
+			FieldOrder []string `json:"fieldOrder"`
+		}
+		if err := json.Unmarshal(body, &r); err != nil {
+			return fmt.Errorf("representation: %w", err)
+		}
+		if len(r.FieldOrder) == 0 {
+			// the order of the DSL is lost in JSON: it has to be given
+			return fmt.Errorf("representation: tuple structs need a fieldOrder")
+		}
+		if len(r.FieldOrder) != len(t.fields) {
+			return fmt.Errorf("representation: fieldOrder has %d fields, the struct has %d", len(r.FieldOrder), len(t.fields))
+		}
+		t.fields = t.fields[:0]
+		for _, name := range r.FieldOrder {
+			sf, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("representation: unknown field %s", name)
+			}
+			t.fields = append(t.fields, sf)
+		}
+	default:
+		return fmt.Errorf("the %s representation is not supported", repr)
+	}
+	return nil
+}
+
+// memberNames reads the members of an enum or union, which are a list of
+// names, or the keys of an object in older schemas.
+func memberNames(raw json.RawMessage) ([]string, error) {
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list, nil
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("members: expected a list of names")
+	}
+	for name := range m {
+		list = append(list, name)
+	}
+	return list, nil
+}
+
+func parseEnum(t *schemaType, body json.RawMessage) error {
+	var d struct {
+		Members        json.RawMessage `json:"members"`
+		Representation json.RawMessage `json:"representation"`
+	}
+	if err := json.Unmarshal(body, &d); err != nil {
+		return err
+	}
+	names, err := memberNames(d.Members)
+	if err != nil {
+		return err
+	}
+
+	t.repr = "string"
+	var reprBody json.RawMessage
+	if len(d.Representation) > 0 {
+		if t.repr, reprBody, err = keyedObject(d.Representation); err != nil {
+			return fmt.Errorf("representation: %w", err)
+		}
+	}
+
+	t.members = make(map[string]string, len(names))
+	switch t.repr {
+	case "string":
+		var aliases map[string]string
+		if len(reprBody) > 0 {
+			if err := json.Unmarshal(reprBody, &aliases); err != nil {
+				return fmt.Errorf("representation: %w", err)
+			}
+		}
+		for _, name := range names {
+			value := name
+			if alias, ok := aliases[name]; ok {
+				value = alias
+			}
+			t.members[value] = name
+		}
+	case "int":
+		var values map[string]int64
+		if err := json.Unmarshal(reprBody, &values); err != nil {
+			return fmt.Errorf("representation: %w", err)
+		}
+		for _, name := range names {
+			value, ok := values[name]
+			if !ok {
+				return fmt.Errorf("representation: member %s has no value", name)
+			}
+			t.members[strconv.FormatInt(value, 10)] = name
+		}
+	default:
+		return fmt.Errorf("the %s representation is not supported", t.repr)
+	}
+	return nil
+}
+
+func parseUnion(t *schemaType, body json.RawMessage) error {
+	var d struct {
+		Representation json.RawMessage `json:"representation"`
+	}
+	if err := json.Unmarshal(body, &d); err != nil {
+		return err
+	}
+	if len(d.Representation) == 0 {
+		return fmt.Errorf("unions need a representation")
+	}
+	repr, reprBody, err := keyedObject(d.Representation)
+	if err != nil {
+		return fmt.Errorf("representation: %w", err)
+	}
+	t.repr = repr
+
+	switch repr {
+	case "keyed", "kinded":
+		err = json.Unmarshal(reprBody, &t.table)
+	case "envelope", "inline":
+		var r struct {
+			DiscriminantKey   string            `json:"discriminantKey"`
+			ContentKey        string            `json:"contentKey"`
+			DiscriminantTable map[string]string `json:"discriminantTable"`
+		}
+		err = json.Unmarshal(reprBody, &r)
+		t.discriminantKey, t.contentKey, t.table = r.DiscriminantKey, r.ContentKey, r.DiscriminantTable
+		if err == nil && (t.discriminantKey == "" || (repr == "envelope" && t.contentKey == "")) {
+			err = fmt.Errorf("missing discriminantKey or contentKey")
+		}
+	default:
+		return fmt.Errorf("the %s representation is not supported", repr)
+	}
+	if err != nil {
+		return fmt.Errorf("representation: %w", err)
+	}
+	if len(t.table) == 0 {
+		return fmt.Errorf("representation: the union has no members")
+	}
+	if repr == "kinded" {
+		for kind := range t.table {
+			if _, ok := kindByName(kind); !ok {
+				return fmt.Errorf("representation: unknown kind %q", kind)
+			}
+		}
+	}
+	return nil
+}
+
+func kindByName(name string) (ipldprime.Kind, bool) {
+	switch name {
+	case "map":
+		return ipldprime.Kind_Map, true
+	case "list":
+		return ipldprime.Kind_List, true
+	case "null":
+		return ipldprime.Kind_Null, true
+	}
+	k, ok := scalarKinds[name]
+	return k, ok
+}
+
+// resolve returns the type a reference points to, following copies.
+func (s *Schema) resolve(ref typeRef) (*schemaType, error) {
+	t := ref.inline
+	if t == nil {
+		var ok bool
+		if t, ok = s.types[ref.name]; !ok {
+			return nil, fmt.Errorf("unknown type %q", ref.name)
+		}
+	}
+	for i := 0; t.kind == "copy"; i++ {
+		if i == len(s.types) {
+			return nil, fmt.Errorf("type %s is a copy of itself", t.name)
+		}
+		from, ok := s.types[t.fromType]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %q", t.fromType)
+		}
+		t = from
+	}
+	return t, nil
+}
+
+// check verifies that the types t refers to exist, so that validation
+// doesn't have to.
+func (s *Schema) check(t *schemaType) error {
+	if _, err := s.resolve(typeRef{inline: t}); err != nil {
+		return err
+	}
+	var refs []typeRef
+	switch t.kind {
+	case "map":
+		key, err := s.resolve(t.keyType)
+		if err != nil {
+			return err
+		}
+		if key.kind != "string" && !(key.kind == "enum" && key.repr == "string") {
+			return fmt.Errorf("map keys must be strings, not %s", key)
+		}
+		refs = append(refs, t.valueType)
+	case "list":
+		refs = append(refs, t.valueType)
+	case "struct":
+		for _, f := range t.fields {
+			refs = append(refs, f.typ)
+		}
+	case "union":
+		for _, name := range t.table {
+			member, err := s.resolve(typeRef{name: name})
+			if err != nil {
+				return err
+			}
+			if t.repr == "inline" && (member.kind != "struct" || member.repr != "map") {
+				return fmt.Errorf("members of inline unions must be structs represented as maps, not %s", member)
+			}
+		}
+	}
+	for _, ref := range refs {
+		if _, err := s.resolve(ref); err != nil {
+			return err
+		}
+		if ref.inline != nil {
+			if err := s.check(ref.inline); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *schemaType) String() string {
+	if t.name != "" {
+		return t.name
+	}
+	return "a " + t.kind
+}
+
+// ValidationError is a value that doesn't conform to its type.
+type ValidationError struct {
+	// Path is the path of the value in the node, as in 'ipfs dag get'
+	Path    string
+	Message string
+	// Expected and Got are the kinds of a value of the wrong kind, and
+	// Kind_Invalid for other errors.
+	Expected, Got ipldprime.Kind
+}
+
+func (e *ValidationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ValidationErrors are all the errors found in a node.
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks that n conforms to the type typeName. The error, if n
+// doesn't, is a ValidationErrors listing all the values that don't.
+func (s *Schema) Validate(n ipldprime.Node, typeName string) error {
+	t, ok := s.types[typeName]
+	if !ok {
+		return fmt.Errorf("the schema has no type %q", typeName)
+	}
+	v := validator{s: s}
+	v.value("", n, s.mustResolve(typeRef{inline: t}))
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+// mustResolve resolves the references that check verified.
+func (s *Schema) mustResolve(ref typeRef) *schemaType {
+	t, err := s.resolve(ref)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+type validator struct {
+	s    *Schema
+	errs ValidationErrors
+}
+
+func (v *validator) fail(p string, format string, args ...interface{}) {
+	if p == "" {
+		p = "/"
+	}
+	v.errs = append(v.errs, &ValidationError{Path: p, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) expect(p string, n ipldprime.Node, t *schemaType, kind ipldprime.Kind) bool {
+	if n.Kind() != kind {
+		v.fail(p, "expected %s, got %s", t, n.Kind())
+		e := v.errs[len(v.errs)-1]
+		e.Expected, e.Got = kind, n.Kind()
+		return false
+	}
+	return true
+}
+
+// nullable validates a value that can be null if nullable is set.
+func (v *validator) nullable(p string, n ipldprime.Node, ref typeRef, nullable bool) {
+	if nullable && n.Kind() == ipldprime.Kind_Null {
+		return
+	}
+	v.value(p, n, v.s.mustResolve(ref))
+}
+
+func (v *validator) value(p string, n ipldprime.Node, t *schemaType) {
+	if kind, ok := scalarKinds[t.kind]; ok {
+		v.expect(p, n, t, kind)
+		return
+	}
+
+	switch t.kind {
+	case "any":
+	case "map":
+		if !v.expect(p, n, t, ipldprime.Kind_Map) {
+			return
+		}
+		key := v.s.mustResolve(t.keyType)
+		for it := n.MapIterator(); !it.Done(); {
+			k, val, err := it.Next()
+			if err != nil {
+				v.fail(p, "%s", err)
+				return
+			}
+			ks, _ := k.AsString()
+			if key.kind == "enum" {
+				v.value(p+"/"+ks, basicnode.NewString(ks), key)
+			}
+			v.nullable(p+"/"+ks, val, t.valueType, t.valueNullable)
+		}
+	case "list":
+		if !v.expect(p, n, t, ipldprime.Kind_List) {
+			return
+		}
+		for it := n.ListIterator(); !it.Done(); {
+			i, val, err := it.Next()
+			if err != nil {
+				v.fail(p, "%s", err)
+				return
+			}
+			v.nullable(p+"/"+strconv.FormatInt(i, 10), val, t.valueType, t.valueNullable)
+		}
+	case "struct":
+		if t.repr == "tuple" {
+			v.tuple(p, n, t)
+		} else {
+			v.structMap(p, n, t, "")
+		}
+	case "enum":
+		var value string
+		if t.repr == "int" {
+			if !v.expect(p, n, t, ipldprime.Kind_Int) {
+				return
+			}
+			i, _ := n.AsInt()
+			value = strconv.FormatInt(i, 10)
+		} else {
+			if !v.expect(p, n, t, ipldprime.Kind_String) {
+				return
+			}
+			value, _ = n.AsString()
+		}
+		if _, ok := t.members[value]; !ok {
+			v.fail(p, "%s is not a member of %s", value, t)
+		}
+	case "union":
+		v.union(p, n, t)
+	}
+}
+
+// structMap validates a struct represented as a map, without the key skip.
+func (v *validator) structMap(p string, n ipldprime.Node, t *schemaType, skip string) {
+	if !v.expect(p, n, t, ipldprime.Kind_Map) {
+		return
+	}
+	byKey := make(map[string]*structField, len(t.fields))
+	for _, f := range t.fields {
+		byKey[f.key] = f
+	}
+
+	seen := make(map[string]bool, len(t.fields))
+	for it := n.MapIterator(); !it.Done(); {
+		k, val, err := it.Next()
+		if err != nil {
+			v.fail(p, "%s", err)
+			return
+		}
+		ks, _ := k.AsString()
+		if ks == skip {
+			continue
+		}
+		f, ok := byKey[ks]
+		if !ok {
+			v.fail(p+"/"+ks, "unexpected field: %s has no field %s", t, ks)
+			continue
+		}
+		seen[ks] = true
+		v.nullable(p+"/"+ks, val, f.typ, f.nullable)
+	}
+	for _, f := range t.fields {
+		if !seen[f.key] && !f.optional && !f.implicit {
+			v.fail(p+"/"+f.key, "missing required field of %s", t)
+		}
+	}
+}
+
+func (v *validator) tuple(p string, n ipldprime.Node, t *schemaType) {
+	if !v.expect(p, n, t, ipldprime.Kind_List) {
+		return
+	}
+	// optional fields can only be left out at the end
+	required := len(t.fields)
+	for required > 0 && t.fields[required-1].optional {
+		required--
+	}
+	if l := int(n.Length()); l < required || l > len(t.fields) {
+		v.fail(p, "expected %s with %d to %d values, got %d", t, required, len(t.fields), l)
+		return
+	}
+	for it := n.ListIterator(); !it.Done(); {
+		i, val, err := it.Next()
+		if err != nil {
+			v.fail(p, "%s", err)
+			return
+		}
+		f := t.fields[i]
+		v.nullable(p+"/"+strconv.FormatInt(i, 10), val, f.typ, f.nullable)
+	}
+}
+
+func (v *validator) union(p string, n ipldprime.Node, t *schemaType) {
+	switch t.repr {
+	case "kinded":
+		member, ok := t.table[n.Kind().String()]
+		if !ok {
+			v.fail(p, "expected %s, which can't be a %s", t, n.Kind())
+			return
+		}
+		v.value(p, n, v.s.mustResolve(typeRef{name: member}))
+
+	case "keyed":
+		if !v.expect(p, n, t, ipldprime.Kind_Map) {
+			return
+		}
+		if n.Length() != 1 {
+			v.fail(p, "expected %s, with a single key, got %d keys", t, n.Length())
+			return
+		}
+		k, val, err := n.MapIterator().Next()
+		if err != nil {
+			v.fail(p, "%s", err)
+			return
+		}
+		ks, _ := k.AsString()
+		member, ok := t.table[ks]
+		if !ok {
+			v.fail(p+"/"+ks, "%s is not a key of %s", ks, t)
+			return
+		}
+		v.value(p+"/"+ks, val, v.s.mustResolve(typeRef{name: member}))
+
+	case "envelope", "inline":
+		if !v.expect(p, n, t, ipldprime.Kind_Map) {
+			return
+		}
+		member, ok := v.discriminant(p, n, t)
+		if !ok {
+			return
+		}
+		mt := v.s.mustResolve(typeRef{name: member})
+		if t.repr == "inline" {
+			v.structMap(p, n, mt, t.discriminantKey)
+			return
+		}
+		hasContent := false
+		for it := n.MapIterator(); !it.Done(); {
+			k, val, err := it.Next()
+			if err != nil {
+				v.fail(p, "%s", err)
+				return
+			}
+			switch ks, _ := k.AsString(); ks {
+			case t.discriminantKey:
+			case t.contentKey:
+				hasContent = true
+				v.value(p+"/"+ks, val, mt)
+			default:
+				v.fail(p+"/"+ks, "unexpected field: %s only has %s and %s", t, t.discriminantKey, t.contentKey)
+			}
+		}
+		if !hasContent {
+			v.fail(p+"/"+t.contentKey, "missing content of %s", t)
+		}
+	}
+}
+
+// discriminant returns the member of the envelope or inline union t that n
+// says it is.
+func (v *validator) discriminant(p string, n ipldprime.Node, t *schemaType) (string, bool) {
+	dp := p + "/" + t.discriminantKey
+	d, err := n.LookupByString(t.discriminantKey)
+	if err != nil {
+		v.fail(dp, "missing discriminant of %s", t)
+		return "", false
+	}
+	ds, err := d.AsString()
+	if err != nil {
+		v.fail(dp, "expected a string discriminant, got %s", d.Kind())
+		return "", false
+	}
+	member, ok := t.table[ds]
+	if !ok {
+		v.fail(dp, "%s is not a discriminant of %s", ds, t)
+		return "", false
+	}
+	return member, true
+}
@@ -0,0 +1,201 @@
+package coredag
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+)
+
+const testSchema = `{"types": {
+  "Record": {"struct": {
+    "fields": {
+      "name": {"type": "String"},
+      "age": {"type": "Int", "optional": true},
+      "color": {"type": "Color", "optional": true},
+      "tags": {"type": {"list": {"valueType": "String"}}, "optional": true},
+      "parent": {"type": "Link", "optional": true, "nullable": true},
+      "shape": {"type": "Shape", "optional": true},
+      "event": {"type": "Event", "optional": true}
+    },
+    "representation": {"map": {"fields": {"name": {"rename": "n"}}}}
+  }},
+  "Color": {"enum": {"members": ["Red", "Green"], "representation": {"string": {"Red": "r"}}}},
+  "Shape": {"union": {"members": ["Circle", "Square"], "representation": {"keyed": {"circle": "Circle", "square": "Square"}}}},
+  "Circle": {"struct": {"fields": {"r": {"type": "Float"}}}},
+  "Square": {"struct": {"fields": {"side": {"type": "Int"}}, "representation": {"tuple": {"fieldOrder": ["side"]}}}},
+  "Event": {"union": {"members": ["Circle"], "representation": {"inline": {"discriminantKey": "kind", "discriminantTable": {"circle": "Circle"}}}}},
+  "Meta": {"map": {"keyType": "String", "valueType": "Record"}},
+  "AnyRecord": {"copy": {"fromType": "Record"}}
+}}`
+
+func TestSchemaValidate(t *testing.T) {
+	s, err := LoadSchema([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := strings.Join(s.TypeNames(), ","); names != "AnyRecord,Circle,Color,Event,Meta,Record,Shape,Square" {
+		t.Fatalf("unexpected type names %s", names)
+	}
+
+	cases := []struct {
+		typ, value string
+		errs       []string
+	}{
+		{"Record", `{"n": "a"}`, nil},
+		{"Record", `{"n": "a", "age": 3, "color": "r", "tags": ["x"], "parent": null, "shape": {"square": [2]}, "event": {"kind": "circle", "r": 1.5}}`, nil},
+		{"Record", `{"n": "a", "parent": {"/": "bafyreifbswkeh7y23xoruvdjjfw4clhjjufkvh5ynipjjgdkfrxp4ojcbm"}}`, nil},
+		{"AnyRecord", `{"n": "a"}`, nil},
+		{"Meta", `{"x": {"n": "a"}}`, nil},
+		{"Record", `[]`, []string{"/: expected Record, got list"}},
+		{"Record", `{"age": 1}`, []string{"/n: missing required field of Record"}},
+		{"Record", `{"n": "a", "name": "b"}`, []string{"/name: unexpected field: Record has no field name"}},
+		{"Record", `{"n": "a", "age": 1.5}`, []string{"/age: expected Int, got float"}},
+		{"Record", `{"n": "a", "color": "Red"}`, []string{"/color: Red is not a member of Color"}},
+		{"Record", `{"n": "a", "tags": ["x", 1, null]}`, []string{"/tags/1: expected String, got int", "/tags/2: expected String, got null"}},
+		{"Record", `{"n": "a", "parent": "x"}`, []string{"/parent: expected Link, got string"}},
+		{"Record", `{"n": "a", "shape": {"triangle": {}}}`, []string{"/shape/triangle: triangle is not a key of Shape"}},
+		{"Record", `{"n": "a", "shape": {"square": []}}`, []string{"/shape/square: expected Square with 1 to 1 values, got 0"}},
+		{"Record", `{"n": "a", "event": {"kind": "circle"}}`, []string{"/event/r: missing required field of Circle"}},
+		{"Record", `{"n": "a", "event": {"kind": "square"}}`, []string{"/event/kind: square is not a discriminant of Event"}},
+		{"Meta", `{"x": {"n": 1}}`, []string{"/x/n: expected String, got int"}},
+	}
+	for _, c := range cases {
+		checkValidate(t, s, c.typ, c.value, c.errs)
+	}
+}
+
+// checkValidate validates the dag-json value against typ, and checks that
+// the errors are errs.
+func checkValidate(t *testing.T, s *Schema, typ, value string, errs []string) {
+	t.Helper()
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagjson.Decode(nb, strings.NewReader(value)); err != nil {
+		t.Fatal(err)
+	}
+	err := s.Validate(nb.Build(), typ)
+	if errs == nil {
+		if err != nil {
+			t.Errorf("%s %s: %s", typ, value, err)
+		}
+		return
+	}
+	verrs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Errorf("%s %s: expected validation errors, got %v", typ, value, err)
+		return
+	}
+	var got []string
+	for _, e := range verrs {
+		got = append(got, e.Error())
+	}
+	if strings.Join(got, "\n") != strings.Join(errs, "\n") {
+		t.Errorf("%s %s: expected errors\n%s\ngot\n%s", typ, value, strings.Join(errs, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestSchemaKinds(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		types string // the types of the schema, without the braces
+		value string
+		errs  []string
+	}{
+		// scalars
+		{"bool", `"T": {"bool": {}}`, `true`, nil},
+		{"bool/string", `"T": {"bool": {}}`, `"true"`, []string{"/: expected T, got string"}},
+		{"string", `"T": {"string": {}}`, `"a"`, nil},
+		{"bytes/string", `"T": {"bytes": {}}`, `"aGk"`, []string{"/: expected T, got string"}},
+		{"int", `"T": {"int": {}}`, `1`, nil},
+		{"int/float", `"T": {"int": {}}`, `1.0`, []string{"/: expected T, got float"}},
+		{"float", `"T": {"float": {}}`, `1.5`, nil},
+		{"float/int", `"T": {"float": {}}`, `1`, []string{"/: expected T, got int"}},
+		{"link", `"T": {"link": {}}`, `{"/": "bafyreifbswkeh7y23xoruvdjjfw4clhjjufkvh5ynipjjgdkfrxp4ojcbm"}`, nil},
+		{"link/map", `"T": {"link": {}}`, `{"a": 1}`, []string{"/: expected T, got map"}},
+
+		// maps and lists
+		{"map", `"T": {"map": {"keyType": "String", "valueType": "Int"}}`, `{"a": 1, "b": 2}`, nil},
+		{"map/value", `"T": {"map": {"keyType": "String", "valueType": "Int"}}`, `{"a": 1, "b": "2"}`, []string{"/b: expected Int, got string"}},
+		{"map/null", `"T": {"map": {"keyType": "String", "valueType": "Int"}}`, `{"a": null}`, []string{"/a: expected Int, got null"}},
+		{"map/nullable", `"T": {"map": {"keyType": "String", "valueType": "Int", "valueNullable": true}}`, `{"a": null}`, nil},
+		{"map/enum keys", `"T": {"map": {"keyType": "E", "valueType": "Int"}}, "E": {"enum": {"members": ["a"]}}`, `{"a": 1, "b": 2}`, []string{"/b: b is not a member of E"}},
+		{"list", `"T": {"list": {"valueType": "String"}}`, `["a", "b"]`, nil},
+		{"list/value", `"T": {"list": {"valueType": "String"}}`, `["a", 1]`, []string{"/1: expected String, got int"}},
+		{"list/nullable", `"T": {"list": {"valueType": "String", "valueNullable": true}}`, `["a", null]`, nil},
+		{"list/inline", `"T": {"list": {"valueType": {"list": {"valueType": "Int"}}}}`, `[[1], ["a"]]`, []string{"/1/0: expected Int, got string"}},
+		{"list/map", `"T": {"list": {"valueType": "String"}}`, `{}`, []string{"/: expected T, got map"}},
+
+		// structs
+		{"struct/map", `"T": {"struct": {"fields": {"a": {"type": "Int"}, "b": {"type": "String", "optional": true}}}}`, `{"a": 1}`, nil},
+		{"struct/map missing", `"T": {"struct": {"fields": {"a": {"type": "Int"}}}}`, `{}`, []string{"/a: missing required field of T"}},
+		{"struct/map nullable", `"T": {"struct": {"fields": {"a": {"type": "Int", "nullable": true}}}}`, `{"a": null}`, nil},
+		{"struct/map rename", `"T": {"struct": {"fields": {"a": {"type": "Int"}}, "representation": {"map": {"fields": {"a": {"rename": "A"}}}}}}`, `{"a": 1}`, []string{"/a: unexpected field: T has no field a", "/A: missing required field of T"}},
+		{"struct/map implicit", `"T": {"struct": {"fields": {"a": {"type": "Int"}}, "representation": {"map": {"fields": {"a": {"implicit": 0}}}}}}`, `{}`, nil},
+		{"struct/tuple", `"T": {"struct": {"fields": {"a": {"type": "Int"}, "b": {"type": "String", "optional": true}}, "representation": {"tuple": {"fieldOrder": ["a", "b"]}}}}`, `[1]`, nil},
+		{"struct/tuple types", `"T": {"struct": {"fields": {"a": {"type": "Int"}, "b": {"type": "String"}}, "representation": {"tuple": {"fieldOrder": ["a", "b"]}}}}`, `["x", "y"]`, []string{"/0: expected Int, got string"}},
+		{"struct/tuple length", `"T": {"struct": {"fields": {"a": {"type": "Int"}}, "representation": {"tuple": {"fieldOrder": ["a"]}}}}`, `[1, 2]`, []string{"/: expected T with 1 to 1 values, got 2"}},
+
+		// enums
+		{"enum/string", `"T": {"enum": {"members": ["A", "B"]}}`, `"B"`, nil},
+		{"enum/string alias", `"T": {"enum": {"members": ["A", "B"], "representation": {"string": {"A": "a"}}}}`, `"a"`, nil},
+		{"enum/string member", `"T": {"enum": {"members": ["A", "B"], "representation": {"string": {"A": "a"}}}}`, `"A"`, []string{"/: A is not a member of T"}},
+		{"enum/int", `"T": {"enum": {"members": ["A", "B"], "representation": {"int": {"A": 1, "B": 2}}}}`, `2`, nil},
+		{"enum/int value", `"T": {"enum": {"members": ["A", "B"], "representation": {"int": {"A": 1, "B": 2}}}}`, `3`, []string{"/: 3 is not a member of T"}},
+		{"enum/int string", `"T": {"enum": {"members": ["A"], "representation": {"int": {"A": 1}}}}`, `"A"`, []string{"/: expected T, got string"}},
+
+		// unions
+		{"union/keyed", `"T": {"union": {"representation": {"keyed": {"i": "Int", "s": "String"}}}}`, `{"s": "a"}`, nil},
+		{"union/keyed member", `"T": {"union": {"representation": {"keyed": {"i": "Int", "s": "String"}}}}`, `{"i": "a"}`, []string{"/i: expected Int, got string"}},
+		{"union/keyed keys", `"T": {"union": {"representation": {"keyed": {"i": "Int"}}}}`, `{"i": 1, "j": 2}`, []string{"/: expected T, with a single key, got 2 keys"}},
+		{"union/kinded", `"T": {"union": {"representation": {"kinded": {"int": "Int", "string": "String"}}}}`, `1`, nil},
+		{"union/kinded kind", `"T": {"union": {"representation": {"kinded": {"int": "Int", "string": "String"}}}}`, `1.5`, []string{"/: expected T, which can't be a float"}},
+		{"union/envelope", `"T": {"union": {"representation": {"envelope": {"discriminantKey": "tag", "contentKey": "content", "discriminantTable": {"i": "Int"}}}}}`, `{"tag": "i", "content": 1}`, nil},
+		{"union/envelope content", `"T": {"union": {"representation": {"envelope": {"discriminantKey": "tag", "contentKey": "content", "discriminantTable": {"i": "Int"}}}}}`, `{"tag": "i"}`, []string{"/content: missing content of T"}},
+		{"union/envelope discriminant", `"T": {"union": {"representation": {"envelope": {"discriminantKey": "tag", "contentKey": "content", "discriminantTable": {"i": "Int"}}}}}`, `{"tag": 1, "content": 1}`, []string{"/tag: expected a string discriminant, got int"}},
+		{"union/inline", `"T": {"union": {"representation": {"inline": {"discriminantKey": "tag", "discriminantTable": {"a": "A"}}}}}, "A": {"struct": {"fields": {"x": {"type": "Int"}}}}`, `{"tag": "a", "x": 1}`, nil},
+		{"union/inline member", `"T": {"union": {"representation": {"inline": {"discriminantKey": "tag", "discriminantTable": {"a": "A"}}}}}, "A": {"struct": {"fields": {"x": {"type": "Int"}}}}`, `{"tag": "a", "x": 1.5}`, []string{"/x: expected Int, got float"}},
+		{"union/inline discriminant", `"T": {"union": {"representation": {"inline": {"discriminantKey": "tag", "discriminantTable": {"a": "A"}}}}}, "A": {"struct": {"fields": {"x": {"type": "Int"}}}}`, `{"x": 1}`, []string{"/tag: missing discriminant of T"}},
+
+		// copies are validated as the type they copy
+		{"copy", `"T": {"copy": {"fromType": "U"}}, "U": {"list": {"valueType": "Int"}}`, `[1]`, nil},
+		{"copy/value", `"T": {"copy": {"fromType": "U"}}, "U": {"list": {"valueType": "Int"}}`, `[1.5]`, []string{"/0: expected Int, got float"}},
+		{"copy/chain", `"T": {"copy": {"fromType": "U"}}, "U": {"copy": {"fromType": "Int"}}`, `"a"`, []string{"/: expected Int, got string"}},
+
+		// the prelude
+		{"any", `"T": {"list": {"valueType": "Any"}}`, `[1, "a", null, {"b": []}]`, nil},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := LoadSchema([]byte(`{"types": {` + c.types + `}}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkValidate(t, s, "T", c.value, c.errs)
+		})
+	}
+
+	// the dag-json decoder reads bytes as strings
+	s, err := LoadSchema([]byte(`{"types": {"T": {"bytes": {}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate(basicnode.NewBytes([]byte("hi")), "T"); err != nil {
+		t.Errorf("bytes: %s", err)
+	}
+}
+
+func TestLoadSchemaErrors(t *testing.T) {
+	for _, c := range []struct{ schema, err string }{
+		{`{}`, "invalid schema: no types"},
+		{`{"types": {"A": {"struct": {"fields": {"b": {"type": "B"}}}}}}`, `invalid schema: type A: unknown type "B"`},
+		{`{"types": {"A": {"map": {"keyType": "Int", "valueType": "String"}}}}`, "invalid schema: type A: map keys must be strings, not Int"},
+		{`{"types": {"A": {"map": {"keyType": "String", "valueType": "String", "representation": {"listpairs": {}}}}}}`, "invalid schema: type A: the listpairs representation is not supported"},
+		{`{"types": {"A": {"copy": {"fromType": "A"}}}}`, "invalid schema: type A: type A is a copy of itself"},
+		{`{"types": {"A": {"union": {"representation": {"inline": {"discriminantKey": "k", "discriminantTable": {"s": "String"}}}}}}}`, "invalid schema: type A: members of inline unions must be structs represented as maps, not String"},
+	} {
+		_, err := LoadSchema([]byte(c.schema))
+		if err == nil || err.Error() != c.err {
+			t.Errorf("%s: expected error %q, got %v", c.schema, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package corehttp
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	oldcmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
+
+	ipld "github.com/ipfs/go-ipld-format"
+	merkledag "github.com/ipfs/go-merkledag"
+)
+
+func TestDagExportProgressTrailer(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	cctx := oldcmds.Context{
+		ConfigRoot: "/tmp/.mockipfsconfig",
+		ReqLog:     &oldcmds.ReqLog{},
+		ConstructNode: func() (*core.IpfsNode, error) {
+			return n, nil
+		},
+	}
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(ts.Close)
+	dh.Handler, err = makeHandler(n, ts.Listener, CommandsOption(cctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := merkledag.NodeWithData([]byte("child"))
+	complete := merkledag.NodeWithData([]byte("complete"))
+	if err := complete.AddNodeLink("child", child); err != nil {
+		t.Fatal(err)
+	}
+	// the child of incomplete isn't in the blockstore
+	missing := merkledag.NodeWithData([]byte("missing"))
+	incomplete := merkledag.NodeWithData([]byte("incomplete"))
+	if err := incomplete.AddNodeLink("missing", missing); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.DAG.AddMany(ctx, []ipld.Node{child, complete, incomplete}); err != nil {
+		t.Fatal(err)
+	}
+
+	export := func(arg string) string {
+		res, err := http.Post(ts.URL+APIPath+"/dag/export?offline=true&arg="+arg, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("export of %s: status %d", arg, res.StatusCode)
+		}
+		// trailers are only known once the body was read
+		if _, err := ioutil.ReadAll(res.Body); err != nil {
+			t.Fatal(err)
+		}
+		return res.Trailer.Get("X-Car-Progress")
+	}
+
+	if got, want := export(complete.Cid().String()), "blocks=2; last="+child.Cid().String()+"; complete=true"; got != want {
+		t.Errorf("expected trailer %q, got %q", want, got)
+	}
+	if got, want := export(incomplete.Cid().String()), "blocks=1; last="+incomplete.Cid().String()+"; complete=false"; got != want {
+		t.Errorf("expected trailer %q, got %q", want, got)
+	}
+	if got := export(complete.Cid().String() + "&resume-from=1"); !strings.HasPrefix(got, "blocks=2; ") {
+		t.Errorf("expected a resumed export to count the skipped blocks, got %q", got)
+	}
+}
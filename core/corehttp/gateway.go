@@ -5,10 +5,12 @@ import (
 	"net"
 	"net/http"
 	"sort"
+	"time"
 
 	version "github.com/ipfs/go-ipfs"
 	core "github.com/ipfs/go-ipfs/core"
 	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+	"github.com/ipfs/go-ipfs/core/node"
 
 	options "github.com/ipfs/interface-go-ipfs-core/options"
 	id "github.com/libp2p/go-libp2p/p2p/protocol/identify"
@@ -18,6 +20,13 @@ type GatewayConfig struct {
 	Headers      map[string][]string
 	Writable     bool
 	PathPrefixes []string
+
+	// Retrieval timeouts, zero disables them. ResolveTimeout bounds path
+	// resolution, FirstByteTimeout the time until the response starts, and
+	// StallTimeout the time between writes once it has started.
+	ResolveTimeout   time.Duration
+	FirstByteTimeout time.Duration
+	StallTimeout     time.Duration
 }
 
 // A helper function to clean up a set of headers:
@@ -87,11 +96,20 @@ func GatewayOption(writable bool, paths ...string) ServeOption {
 				"X-Stream-Output",
 			}, headers[ACEHeadersName]...))
 
-		gateway := newGatewayHandler(GatewayConfig{
+		gwCfg := GatewayConfig{
 			Headers:      headers,
 			Writable:     writable,
 			PathPrefixes: cfg.Gateway.PathPrefixes,
-		}, api)
+		}
+		if err := addTimeoutsFromConfig(n.Repo, &gwCfg); err != nil {
+			return nil, err
+		}
+		gateway := newGatewayHandler(gwCfg, api)
+		if n.IpnsRecords != nil {
+			gateway.names = newNameTTLs(n.IpnsRecords, func(domain string) string {
+				return node.DNSResolverURL(cfg, domain)
+			})
+		}
 
 		for _, p := range paths {
 			mux.Handle(p+"/", gateway)
@@ -2,6 +2,7 @@ package corehttp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -21,6 +22,7 @@ import (
 	"github.com/ipfs/go-cid"
 	files "github.com/ipfs/go-ipfs-files"
 	assets "github.com/ipfs/go-ipfs/assets"
+	"github.com/ipfs/go-ipfs/denylist"
 	dag "github.com/ipfs/go-merkledag"
 	mfs "github.com/ipfs/go-mfs"
 	path "github.com/ipfs/go-path"
@@ -62,6 +64,9 @@ type redirectTemplateData struct {
 type gatewayHandler struct {
 	config GatewayConfig
 	api    coreiface.CoreAPI
+	names  *nameTTLs // TTLs of /ipns/ names, nil if unknown
+
+	redirects *redirectsCache
 }
 
 // StatusResponseWriter enables us to override HTTP Status Code passed to
@@ -85,8 +90,9 @@ func (sw *statusResponseWriter) WriteHeader(code int) {
 
 func newGatewayHandler(c GatewayConfig, api coreiface.CoreAPI) *gatewayHandler {
 	i := &gatewayHandler{
-		config: c,
-		api:    api,
+		config:    c,
+		api:       api,
+		redirects: newRedirectsCache(),
 	}
 	return i
 }
@@ -141,7 +147,7 @@ func (i *gatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet, http.MethodHead:
-		i.getOrHeadHandler(w, r)
+		i.serveWithTimeouts(w, r, i.getOrHeadHandler)
 		return
 	case http.MethodOptions:
 		i.optionsHandler(w, r)
@@ -249,14 +255,40 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Detect when explicit response format was requested
+	responseFormat, formatParams, err := customResponseFormat(r)
+	if err != nil {
+		webError(w, "error while processing the Accept header", err, http.StatusBadRequest)
+		return
+	}
+
 	// Resolve path to the final DAG node for the ETag
-	resolvedPath, err := i.api.ResolvePath(r.Context(), parsedPath)
+	resolveCtx, cancelResolve := r.Context(), context.CancelFunc(func() {})
+	if i.config.ResolveTimeout > 0 {
+		resolveCtx, cancelResolve = context.WithTimeout(resolveCtx, i.config.ResolveTimeout)
+	}
+	defer cancelResolve()
+	resolvedPath, err := i.api.ResolvePath(resolveCtx, parsedPath)
+	timedOut := resolveCtx.Err() == context.DeadlineExceeded && r.Context().Err() == nil
+	if err != nil && timedOut {
+		err := fmt.Errorf("path could not be resolved within %s", i.config.ResolveTimeout)
+		webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusGatewayTimeout)
+		return
+	}
+	if errors.Is(err, denylist.ErrBlocked) {
+		webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusGone)
+		return
+	}
 	switch err {
 	case nil:
 	case coreiface.ErrOffline:
 		webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusServiceUnavailable)
 		return
 	default:
+		if i.serveRedirectsIfPresent(w, r, parsedPath, requestURI.Path) {
+			return
+		}
+
 		if i.servePretty404IfPresent(w, r, parsedPath) {
 			return
 		}
@@ -265,6 +297,35 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	w = i.withMutableCacheHeaders(resolveCtx, w, parsedPath)
+	i.setIpfsRootsHeader(resolveCtx, w, resolvedPath)
+
+	// Verifiable response formats are served before touching UnixFS, they
+	// work for any DAG the path resolves to. Archives are UnixFS, but
+	// streamed as a whole.
+	switch responseFormat {
+	case "": // UnixFS, handled below
+	case "application/vnd.ipld.raw":
+		i.serveRawBlock(w, r, resolvedPath)
+		return
+	case "application/vnd.ipld.car":
+		i.serveCar(w, r, resolvedPath, formatParams["version"])
+		return
+	case "application/x-tar", "application/zip":
+		i.serveArchive(w, r, resolvedPath, responseFormat)
+		return
+	default:
+		err := fmt.Errorf("unsupported format %q", responseFormat)
+		webError(w, "failed to respond with requested content type", err, http.StatusBadRequest)
+		return
+	}
+
+	// Structured data (dag-cbor, dag-json) is not UnixFS, render it as-is
+	if isCodecNode(resolvedPath.Cid()) {
+		i.serveCodec(w, r, resolvedPath, originalUrlPath)
+		return
+	}
+
 	dr, err := i.api.Unixfs().Get(r.Context(), resolvedPath)
 	if err != nil {
 		webError(w, "ipfs cat "+escapedURLPath, err, http.StatusNotFound)
@@ -301,6 +362,12 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 	// and only if it's /ipfs!
 	// TODO: break this out when we split /ipfs /ipns routes.
 	modtime := time.Now()
+	if parsedPath.Namespace() == "ipns" {
+		// We can't tell when mutable content last changed, and time.Now()
+		// would make caches revalidate it on every request: rely on the
+		// Etag and the Cache-Control set from the TTL of the name instead.
+		modtime = time.Time{}
+	}
 
 	if f, ok := dr.(files.File); ok {
 		if strings.HasPrefix(urlPath, ipfsPathPrefix) {
@@ -317,14 +384,12 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 			if r.URL.Query().Get("download") == "true" {
 				disposition = "attachment"
 			}
-			utf8Name := url.PathEscape(urlFilename)
-			asciiName := url.PathEscape(onlyAscii.ReplaceAllLiteralString(urlFilename, "_"))
-			w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"; filename*=UTF-8''%s", disposition, asciiName, utf8Name))
+			setContentDispositionHeader(w, urlFilename, disposition)
 			name = urlFilename
 		} else {
 			name = getFilename(urlPath)
 		}
-		i.serveFile(w, r, name, modtime, f)
+		i.serveFile(w, r, resolvedPath, name, modtime, f)
 		return
 	}
 	dir, ok := dr.(files.Directory)
@@ -333,7 +398,11 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	idx, err := i.api.Unixfs().Get(r.Context(), ipath.Join(resolvedPath, "index.html"))
+	idxPath, err := i.api.ResolvePath(r.Context(), ipath.Join(resolvedPath, "index.html"))
+	var idx files.Node
+	if err == nil {
+		idx, err = i.api.Unixfs().Get(r.Context(), idxPath)
+	}
 	switch err.(type) {
 	case nil:
 		dirwithoutslash := urlPath[len(urlPath)-1] != '/'
@@ -356,7 +425,7 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		}
 
 		// write to request
-		i.serveFile(w, r, "index.html", modtime, f)
+		i.serveFile(w, r, idxPath, "index.html", modtime, f)
 		return
 	case resolver.ErrNoLink:
 		// no index.html; noop
@@ -374,6 +443,12 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	page, err := parseListingPage(r.URL.Query())
+	if err != nil {
+		webError(w, "failed to parse directory listing page", err, http.StatusBadRequest)
+		return
+	}
+
 	// A HTML directory index will be presented, be sure to set the correct
 	// type instead of relying on autodetection (which may fail).
 	w.Header().Set("Content-Type", "text/html")
@@ -381,35 +456,43 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// storage for directory listing
-	var dirListing []directoryItem
-	dirit := dir.Entries()
-	for dirit.Next() {
-		size := "?"
-		if s, err := dirit.Node().Size(); err == nil {
-			// Size may not be defined/supported. Continue anyways.
-			size = humanize.Bytes(uint64(s))
+	// Entries are streamed into the template as they are enumerated, so that
+	// huge (sharded) directories neither have to fit in memory nor be fully
+	// enumerated before the first byte is sent.
+	listCtx, cancelList := context.WithCancel(r.Context())
+	defer cancelList()
+	listPage := page
+	if page.limit > 0 {
+		// one more entry tells us whether there is a next page
+		listPage.limit++
+	}
+	// See comment above where originalUrlPath is declared.
+	dirListing, listErr, err := i.listDirectory(listCtx, resolvedPath, originalUrlPath, listPage)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+	if page.limit > 0 {
+		// A page is small enough to be listed before rendering it, so that
+		// the link to the next one can be sent along with the headers.
+		var items []directoryItem
+		for item := range dirListing {
+			items = append(items, item)
 		}
-
-		hash := ""
-		if r, err := i.api.ResolvePath(r.Context(), ipath.Join(resolvedPath, dirit.Name())); err == nil {
-			// Path may not be resolved. Continue anyways.
-			hash = r.Cid().String()
+		if err := listErr(); err != nil {
+			internalWebError(w, err)
+			return
 		}
-
-		// See comment above where originalUrlPath is declared.
-		di := directoryItem{
-			Size:      size,
-			Name:      dirit.Name(),
-			Path:      gopath.Join(originalUrlPath, dirit.Name()),
-			Hash:      hash,
-			ShortHash: shortHash(hash),
+		if len(items) > page.limit {
+			items = items[:page.limit]
+			w.Header().Set("Link", `<`+page.nextURL(originalUrlPath, r.URL.Query())+`>; rel="next"`)
 		}
-		dirListing = append(dirListing, di)
-	}
-	if dirit.Err() != nil {
-		internalWebError(w, dirit.Err())
-		return
+		pageListing := make(chan directoryItem, len(items))
+		for _, item := range items {
+			pageListing <- item
+		}
+		close(pageListing)
+		dirListing = pageListing
 	}
 
 	// construct the correct back link
@@ -473,22 +556,38 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		internalWebError(w, err)
 		return
 	}
+	if err := listErr(); err != nil {
+		// the status was sent with the first entries, all we can do is log it
+		log.Warnf("directory listing of %s is incomplete: %s", urlPath, err)
+	}
 }
 
-func (i *gatewayHandler) serveFile(w http.ResponseWriter, req *http.Request, name string, modtime time.Time, file files.File) {
+func (i *gatewayHandler) serveFile(w http.ResponseWriter, req *http.Request, resolvedPath ipath.Resolved, name string, modtime time.Time, file files.File) {
 	size, err := file.Size()
 	if err != nil {
 		http.Error(w, "cannot serve files with unknown sizes", http.StatusBadGateway)
 		return
 	}
 
-	content := &lazySeeker{
+	_, isSymlink := file.(*files.Symlink)
+
+	// Read UnixFS files straight from the DAG, so that range requests only
+	// fetch the blocks they need. Fall back to the file reader otherwise.
+	var content io.ReadSeeker = &lazySeeker{
 		size:   size,
 		reader: file,
 	}
+	if !isSymlink && resolvedPath.Remainder() == "" {
+		if nd, err := i.api.Dag().Get(req.Context(), resolvedPath.Cid()); err == nil {
+			ranges := requestedRanges(req, w.Header().Get("Etag"), modtime, size)
+			if s, err := newDagRangeSeeker(req.Context(), i.api.Dag(), nd, size, ranges); err == nil {
+				content = s
+			}
+		}
+	}
 
 	var ctype string
-	if _, isSymlink := file.(*files.Symlink); isSymlink {
+	if isSymlink {
 		// We should be smarter about resolving symlinks but this is the
 		// "most correct" we can be without doing that.
 		ctype = "inode/symlink"
@@ -524,6 +623,14 @@ func (i *gatewayHandler) serveFile(w http.ResponseWriter, req *http.Request, nam
 	http.ServeContent(w, req, name, modtime, content)
 }
 
+// setContentDispositionHeader sets a Content-Disposition header with both the
+// ASCII-only and the UTF-8 (RFC 5987) variant of the filename.
+func setContentDispositionHeader(w http.ResponseWriter, filename string, disposition string) {
+	utf8Name := url.PathEscape(filename)
+	asciiName := url.PathEscape(onlyAscii.ReplaceAllLiteralString(filename, "_"))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"; filename*=UTF-8''%s", disposition, asciiName, utf8Name))
+}
+
 func (i *gatewayHandler) servePretty404IfPresent(w http.ResponseWriter, r *http.Request, parsedPath ipath.Path) bool {
 	resolved404Path, ctype, err := i.searchUpTreeFor404(r, parsedPath)
 	if err != nil {
@@ -810,6 +917,40 @@ func preferred404Filename(acceptHeaders []string) (string, string, error) {
 	return "", "", fmt.Errorf("there is no 404 file for the requested content types")
 }
 
+// customResponseFormat returns the media type explicitly requested with the
+// ?format= query parameter or an application/vnd.ipld.* Accept header.
+// An empty media type means the default (deserialized UnixFS) response.
+func customResponseFormat(r *http.Request) (mediaType string, params map[string]string, err error) {
+	if formatParam := r.URL.Query().Get("format"); formatParam != "" {
+		// translate query param to a content type
+		switch formatParam {
+		case "raw":
+			return "application/vnd.ipld.raw", nil, nil
+		case "car":
+			return "application/vnd.ipld.car", nil, nil
+		case "tar":
+			return "application/x-tar", nil, nil
+		case "zip":
+			return "application/zip", nil, nil
+		default:
+			return "", nil, fmt.Errorf("unknown format %q", formatParam)
+		}
+	}
+	// Browsers and other user agents will send Accept header with generic
+	// types like text/html or */*, we only care about explicit, IPLD-specific
+	// content types.
+	for _, accept := range r.Header.Values("Accept") {
+		for _, spec := range strings.Split(accept, ",") {
+			spec = strings.TrimSpace(spec)
+			// respond to the very first ipld content type
+			if strings.HasPrefix(spec, "application/vnd.ipld.") {
+				return mime.ParseMediaType(spec)
+			}
+		}
+	}
+	return "", nil, nil
+}
+
 // Attempt to fix redundant /ipfs/ namespace as long as resulting
 // 'intended' path is valid.  This is in case gremlins were tickled
 // wrong way and user ended up at /ipfs/ipfs/{cid} or /ipfs/ipns/{id}
@@ -0,0 +1,240 @@
+package corehttp
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	files "github.com/ipfs/go-ipfs-files"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// archiveFormat describes an archive a UnixFS file or directory can be
+// downloaded as.
+type archiveFormat struct {
+	ext   string
+	write func(w io.Writer, nd files.Node, name string) error
+}
+
+var archiveFormats = map[string]archiveFormat{
+	"application/x-tar": {ext: ".tar", write: writeTarArchive},
+	"application/zip":   {ext: ".zip", write: writeZipArchive},
+}
+
+// serveArchive streams the UnixFS file or directory behind resolvedPath as a
+// single archive, so that a whole directory can be downloaded in one go.
+func (i *gatewayHandler) serveArchive(w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, mediaType string) {
+	format, ok := archiveFormats[mediaType]
+	if !ok {
+		err := fmt.Errorf("unsupported archive format %q", mediaType)
+		webError(w, "failed to respond with requested content type", err, http.StatusBadRequest)
+		return
+	}
+
+	// Weak Etag because archives are not byte-for-byte reproducible
+	// (headers contain the time they were written at).
+	etag := `W/"` + resolvedPath.Cid().String() + format.ext + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// the top-level entry of the archive is named after the last path segment,
+	// like 'ipfs get' does
+	name, err := archiveName(r)
+	if err != nil {
+		webError(w, "invalid archive name", err, http.StatusBadRequest)
+		return
+	}
+
+	nd, err := i.api.Unixfs().Get(r.Context(), resolvedPath)
+	if err != nil {
+		webError(w, "ipfs get "+r.URL.EscapedPath(), err, http.StatusNotFound)
+		return
+	}
+	defer nd.Close()
+
+	i.addUserHeaders(w) // ok, _now_ write user's headers.
+	w.Header().Set("X-IPFS-Path", r.URL.Path)
+	w.Header().Set("Etag", etag)
+	setContentDispositionHeader(w, name+format.ext, "attachment")
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// The HTTP status is sent before all files are known to be retrievable,
+	// so a failure mid-stream can only be reported in a trailer.
+	w.Header().Set("Trailer", "X-Stream-Error")
+
+	if err := format.write(w, nd, name); err != nil {
+		w.Header().Set("X-Stream-Error", err.Error())
+		log.Warnf("failed to stream %s archive for %s: %s", format.ext, resolvedPath, err)
+		return
+	}
+}
+
+// archiveName returns the name of the top-level entry of an archive: the
+// last element of the ?filename= parameter (without the archive extension),
+// or the last segment of the requested path. All the entries are prefixed
+// with it, so it can't contain separators, nor be "..". The entries below it
+// are checked by walkArchive.
+func archiveName(r *http.Request) (string, error) {
+	name := gopath.Base(gopath.Clean(r.URL.Path))
+	if filename := r.URL.Query().Get("filename"); filename != "" {
+		name = strings.TrimSuffix(filename, gopath.Ext(filename))
+	}
+	// some extractors take backslashes as separators too
+	name = gopath.Base(strings.ReplaceAll(name, `\`, "/"))
+	switch name {
+	case "", ".", "..", "/":
+		return "", fmt.Errorf("%q can't be the name of an archive", name)
+	}
+	return name, nil
+}
+
+// walkArchive calls cb with the path in the archive of nd and of everything
+// below it. Link names are chosen by whoever created the DAG, so the entries
+// that would be extracted outside of the top-level directory (names with
+// separators, "." or "..", and symlinks pointing out of it) are skipped.
+func walkArchive(nd files.Node, name string, cb func(fpath string, nd files.Node) error) error {
+	var walk func(fpath string, nd files.Node) error
+	walk = func(fpath string, nd files.Node) error {
+		if link, ok := nd.(*files.Symlink); ok && !archiveSymlinkOK(name, fpath, link.Target) {
+			log.Warnf("skipping %s in archive: symlink target %q is outside of %s", fpath, link.Target, name)
+			return nil
+		}
+		if err := cb(fpath, nd); err != nil {
+			return err
+		}
+		dir, ok := nd.(files.Directory)
+		if !ok {
+			return nil
+		}
+		it := dir.Entries()
+		for it.Next() {
+			switch entry := it.Name(); {
+			case entry == "", entry == ".", entry == "..", strings.ContainsAny(entry, `/\`):
+				log.Warnf("skipping %q in %s: not a valid archive entry name", entry, fpath)
+			default:
+				if err := walk(fpath+"/"+entry, it.Node()); err != nil {
+					return err
+				}
+			}
+		}
+		return it.Err()
+	}
+	return walk(name, nd)
+}
+
+// archiveSymlinkOK returns whether the symlink at fpath, once extracted,
+// points somewhere inside of the top-level directory name.
+func archiveSymlinkOK(name, fpath, target string) bool {
+	target = strings.ReplaceAll(target, `\`, "/")
+	if gopath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return false
+	}
+	resolved := gopath.Join(gopath.Dir(fpath), target)
+	return resolved == name || strings.HasPrefix(resolved, name+"/")
+}
+
+// writeTarArchive writes nd with the same headers as the TAR writer
+// 'ipfs get -a' uses.
+func writeTarArchive(w io.Writer, nd files.Node, name string) error {
+	tw := tar.NewWriter(w)
+	err := walkArchive(nd, name, func(fpath string, nd files.Node) error {
+		return writeTarEntry(tw, fpath, nd)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func writeTarEntry(tw *tar.Writer, fpath string, nd files.Node) error {
+	switch nd := nd.(type) {
+	case *files.Symlink:
+		return tw.WriteHeader(&tar.Header{
+			Name:     fpath,
+			Linkname: nd.Target,
+			Mode:     0777,
+			Typeflag: tar.TypeSymlink,
+		})
+	case files.File:
+		size, err := nd.Size()
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name:     fpath,
+			Size:     size,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			ModTime:  time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, nd)
+		return err
+	case files.Directory:
+		return tw.WriteHeader(&tar.Header{
+			Name:     fpath,
+			Typeflag: tar.TypeDir,
+			Mode:     0777,
+			ModTime:  time.Now(),
+		})
+	default:
+		return fmt.Errorf("file type %T is not supported", nd)
+	}
+}
+
+func writeZipArchive(w io.Writer, nd files.Node, name string) error {
+	zw := zip.NewWriter(w)
+	err := walkArchive(nd, name, func(fpath string, nd files.Node) error {
+		return writeZipEntry(zw, fpath, nd)
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+func writeZipEntry(zw *zip.Writer, fpath string, nd files.Node) error {
+	switch nd := nd.(type) {
+	case *files.Symlink:
+		hdr := &zip.FileHeader{Name: fpath, Method: zip.Store}
+		hdr.SetMode(os.ModeSymlink | 0777)
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(fw, nd.Target)
+		return err
+	case files.File:
+		hdr := &zip.FileHeader{Name: fpath, Method: zip.Deflate}
+		hdr.SetMode(0644)
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, nd)
+		return err
+	case files.Directory:
+		hdr := &zip.FileHeader{Name: fpath + "/"}
+		hdr.SetMode(os.ModeDir | 0755)
+		_, err := zw.CreateHeader(hdr)
+		return err
+	default:
+		return fmt.Errorf("file type %T is not supported", nd)
+	}
+}
@@ -0,0 +1,51 @@
+package corehttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// serveRawBlock returns the single raw block the path resolves to, without
+// any deserialization, so that the client can verify it against the CID.
+func (i *gatewayHandler) serveRawBlock(w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved) {
+	blockCid := resolvedPath.Cid()
+
+	etag := `"` + blockCid.String() + `.raw"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	blockReader, err := i.api.Block().Get(r.Context(), resolvedPath)
+	if err != nil {
+		webError(w, "ipfs block get "+blockCid.String(), err, http.StatusInternalServerError)
+		return
+	}
+	block, err := ioutil.ReadAll(blockReader)
+	if err != nil {
+		webError(w, "ipfs block get "+blockCid.String(), err, http.StatusInternalServerError)
+		return
+	}
+
+	i.addUserHeaders(w) // ok, _now_ write user's headers.
+	w.Header().Set("X-IPFS-Path", r.URL.Path)
+	w.Header().Set("Etag", etag)
+	setContentDispositionHeader(w, blockCid.String()+".bin", "attachment")
+
+	// A block is immutable, but the /ipns/ path pointing at it is not.
+	modtime := time.Now()
+	if strings.HasPrefix(r.URL.Path, ipfsPathPrefix) {
+		w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+		modtime = time.Unix(1, 0)
+	}
+	w.Header().Set("Content-Type", "application/vnd.ipld.raw")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// ServeContent takes care of If-Modified-Since, HEAD and range requests
+	http.ServeContent(w, r, blockCid.String()+".bin", modtime, bytes.NewReader(block))
+}
@@ -0,0 +1,68 @@
+package corehttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	cid "github.com/ipfs/go-cid"
+	mdag "github.com/ipfs/go-merkledag"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	gocar "github.com/ipld/go-car"
+)
+
+// serveCar returns the DAG behind resolvedPath as a CARv1 stream, using the
+// same traversal as 'ipfs dag export'.
+func (i *gatewayHandler) serveCar(w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, carVersion string) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	switch carVersion {
+	case "": // noop, client does not care about version
+	case "1": // noop, we support this
+	default:
+		err := fmt.Errorf("only version=1 is supported")
+		webError(w, "unsupported CAR version", err, http.StatusBadRequest)
+		return
+	}
+	rootCid := resolvedPath.Cid()
+
+	// Weak Etag because we can't guarantee byte-for-byte identical responses
+	// (block order depends on the traversal, not on the CID alone).
+	etag := `W/"` + rootCid.String() + `.car"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	i.addUserHeaders(w) // ok, _now_ write user's headers.
+	w.Header().Set("X-IPFS-Path", r.URL.Path)
+	w.Header().Set("Etag", etag)
+	setContentDispositionHeader(w, rootCid.String()+".car", "attachment")
+
+	// Make it clear we don't support range-requests over a car stream, and
+	// that the stream should be fetched again on retry.
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Cache-Control", "no-cache, no-transform")
+	w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// The HTTP status is sent before the first block is known to be
+	// retrievable, so a failure mid-stream can only be reported in a trailer.
+	w.Header().Set("Trailer", "X-Stream-Error")
+
+	if err := gocar.WriteCar(
+		ctx,
+		mdag.NewSession(ctx, i.api.Dag()),
+		[]cid.Cid{rootCid},
+		w,
+	); err != nil {
+		w.Header().Set("X-Stream-Error", err.Error())
+		log.Warnf("failed to stream CAR for %s: %s", resolvedPath, err)
+		return
+	}
+}
@@ -0,0 +1,269 @@
+package corehttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	gopath "path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-ipfs/core/coredag"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	ipldprime "github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// isCodecNode reports whether c points at a structured (non-UnixFS) node
+// that can be rendered by serveCodec.
+func isCodecNode(c cid.Cid) bool {
+	switch c.Type() {
+	case cid.DagCBOR, coredag.DagJSON:
+		return true
+	default:
+		return false
+	}
+}
+
+// serveCodec renders a dag-cbor or dag-json node (or the value at the
+// remainder of the path inside of it), decoded with go-ipld-prime: as
+// dag-json if the client asked for application/json, and as a HTML page with
+// clickable links otherwise.
+func (i *gatewayHandler) serveCodec(w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, originalURLPath string) {
+	blockReader, err := i.api.Block().Get(r.Context(), ipath.IpfsPath(resolvedPath.Cid()))
+	if err != nil {
+		webError(w, "ipfs dag get "+r.URL.EscapedPath(), err, http.StatusNotFound)
+		return
+	}
+	data, err := ioutil.ReadAll(blockReader)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+	blk, err := blocks.NewBlockWithCid(data, resolvedPath.Cid())
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+	value, err := coredag.DecodeValue(blk, resolvedPath.Remainder())
+	if err != nil {
+		webError(w, "ipfs dag get "+r.URL.EscapedPath(), err, http.StatusNotFound)
+		return
+	}
+
+	asJSON := prefersJSON(r)
+	etag := `"` + resolvedPath.Cid().String() + `.html"`
+	if asJSON {
+		etag = `"` + resolvedPath.Cid().String() + `.json"`
+	}
+	if r.Header.Get("If-None-Match") == etag || r.Header.Get("If-None-Match") == `W/`+etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	i.addUserHeaders(w) // ok, _now_ write user's headers.
+	w.Header().Set("X-IPFS-Path", r.URL.Path)
+	w.Header().Set("Etag", etag)
+	// the same URL is rendered differently depending on the Accept header
+	w.Header().Add("Vary", "Accept")
+	if strings.HasPrefix(r.URL.Path, ipfsPathPrefix) {
+		w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+	}
+
+	if asJSON {
+		out := blk.RawData() // already dag-json, sent as-is
+		if resolvedPath.Cid().Type() != coredag.DagJSON || resolvedPath.Remainder() != "" {
+			var buf bytes.Buffer
+			if err := coredag.EncodeDagJSON(&buf, value); err != nil {
+				internalWebError(w, err)
+				return
+			}
+			out = buf.Bytes()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(out)))
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(out)
+		}
+		return
+	}
+
+	// Gateway root URL to be used when linking to other CIDs, see the
+	// directory listing.
+	var gwURL string
+	if h, ok := r.Context().Value("gw-hostname").(string); ok {
+		gwURL = "//" + h
+	}
+
+	codec := "dag-cbor"
+	if resolvedPath.Cid().Type() == coredag.DagJSON {
+		codec = "dag-json"
+	}
+	tplData := codecTemplateData{
+		Path:        r.URL.Path,
+		Breadcrumbs: breadcrumbs(r.URL.Path, hasDNSLinkOrigin(gwURL, r.URL.Path)),
+		Cid:         resolvedPath.Cid().String(),
+		Codec:       codec,
+		// the core API resolves paths inside of dag-cbor nodes only
+		Value: explorerValue(value, originalURLPath, gwURL, codec == "dag-cbor"),
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := codecTemplate.Execute(w, tplData); err != nil {
+		internalWebError(w, err)
+		return
+	}
+}
+
+// prefersJSON reports whether JSON comes before HTML in the Accept header.
+// Browsers ask for text/html, so HTML is the default.
+func prefersJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, spec := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(spec))
+			if err != nil {
+				continue
+			}
+			switch mediaType {
+			case "application/json":
+				return true
+			case "text/html":
+				return false
+			}
+		}
+	}
+	return false
+}
+
+type codecTemplateData struct {
+	Path        string
+	Breadcrumbs []breadcrumb
+	Cid         string
+	Codec       string
+	Value       dagValue
+}
+
+// dagValue is a value of a node, ready to be rendered. Maps and lists have
+// Entries, links have an Href.
+type dagValue struct {
+	Kind    string
+	Value   string
+	Href    string
+	Entries []dagEntry
+}
+
+// dagEntry is a map field or list item, linking to its own path.
+type dagEntry struct {
+	Key   string
+	Href  string
+	Value dagValue
+}
+
+// explorerValue converts a value decoded by go-ipld-prime to a dagValue.
+// urlPath is the path the value was requested at, and is used to link to its
+// fields, unless linkFields is false.
+func explorerValue(v ipldprime.Node, urlPath string, gwURL string, linkFields bool) dagValue {
+	entry := func(key string, v ipldprime.Node) dagEntry {
+		sub := gopath.Join(urlPath, key)
+		e := dagEntry{Key: key, Value: explorerValue(v, sub, gwURL, linkFields)}
+		if linkFields {
+			e.Href = sub
+		}
+		return e
+	}
+
+	switch v.Kind() {
+	case ipldprime.Kind_Map:
+		out := dagValue{Kind: "map", Value: "{}"}
+		for it := v.MapIterator(); !it.Done(); {
+			k, val, err := it.Next()
+			if err != nil {
+				return dagValue{Kind: "error", Value: err.Error()}
+			}
+			key, err := k.AsString()
+			if err != nil {
+				return dagValue{Kind: "error", Value: err.Error()}
+			}
+			out.Entries = append(out.Entries, entry(key, val))
+		}
+		sort.Slice(out.Entries, func(i, j int) bool { return out.Entries[i].Key < out.Entries[j].Key })
+		return out
+	case ipldprime.Kind_List:
+		out := dagValue{Kind: "list", Value: "[]"}
+		for it := v.ListIterator(); !it.Done(); {
+			idx, val, err := it.Next()
+			if err != nil {
+				return dagValue{Kind: "error", Value: err.Error()}
+			}
+			out.Entries = append(out.Entries, entry(strconv.FormatInt(idx, 10), val))
+		}
+		return out
+	case ipldprime.Kind_Link:
+		lnk, err := v.AsLink()
+		if err != nil {
+			return dagValue{Kind: "error", Value: err.Error()}
+		}
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return dagValue{Kind: "link", Value: lnk.String()}
+		}
+		return dagValue{Kind: "link", Value: cl.Cid.String(), Href: gwURL + "/ipfs/" + cl.Cid.String()}
+	case ipldprime.Kind_Bytes:
+		b, _ := v.AsBytes()
+		return dagValue{Kind: "bytes", Value: base64.StdEncoding.EncodeToString(b)}
+	case ipldprime.Kind_String:
+		str, _ := v.AsString()
+		return dagValue{Kind: "string", Value: strconv.Quote(str)}
+	case ipldprime.Kind_Null:
+		return dagValue{Kind: "null", Value: "null"}
+	case ipldprime.Kind_Bool:
+		b, _ := v.AsBool()
+		return dagValue{Kind: "scalar", Value: strconv.FormatBool(b)}
+	case ipldprime.Kind_Int:
+		n, _ := v.AsInt()
+		return dagValue{Kind: "scalar", Value: strconv.FormatInt(n, 10)}
+	case ipldprime.Kind_Float:
+		f, _ := v.AsFloat()
+		return dagValue{Kind: "scalar", Value: strconv.FormatFloat(f, 'g', -1, 64)}
+	default:
+		return dagValue{Kind: "error", Value: fmt.Sprintf("unexpected %s", v.Kind())}
+	}
+}
+
+var codecTemplate = template.Must(template.New("codec").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Path}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ddd; padding: .25em .5em; text-align: left; vertical-align: top; }
+th { font-weight: normal; background: #f7f8fa; }
+.string { color: #0b7a75; }
+.link, .bytes { font-family: monospace; }
+.null, .error { color: #999; }
+</style>
+</head>
+<body>
+<div>
+{{range .Breadcrumbs}}/{{if .Path}}<a href="{{.Path}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}{{else}}{{.Path}}{{end}}
+</div>
+<p><code>{{.Cid}}</code> ({{.Codec}})</p>
+{{template "value" .Value}}
+</body>
+</html>
+{{define "value"}}{{if .Entries}}<table>
+{{range .Entries}}<tr><th>{{if .Href}}<a href="{{.Href}}">{{.Key}}</a>{{else}}{{.Key}}{{end}}</th><td>{{template "value" .Value}}</td></tr>
+{{end}}</table>{{else if .Href}}<a class="{{.Kind}}" href="{{.Href}}">{{.Value}}</a>{{else}}<span class="{{.Kind}}">{{.Value}}</span>{{end}}{{end}}`))
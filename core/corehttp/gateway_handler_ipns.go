@@ -0,0 +1,318 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core/node"
+	ipns "github.com/ipfs/go-ipns"
+	ipns_pb "github.com/ipfs/go-ipns/pb"
+	namesys "github.com/ipfs/go-namesys"
+	path "github.com/ipfs/go-path"
+	"github.com/ipfs/go-path/resolver"
+	uio "github.com/ipfs/go-unixfs/io"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	dns "github.com/miekg/dns"
+)
+
+// dohMediaType is the media type of the DNS messages sent to, and received
+// from DNS over HTTPS resolvers.
+const dohMediaType = "application/dns-message"
+
+// maxNameTTLs bounds how many names nameTTLs remembers.
+const maxNameTTLs = 1024
+
+var (
+	errNoDNSLinkTTL = errors.New("no DNSLink TXT record with a TTL")
+	errNoIpnsRecord = errors.New("the IPNS record wasn't resolved by the name system")
+)
+
+// nameTTLs tells how long the content behind an /ipns/ name may be cached
+// for: the TTL of the IPNS record the name system resolved (capped by the
+// validity of the record), or the TTL of its DNSLink TXT record. Names are
+// looked up again once their TTL expired.
+type nameTTLs struct {
+	ipnsRecord   func(pid peer.ID) (*ipns_pb.IpnsEntry, bool)
+	lookupTXTTTL func(ctx context.Context, name string) (time.Duration, error)
+
+	mu      sync.Mutex
+	entries map[string]nameTTL
+}
+
+type nameTTL struct {
+	expires time.Time
+	known   bool // false for names we failed to look up
+}
+
+func newNameTTLs(records *node.IpnsRecords, resolverURL func(domain string) string) *nameTTLs {
+	return &nameTTLs{
+		ipnsRecord: records.Get,
+		lookupTXTTTL: func(ctx context.Context, name string) (time.Duration, error) {
+			return lookupDNSLinkTTL(ctx, name, resolverURL)
+		},
+		entries: make(map[string]nameTTL),
+	}
+}
+
+// ttl returns for how much longer the value of name is valid, or false if
+// that isn't known. A nil *nameTTLs knows nothing.
+func (n *nameTTLs) ttl(ctx context.Context, name string) (time.Duration, bool) {
+	if n == nil {
+		return 0, false
+	}
+
+	n.mu.Lock()
+	e, ok := n.entries[name]
+	n.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return time.Until(e.expires), e.known
+	}
+
+	ttl, err := n.lookup(ctx, name)
+	if err != nil {
+		log.Debugf("could not find the TTL of %s: %s", name, err)
+		// don't look it up on every request, but not forever either
+		e = nameTTL{expires: time.Now().Add(namesys.DefaultResolverCacheTTL)}
+	} else {
+		e = nameTTL{expires: time.Now().Add(ttl), known: true}
+	}
+
+	n.mu.Lock()
+	if len(n.entries) >= maxNameTTLs {
+		n.evictExpired()
+	}
+	n.entries[name] = e
+	n.mu.Unlock()
+	return ttl, e.known
+}
+
+// evictExpired forgets expired names, or all of them if none expired.
+// Must be called with mu held.
+func (n *nameTTLs) evictExpired() {
+	now := time.Now()
+	for name, e := range n.entries {
+		if now.After(e.expires) {
+			delete(n.entries, name)
+		}
+	}
+	if len(n.entries) >= maxNameTTLs {
+		n.entries = make(map[string]nameTTL)
+	}
+}
+
+func (n *nameTTLs) lookup(ctx context.Context, name string) (time.Duration, error) {
+	pid, err := peer.Decode(name)
+	if err != nil {
+		if _, ok := dns.IsDomainName(name); !ok {
+			return 0, err
+		}
+		return n.lookupTXTTTL(ctx, name)
+	}
+
+	// the name was just resolved, don't fetch its record again
+	entry, ok := n.ipnsRecord(pid)
+	if !ok {
+		return 0, errNoIpnsRecord
+	}
+	return ipnsRecordTTL(entry, time.Now())
+}
+
+// ipnsRecordTTL returns for how long the value of an IPNS record may be
+// cached: its TTL, the same default as namesys uses if it has none, and never
+// past the end of its validity.
+func ipnsRecordTTL(entry *ipns_pb.IpnsEntry, now time.Time) (time.Duration, error) {
+	ttl := namesys.DefaultResolverCacheTTL
+	if entry.Ttl != nil {
+		ttl = time.Duration(entry.GetTtl())
+	}
+	eol, err := ipns.GetEOL(entry)
+	if err != nil {
+		return 0, err
+	}
+	if left := eol.Sub(now); left < ttl {
+		ttl = left
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	return ttl, nil
+}
+
+// lookupDNSLinkTTL returns the TTL of the DNSLink TXT records of name, as
+// reported by the resolver the node uses for the domain: the DNS over HTTPS
+// resolver at resolverURL(domain), or the system resolvers. The resolvers
+// don't report TTLs through madns, so the records are queried again. Like
+// namesys, _dnslink.name takes precedence over name.
+func lookupDNSLinkTTL(ctx context.Context, name string, resolverURL func(domain string) string) (time.Duration, error) {
+	for _, fqdn := range []string{"_dnslink." + name, name} {
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn(fqdn), dns.TypeTXT)
+		var (
+			in  *dns.Msg
+			err error
+		)
+		if url := resolverURL(fqdn); url != "" {
+			in, err = exchangeDoH(ctx, url, m)
+		} else {
+			in, err = exchangeSystem(ctx, m)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if ttl, ok := dnslinkTTL(in.Answer); ok {
+			return ttl, nil
+		}
+	}
+	return 0, errNoDNSLinkTTL
+}
+
+// exchangeDoH sends m to the DNS over HTTPS resolver at url.
+func exchangeDoH(ctx context.Context, url string, m *dns.Msg) (*dns.Msg, error) {
+	data, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, res.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	in := new(dns.Msg)
+	if err := in.Unpack(body); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// exchangeSystem sends m to the system resolvers, which are the default of
+// the node.
+func exchangeSystem(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return nil, err
+	}
+	err = errors.New("no system DNS resolver")
+	c := new(dns.Client)
+	for _, server := range conf.Servers {
+		var in *dns.Msg
+		in, _, err = c.ExchangeContext(ctx, m, net.JoinHostPort(server, conf.Port))
+		if err == nil {
+			return in, nil
+		}
+	}
+	return nil, err
+}
+
+// dnslinkTTL returns the lowest TTL of the DNSLink TXT records in rrs.
+func dnslinkTTL(rrs []dns.RR) (time.Duration, bool) {
+	var (
+		ttl   uint32
+		found bool
+	)
+	for _, rr := range rrs {
+		txt, ok := rr.(*dns.TXT)
+		if !ok || !strings.HasPrefix(strings.Join(txt.Txt, ""), "dnslink=") {
+			continue
+		}
+		if !found || txt.Hdr.Ttl < ttl {
+			ttl = txt.Hdr.Ttl
+		}
+		found = true
+	}
+	return time.Duration(ttl) * time.Second, found
+}
+
+// withMutableCacheHeaders returns w, setting Cache-Control for a response to
+// an /ipns/ path from the TTL of the name. The header is only set once the
+// response is known not to be an error: a 404 must not be cached for as long
+// as the name is valid, the content may be found later.
+func (i *gatewayHandler) withMutableCacheHeaders(ctx context.Context, w http.ResponseWriter, parsedPath ipath.Path) http.ResponseWriter {
+	if parsedPath.Namespace() != "ipns" {
+		return w
+	}
+	segs := strings.SplitN(strings.TrimPrefix(parsedPath.String(), "/ipns/"), "/", 2)
+	ttl, ok := i.names.ttl(ctx, segs[0])
+	if !ok {
+		return w
+	}
+	return &mutableCacheWriter{
+		ResponseWriter: w,
+		cacheControl:   "public, max-age=" + strconv.FormatInt(int64(ttl/time.Second), 10),
+	}
+}
+
+// mutableCacheWriter sets Cache-Control when the status of a successful
+// response is written.
+type mutableCacheWriter struct {
+	http.ResponseWriter
+	cacheControl string
+	wroteHeader  bool
+}
+
+func (mw *mutableCacheWriter) WriteHeader(code int) {
+	if !mw.wroteHeader && code < http.StatusBadRequest {
+		mw.Header().Set("Cache-Control", mw.cacheControl)
+	}
+	mw.wroteHeader = true
+	mw.ResponseWriter.WriteHeader(code)
+}
+
+func (mw *mutableCacheWriter) Write(b []byte) (int, error) {
+	if !mw.wroteHeader {
+		mw.WriteHeader(http.StatusOK)
+	}
+	return mw.ResponseWriter.Write(b)
+}
+
+// setIpfsRootsHeader sets X-Ipfs-Roots to the CIDs of every node the path
+// traverses, from the root down, so that caches can tell which responses
+// change together. The nodes were fetched when resolving the path, so they
+// are walked again in one pass from the resolved /ipfs/ path, without
+// resolving the name again. The header is left out if that fails.
+func (i *gatewayHandler) setIpfsRootsHeader(ctx context.Context, w http.ResponseWriter, resolvedPath ipath.Resolved) {
+	// the remainder is inside of the last node
+	segs := path.Path(resolvedPath.String()).Segments()
+	if rem := resolvedPath.Remainder(); rem != "" {
+		segs = segs[:len(segs)-len(strings.Split(rem, "/"))]
+	}
+	p, err := path.FromSegments("/", segs...)
+	if err != nil {
+		log.Debugf("no X-Ipfs-Roots for %s: %s", resolvedPath, err)
+		return
+	}
+	r := &resolver.Resolver{DAG: i.api.Dag(), ResolveOnce: uio.ResolveUnixfsOnce}
+	nodes, err := r.ResolvePathComponents(ctx, p)
+	if err != nil {
+		log.Debugf("no X-Ipfs-Roots for %s: %s", resolvedPath, err)
+		return
+	}
+	roots := make([]string, 0, len(nodes))
+	for _, nd := range nodes {
+		roots = append(roots, nd.Cid().String())
+	}
+	w.Header().Set("X-Ipfs-Roots", strings.Join(roots, ","))
+}
@@ -0,0 +1,344 @@
+package corehttp
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	datastore "github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	config "github.com/ipfs/go-ipfs-config"
+	files "github.com/ipfs/go-ipfs-files"
+	core "github.com/ipfs/go-ipfs/core"
+	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+	"github.com/ipfs/go-ipfs/core/node"
+	repo "github.com/ipfs/go-ipfs/repo"
+	ipns "github.com/ipfs/go-ipns"
+	merkledag "github.com/ipfs/go-merkledag"
+	namesys "github.com/ipfs/go-namesys"
+	ft "github.com/ipfs/go-unixfs"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	ci "github.com/libp2p/go-libp2p-core/crypto"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	dns "github.com/miekg/dns"
+)
+
+func TestIpnsRecordTTL(t *testing.T) {
+	sk, _, err := ci.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	for _, test := range []struct {
+		eol      time.Time
+		ttl      time.Duration
+		expected time.Duration
+	}{
+		{now.Add(time.Hour), 5 * time.Minute, 5 * time.Minute},
+		// no TTL in the record: same default as namesys
+		{now.Add(time.Hour), 0, namesys.DefaultResolverCacheTTL},
+		// never past the validity of the record
+		{now.Add(time.Minute), 5 * time.Minute, time.Minute},
+		{now.Add(-time.Minute), 5 * time.Minute, 0},
+	} {
+		entry, err := ipns.Create(sk, []byte("/ipfs/bafkqaaa"), 1, test.eol, test.ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.ttl == 0 {
+			entry.Ttl = nil
+		}
+		ttl, err := ipnsRecordTTL(entry, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// EOLs are stored with a precision of nanoseconds, in UTC
+		if d := ttl - test.expected; d > time.Millisecond || d < -time.Millisecond {
+			t.Errorf("ttl %s eol %s: got %s, expected %s", test.ttl, test.eol, ttl, test.expected)
+		}
+	}
+}
+
+func TestDNSLinkTTL(t *testing.T) {
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	ttl, ok := dnslinkTTL([]dns.RR{
+		rr(`example.com. 300 IN TXT "v=spf1 -all"`),
+		rr(`example.com. 120 IN TXT "dnslink=/ipfs/bafkqaaa"`),
+		rr(`example.com. 60 IN A 127.0.0.1`),
+	})
+	if !ok || ttl != 2*time.Minute {
+		t.Fatalf("got %s (%t), expected 2m", ttl, ok)
+	}
+	if _, ok := dnslinkTTL([]dns.RR{rr(`example.com. 300 IN TXT "v=spf1 -all"`)}); ok {
+		t.Fatal("found a TTL without a DNSLink")
+	}
+}
+
+func TestLookupDNSLinkTTL(t *testing.T) {
+	// a DNS over HTTPS resolver with a DNSLink for example.com
+	var questions []string
+	doh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		m := new(dns.Msg)
+		if err := m.Unpack(body); err != nil {
+			t.Error(err)
+			return
+		}
+		questions = append(questions, m.Question[0].Name)
+		reply := new(dns.Msg)
+		reply.SetReply(m)
+		if m.Question[0].Name == "_dnslink.example.com." {
+			rr, err := dns.NewRR(`_dnslink.example.com. 42 IN TXT "dnslink=/ipfs/bafkqaaa"`)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			reply.Answer = append(reply.Answer, rr)
+		}
+		data, err := reply.Pack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		w.Header().Set("Content-Type", dohMediaType)
+		w.Write(data)
+	}))
+	defer doh.Close()
+	resolverURL := func(domain string) string { return doh.URL }
+	ctx := context.Background()
+
+	ttl, err := lookupDNSLinkTTL(ctx, "example.com", resolverURL)
+	if err != nil || ttl != 42*time.Second {
+		t.Fatalf("got %s (%v), expected 42s", ttl, err)
+	}
+	if _, err := lookupDNSLinkTTL(ctx, "example.net", resolverURL); err != errNoDNSLinkTTL {
+		t.Fatalf("expected %s, got %v", errNoDNSLinkTTL, err)
+	}
+	expected := []string{"_dnslink.example.com.", "_dnslink.example.net.", "example.net."}
+	if !reflect.DeepEqual(questions, expected) {
+		t.Fatalf("asked %v, expected %v", questions, expected)
+	}
+}
+
+type mapValueStore struct {
+	routing.ValueStore
+	values map[string][]byte
+}
+
+func (vs *mapValueStore) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	v, ok := vs.values[key]
+	if !ok {
+		return nil, routing.ErrNotFound
+	}
+	out := make(chan []byte, 1)
+	out <- v
+	close(out)
+	return out, nil
+}
+
+func TestNameTTLs(t *testing.T) {
+	sk, _, err := ci.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := ipns.Create(sk, []byte("/ipfs/bafkqaaa"), 1, time.Now().Add(time.Hour), 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := entry.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := node.NewIpnsRecords()
+	names := newNameTTLs(records, func(string) string { return "" })
+	names.lookupTXTTTL = func(ctx context.Context, name string) (time.Duration, error) {
+		if name == "example.com" {
+			return 30 * time.Second, nil
+		}
+		return 0, errNoDNSLinkTTL
+	}
+	ctx := context.Background()
+
+	// the TTL comes from the record the name system got from the routing
+	vs := records.ValueStore(&mapValueStore{values: map[string][]byte{ipns.RecordKey(pid): rec}})
+	vals, err := vs.SearchValue(ctx, ipns.RecordKey(pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range vals {
+	}
+	ttl, ok := names.ttl(ctx, pid.Pretty())
+	if !ok || ttl > 10*time.Minute || ttl < 9*time.Minute {
+		t.Fatalf("got %s (%t), expected about 10m", ttl, ok)
+	}
+
+	other, err := peer.Decode("QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := names.ttl(ctx, other.Pretty()); ok {
+		t.Fatal("expected no TTL for a name the name system didn't resolve")
+	}
+
+	if ttl, ok := names.ttl(ctx, "example.com"); !ok || ttl != 30*time.Second {
+		t.Fatalf("got %s (%t) for example.com, expected 30s", ttl, ok)
+	}
+	if _, ok := names.ttl(ctx, "example.net"); ok {
+		t.Fatal("expected no TTL for a name without DNSLink TTL")
+	}
+
+	var nilNames *nameTTLs
+	if _, ok := nilNames.ttl(ctx, "example.com"); ok {
+		t.Fatal("nil nameTTLs should not know anything")
+	}
+}
+
+func TestGatewayMutableCacheHeaders(t *testing.T) {
+	// the name system of the node, which remembers the records it resolved
+	n, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: &repo.Mock{
+		C: config.Config{Identity: config.Identity{PeerID: "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe"}},
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(nil)
+	t.Cleanup(func() { ts.Close() })
+	ts.Config.Handler, err = makeHandler(n, ts.Listener, GatewayOption(false, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	dir := files.NewMapDirectory(map[string]files.Node{
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"file.txt": files.NewBytesFile([]byte("fnord")),
+		}),
+	})
+	k, err := api.Unixfs().Add(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := api.ResolvePath(ctx, ipath.Join(k, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := api.ResolvePath(ctx, ipath.Join(k, "sub", "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedRoots := strings.Join([]string{k.Cid().String(), sub.Cid().String(), file.Cid().String()}, ",")
+
+	// publish IPNS records with a TTL to the routing of the node
+	publish := func(value ipath.Path) peer.ID {
+		sk, pk, err := ci.GenerateEd25519Key(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pid, err := peer.IDFromPublicKey(pk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := ipns.Create(sk, []byte(value.String()), 1, time.Now().Add(time.Hour), 5*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec, err := entry.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Routing.PutValue(ctx, ipns.RecordKey(pid), rec); err != nil {
+			t.Fatal(err)
+		}
+		return pid
+	}
+	pid := publish(k)
+
+	get := func(p string) *http.Response {
+		res, err := http.Get(ts.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status is %d, expected 200", p, res.StatusCode)
+		}
+		return res
+	}
+
+	res := get("/ipns/" + pid.Pretty() + "/sub/file.txt")
+	cc := res.Header.Get("Cache-Control")
+	if !strings.HasPrefix(cc, "public, max-age=") {
+		t.Fatalf("unexpected Cache-Control: %q", cc)
+	}
+	if maxAge, err := strconv.Atoi(strings.TrimPrefix(cc, "public, max-age=")); err != nil || maxAge > 300 || maxAge < 290 {
+		t.Fatalf("unexpected Cache-Control: %q", cc)
+	}
+	if lm := res.Header.Get("Last-Modified"); lm != "" {
+		t.Fatalf("unexpected Last-Modified for mutable content: %s", lm)
+	}
+	if roots := res.Header.Get("X-Ipfs-Roots"); roots != expectedRoots {
+		t.Fatalf("X-Ipfs-Roots is %q, expected %q", roots, expectedRoots)
+	}
+
+	// errors aren't cached for the TTL of the name: the path to a block
+	// the node doesn't have resolves, but the block isn't found
+	missing := merkledag.NodeWithData(ft.FilePBData([]byte("missing"), 7))
+	incomplete := merkledag.NodeWithData(ft.FolderPBData())
+	if err := incomplete.AddNodeLink("missing.txt", missing); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Dag().Add(ctx, incomplete); err != nil {
+		t.Fatal(err)
+	}
+	incompletePid := publish(ipath.IpfsPath(incomplete.Cid()))
+	res, err = http.Get(ts.URL + "/ipns/" + incompletePid.Pretty() + "/missing.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("status is %d, expected 404", res.StatusCode)
+	}
+	if cc := res.Header.Get("Cache-Control"); cc != "" {
+		t.Fatalf("unexpected Cache-Control for an error: %q", cc)
+	}
+
+	// immutable paths keep their headers
+	res = get(file.String() + "?x=1")
+	if cc := res.Header.Get("Cache-Control"); cc != "public, max-age=29030400, immutable" {
+		t.Fatalf("unexpected Cache-Control for /ipfs/: %q", cc)
+	}
+	res = get(k.String() + "/sub/file.txt")
+	if roots := res.Header.Get("X-Ipfs-Roots"); roots != expectedRoots {
+		t.Fatalf("X-Ipfs-Roots is %q, expected %q", roots, expectedRoots)
+	}
+}
@@ -0,0 +1,186 @@
+package corehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	gopath "path"
+	"strconv"
+
+	humanize "github.com/dustin/go-humanize"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	merkledag "github.com/ipfs/go-merkledag"
+	unixfs "github.com/ipfs/go-unixfs"
+	uio "github.com/ipfs/go-unixfs/io"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// errListingDone stops the enumeration of a directory once the requested
+// page was listed.
+var errListingDone = errors.New("listing done")
+
+// listingPage is the part of a directory listing requested with the ?offset=
+// and ?limit= query parameters. A zero limit lists everything after offset.
+type listingPage struct {
+	offset int
+	limit  int
+}
+
+func parseListingPage(q url.Values) (listingPage, error) {
+	var page listingPage
+	for _, p := range []struct {
+		name string
+		out  *int
+	}{
+		{"offset", &page.offset},
+		{"limit", &page.limit},
+	} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return listingPage{}, fmt.Errorf("invalid %s %q: expected a non-negative integer", p.name, v)
+		}
+		*p.out = n
+	}
+	return page, nil
+}
+
+// nextURL returns the URL of the page after this one.
+func (page listingPage) nextURL(urlPath string, q url.Values) string {
+	next := url.Values{}
+	for k, v := range q {
+		next[k] = v
+	}
+	next.Set("offset", strconv.Itoa(page.offset+page.limit))
+	next.Set("limit", strconv.Itoa(page.limit))
+	return (&url.URL{Path: urlPath, RawQuery: next.Encode()}).String()
+}
+
+// listingBatchSize is the number of entries of a directory listing whose
+// root blocks are fetched together.
+const listingBatchSize = 32
+
+// listDirectory sends the entries of the directory at resolvedPath that are
+// part of page to the returned channel, in the order they are stored in, as
+// they are enumerated. For sharded directories, only the shards are fetched.
+//
+// Entries are listed from their links, so that the CID is the one of the
+// link. Their sizes are read from their root blocks, which are fetched a
+// batch at a time: the size of a file is the size of its content, and the
+// size of a directory the cumulative size of the DAG behind it, like the
+// size of the directory itself. The function returned along with the
+// channel reports the error that stopped the enumeration, once the channel
+// was closed.
+func (i *gatewayHandler) listDirectory(ctx context.Context, resolvedPath ipath.Resolved, urlPath string, page listingPage) (<-chan directoryItem, func() error, error) {
+	nd, err := i.api.Dag().Get(ctx, resolvedPath.Cid())
+	if err != nil {
+		return nil, nil, err
+	}
+	dir, err := uio.NewDirectoryFromNode(i.api.Dag(), nd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := make(chan directoryItem, listingBatchSize)
+	var walkErr error
+	go func() {
+		defer close(out)
+
+		batch := make([]*ipld.Link, 0, listingBatchSize)
+		flush := func() error {
+			sizes := entrySizes(ctx, i.api.Dag(), batch)
+			for _, l := range batch {
+				size := "?"
+				if s, ok := sizes[l.Cid]; ok {
+					size = humanize.Bytes(s)
+				}
+				hash := l.Cid.String()
+				item := directoryItem{
+					Size:      size,
+					Name:      l.Name,
+					Path:      gopath.Join(urlPath, l.Name),
+					Hash:      hash,
+					ShortHash: shortHash(hash),
+				}
+				select {
+				case out <- item:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			batch = batch[:0]
+			return nil
+		}
+
+		var idx int
+		err := dir.ForEachLink(ctx, func(l *ipld.Link) error {
+			if page.limit > 0 && idx >= page.offset+page.limit {
+				return errListingDone
+			}
+			idx++
+			if idx <= page.offset {
+				return nil
+			}
+			batch = append(batch, l)
+			if len(batch) < listingBatchSize {
+				return nil
+			}
+			return flush()
+		})
+		if err == nil || err == errListingDone {
+			err = flush()
+		}
+		walkErr = err
+	}()
+
+	// walkErr is only read after out was closed
+	return out, func() error { return walkErr }, nil
+}
+
+// entrySizes fetches the root blocks of the entries of a directory, and
+// returns the sizes of the ones that could be fetched and are UnixFS files
+// or directories.
+func entrySizes(ctx context.Context, ng ipld.NodeGetter, links []*ipld.Link) map[cid.Cid]uint64 {
+	cids := make([]cid.Cid, len(links))
+	for i, l := range links {
+		cids[i] = l.Cid
+	}
+	sizes := make(map[cid.Cid]uint64, len(links))
+	for opt := range ng.GetMany(ctx, cids) {
+		if opt.Err != nil {
+			// Size may not be defined/supported. Continue anyways.
+			continue
+		}
+		if s, ok := unixfsSize(opt.Node); ok {
+			sizes[opt.Node.Cid()] = s
+		}
+	}
+	return sizes
+}
+
+// unixfsSize returns the size of the UnixFS file or directory of nd, as
+// the files package reports it.
+func unixfsSize(nd ipld.Node) (uint64, bool) {
+	switch nd := nd.(type) {
+	case *merkledag.RawNode:
+		return uint64(len(nd.RawData())), true
+	case *merkledag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+		if err != nil {
+			return 0, false
+		}
+		switch fsn.Type() {
+		case unixfs.TFile, unixfs.TRaw:
+			return fsn.FileSize(), true
+		case unixfs.TDirectory, unixfs.THAMTShard:
+			s, err := nd.Size()
+			return s, err == nil
+		}
+	}
+	return 0, false
+}
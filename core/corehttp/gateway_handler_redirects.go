@@ -0,0 +1,349 @@
+package corehttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	gopath "path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	uio "github.com/ipfs/go-unixfs/io"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// redirectsFilename is the name of the file with redirect rules, looked up in
+// the root directory of a website.
+const redirectsFilename = "_redirects"
+
+// maxRedirectsFileSize caps the size of the _redirects files we are willing
+// to read and parse. Larger files are ignored, and a warning is logged.
+const maxRedirectsFileSize = 64 * 1024
+
+// maxCachedRedirects is the number of websites whose parsed _redirects
+// files are kept, see redirectsCache.
+const maxCachedRedirects = 256
+
+// redirectRule is a single line of a _redirects file:
+//
+//	/from/:placeholder/*  /to/:placeholder/:splat  [status]
+//
+// Status 200 rewrites the request to another file, 3xx redirects the client,
+// and 404, 410 and 451 serve the target file with that status code.
+type redirectRule struct {
+	From   string
+	To     string
+	Status int
+}
+
+// parseRedirectsFile reads rules from a _redirects file. Empty lines and lines
+// starting with '#' are ignored.
+func parseRedirectsFile(r io.Reader) ([]redirectRule, error) {
+	var rules []redirectRule
+	s := bufio.NewScanner(r)
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected 'from to [status]'", redirectsFilename, lineNo)
+		}
+
+		rule := redirectRule{From: fields[0], To: fields[1], Status: http.StatusMovedPermanently}
+		if !strings.HasPrefix(rule.From, "/") {
+			return nil, fmt.Errorf("%s:%d: 'from' must be an absolute path: %q", redirectsFilename, lineNo, rule.From)
+		}
+		if i := strings.Index(rule.From, "*"); i >= 0 && i != len(rule.From)-1 {
+			return nil, fmt.Errorf("%s:%d: splat is only supported at the end of 'from': %q", redirectsFilename, lineNo, rule.From)
+		}
+		if u, err := url.Parse(rule.To); err != nil || (!u.IsAbs() && !strings.HasPrefix(rule.To, "/")) {
+			return nil, fmt.Errorf("%s:%d: 'to' must be an absolute path or URL: %q", redirectsFilename, lineNo, rule.To)
+		}
+		if len(fields) == 3 {
+			code, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid status %q", redirectsFilename, lineNo, fields[2])
+			}
+			switch code {
+			case http.StatusOK,
+				http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+				http.StatusTemporaryRedirect, http.StatusPermanentRedirect,
+				http.StatusNotFound, http.StatusGone, http.StatusUnavailableForLegalReasons:
+			default:
+				return nil, fmt.Errorf("%s:%d: unsupported status %d", redirectsFilename, lineNo, code)
+			}
+			rule.Status = code
+		}
+		rules = append(rules, rule)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// match returns the target of the rule for urlPath, with placeholders and
+// the splat substituted, or false if the rule does not apply.
+func (rule redirectRule) match(urlPath string) (string, bool) {
+	fromSegs := strings.Split(strings.TrimSuffix(rule.From, "/"), "/")
+	pathSegs := strings.Split(strings.TrimSuffix(urlPath, "/"), "/")
+
+	params := make(map[string]string)
+	for idx, seg := range fromSegs {
+		if seg == "*" {
+			// splat must be last, and matches the rest of the path (if any)
+			if idx < len(pathSegs) {
+				params["splat"] = strings.Join(pathSegs[idx:], "/")
+			} else {
+				params["splat"] = ""
+			}
+			return rule.expand(params), true
+		}
+		if idx >= len(pathSegs) {
+			return "", false
+		}
+		switch {
+		case strings.HasPrefix(seg, ":") && len(seg) > 1:
+			if pathSegs[idx] == "" {
+				return "", false
+			}
+			params[seg[1:]] = pathSegs[idx]
+		case strings.HasSuffix(seg, "*"):
+			// prefix splat, e.g. /blog-*
+			prefix := strings.TrimSuffix(seg, "*")
+			if !strings.HasPrefix(pathSegs[idx], prefix) {
+				return "", false
+			}
+			params["splat"] = strings.Join(append([]string{strings.TrimPrefix(pathSegs[idx], prefix)}, pathSegs[idx+1:]...), "/")
+			return rule.expand(params), true
+		case seg != pathSegs[idx]:
+			return "", false
+		}
+	}
+	if len(fromSegs) != len(pathSegs) {
+		return "", false
+	}
+	return rule.expand(params), true
+}
+
+func (rule redirectRule) expand(params map[string]string) string {
+	to := rule.To
+	// replace the longest names first so that :a does not clobber :ab
+	for len(params) > 0 {
+		longest := ""
+		for name := range params {
+			if len(name) > len(longest) {
+				longest = name
+			}
+		}
+		to = strings.ReplaceAll(to, ":"+longest, params[longest])
+		delete(params, longest)
+	}
+	return to
+}
+
+// serveRedirectsIfPresent applies the rules from the _redirects file in the
+// root of the website the request was made to. It returns false if there is
+// no such file or no rule matched, in which case nothing was written.
+//
+// Rules are only applied for requests made to an origin dedicated to the
+// website (DNSLink or subdomain gateway), as paths in the file are relative
+// to the root of the site, and only for paths that do not exist.
+func (i *gatewayHandler) serveRedirectsIfPresent(w http.ResponseWriter, r *http.Request, parsedPath ipath.Path, sitePath string) bool {
+	if _, ok := r.Context().Value("gw-hostname").(string); !ok {
+		return false
+	}
+
+	// /ipfs/{cid} or /ipns/{name}
+	segs := strings.SplitN(parsedPath.String(), "/", 4)
+	if len(segs) < 3 {
+		return false
+	}
+	rootPath := ipath.New(strings.Join(segs[:3], "/"))
+
+	rules, err := i.getRedirectRules(r.Context(), rootPath)
+	if err != nil {
+		log.Debugf("could not read %s for %s: %s", redirectsFilename, rootPath, err)
+		return false
+	}
+
+	for _, rule := range rules {
+		to, ok := rule.match(sitePath)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case rule.Status >= 300 && rule.Status < 400:
+			i.addUserHeaders(w)
+			http.Redirect(w, r, to, rule.Status)
+			return true
+		case strings.HasPrefix(to, "/"):
+			if i.serveRedirectTarget(w, r, ipath.Join(rootPath, to), rule.Status) {
+				return true
+			}
+			// a rule pointing at a missing file should not shadow the next one
+		}
+	}
+	return false
+}
+
+// getRedirectRules returns the rules of the _redirects file of the website
+// at rootPath, if it has one. The rules are cached by the CID of the root of
+// the website, along with the parse errors, and the absence of a file.
+func (i *gatewayHandler) getRedirectRules(ctx context.Context, rootPath ipath.Path) ([]redirectRule, error) {
+	root, err := i.api.ResolvePath(ctx, rootPath)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := i.redirects.get(root.Cid()); ok {
+		return e.rules, e.err
+	}
+
+	nd, err := i.api.Dag().Get(ctx, root.Cid())
+	if err != nil {
+		return nil, err
+	}
+	dir, err := uio.NewDirectoryFromNode(i.api.Dag(), nd)
+	if err == uio.ErrNotADir {
+		i.redirects.put(root.Cid(), redirectsEntry{})
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	redirectsNode, err := dir.Find(ctx, redirectsFilename)
+	if err == os.ErrNotExist {
+		i.redirects.put(root.Cid(), redirectsEntry{})
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	node, err := i.api.Unixfs().Get(ctx, ipath.IpfsPath(redirectsNode.Cid()))
+	if err != nil {
+		return nil, err
+	}
+	defer node.Close()
+
+	var e redirectsEntry
+	if f, ok := node.(files.File); !ok {
+		e.err = fmt.Errorf("%s is not a file", redirectsFilename)
+	} else if size, err := f.Size(); err != nil {
+		return nil, err
+	} else if size > maxRedirectsFileSize {
+		e.err = fmt.Errorf("%s is larger than %d bytes", redirectsFilename, maxRedirectsFileSize)
+	} else {
+		// read it all first, so that only parse errors are cached
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			return nil, err
+		}
+		e.rules, e.err = parseRedirectsFile(bytes.NewReader(data))
+	}
+	if e.err != nil {
+		// only logged once per website, as it is cached
+		log.Warnf("ignoring the %s file of %s: %s", redirectsFilename, rootPath, e.err)
+	}
+	i.redirects.put(root.Cid(), e)
+	return e.rules, e.err
+}
+
+// redirectsCache keeps the parsed _redirects files of the last websites by
+// the CID of their root, so that they aren't read again on every request for
+// a missing path.
+type redirectsCache struct {
+	mu      sync.Mutex
+	entries map[cid.Cid]redirectsEntry
+	order   []cid.Cid // oldest first
+}
+
+// redirectsEntry is the outcome of reading a _redirects file: its rules, or
+// why it is invalid. Websites without one have an empty entry.
+type redirectsEntry struct {
+	rules []redirectRule
+	err   error
+}
+
+func newRedirectsCache() *redirectsCache {
+	return &redirectsCache{entries: make(map[cid.Cid]redirectsEntry)}
+}
+
+func (c *redirectsCache) get(root cid.Cid) (redirectsEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[root]
+	return e, ok
+}
+
+func (c *redirectsCache) put(root cid.Cid, e redirectsEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[root]; ok {
+		return
+	}
+	if len(c.order) >= maxCachedRedirects {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.entries[root] = e
+	c.order = append(c.order, root)
+}
+
+// serveRedirectTarget writes the file at targetPath with the given status
+// code: 200 for rewrites, 4xx for custom error pages.
+func (i *gatewayHandler) serveRedirectTarget(w http.ResponseWriter, r *http.Request, targetPath ipath.Path, status int) bool {
+	resolvedTarget, err := i.api.ResolvePath(r.Context(), targetPath)
+	if err != nil {
+		return false
+	}
+	node, err := i.api.Unixfs().Get(r.Context(), resolvedTarget)
+	if err != nil {
+		return false
+	}
+	f, ok := node.(files.File)
+	if !ok {
+		node.Close()
+		return false
+	}
+	defer f.Close()
+
+	size, err := f.Size()
+	if err != nil {
+		return false
+	}
+
+	i.addUserHeaders(w)
+	w.Header().Set("X-IPFS-Path", targetPath.String())
+
+	name := gopath.Base(targetPath.String())
+	if status == http.StatusOK {
+		i.serveFile(w, r, resolvedTarget, name, time.Now(), f)
+		return true
+	}
+
+	ctype := mime.TypeByExtension(gopath.Ext(name))
+	if ctype == "" {
+		ctype = "text/html"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, _ = io.CopyN(w, f, size)
+	}
+	return true
+}
@@ -0,0 +1,205 @@
+package corehttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	merkledag "github.com/ipfs/go-merkledag"
+	path "github.com/ipfs/go-path"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+func TestParseRedirectsFile(t *testing.T) {
+	rules, err := parseRedirectsFile(strings.NewReader(`
+# comment
+/home              /                   301
+/app/*             /index.html         200
+/blog/:year/:month /posts/:year-:month 302
+/old-*             /new/:splat
+/*                 /404.html           404
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 5 {
+		t.Fatalf("expected 5 rules, got %d", len(rules))
+	}
+	if rules[3].Status != http.StatusMovedPermanently {
+		t.Fatalf("expected default status 301, got %d", rules[3].Status)
+	}
+
+	for _, bad := range []string{
+		"/only-from",
+		"/a /b 200 extra",
+		"relative /b",
+		"/a relative",
+		"/a /b 500",
+		"/a /b abc",
+		"/*/a /b",
+	} {
+		if _, err := parseRedirectsFile(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestRedirectRuleMatch(t *testing.T) {
+	for _, test := range []struct {
+		from, to, path string
+		ok             bool
+		expected       string
+	}{
+		{"/home", "/", "/home", true, "/"},
+		{"/home", "/", "/home/", true, "/"},
+		{"/home", "/", "/homepage", false, ""},
+		{"/app/*", "/index.html", "/app", true, "/index.html"},
+		{"/app/*", "/index.html", "/app/a/b", true, "/index.html"},
+		{"/app/*", "/index.html", "/other", false, ""},
+		{"/a/*", "/b/:splat", "/a/c/d", true, "/b/c/d"},
+		{"/old-*", "/new/:splat", "/old-page/x", true, "/new/page/x"},
+		{"/blog/:year/:month", "/posts/:year-:month", "/blog/2021/05", true, "/posts/2021-05"},
+		{"/blog/:year/:month", "/posts/:year-:month", "/blog/2021", false, ""},
+		{"/blog/:year/:month", "/posts/:year-:month", "/blog/2021/05/x", false, ""},
+		{"/:a/:ab", "/:ab/:a", "/x/y", true, "/y/x"},
+		{"/*", "/404.html", "/anything/at/all", true, "/404.html"},
+		{"/go", "https://example.com/", "/go", true, "https://example.com/"},
+	} {
+		rule := redirectRule{From: test.from, To: test.to}
+		to, ok := rule.match(test.path)
+		if ok != test.ok || to != test.expected {
+			t.Errorf("%s -> %s on %s: got (%q, %t), expected (%q, %t)", test.from, test.to, test.path, to, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestGatewayRedirectsFile(t *testing.T) {
+	ns := mockNamesys{}
+	ts, api, ctx := newTestServerAndNode(t, ns)
+	t.Logf("test server url: %s", ts.URL)
+
+	site := files.NewMapDirectory(map[string]files.Node{
+		"_redirects": files.NewBytesFile([]byte(`
+/app/*      /index.html  200
+/old/:name  /new/:name   302
+/*          /404.html    404
+`)),
+		"index.html": files.NewBytesFile([]byte("spa")),
+		"404.html":   files.NewBytesFile([]byte("not here")),
+		"exists.txt": files.NewBytesFile([]byte("exists")),
+	})
+	k, err := api.Unixfs().Add(ctx, site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.net"] = path.FromString(k.String())
+
+	for _, test := range []struct {
+		host     string
+		path     string
+		status   int
+		body     string
+		location string
+	}{
+		{"example.net", "/app/some/route", http.StatusOK, "spa", ""},
+		{"example.net", "/old/page", http.StatusFound, "", "/new/page"},
+		{"example.net", "/missing", http.StatusNotFound, "not here", ""},
+		// existing files are not shadowed by rules
+		{"example.net", "/exists.txt", http.StatusOK, "exists", ""},
+		// rules are not applied without a dedicated origin
+		{"127.0.0.1", k.String() + "/app/some/route", http.StatusNotFound, "", ""},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = test.host
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != test.status {
+			t.Errorf("%s%s: status is %d, expected %d", test.host, test.path, res.StatusCode, test.status)
+		}
+		if test.body != "" {
+			body, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != test.body {
+				t.Errorf("%s%s: body is %q, expected %q", test.host, test.path, body, test.body)
+			}
+		}
+		res.Body.Close()
+		if loc := res.Header.Get("Location"); loc != test.location {
+			t.Errorf("%s%s: location is %q, expected %q", test.host, test.path, loc, test.location)
+		}
+	}
+}
+
+func TestGetRedirectRules(t *testing.T) {
+	_, api, ctx := newTestServerAndNode(t, mockNamesys{})
+	i := newGatewayHandler(GatewayConfig{}, api)
+
+	add := func(entries map[string]files.Node) ipath.Resolved {
+		k, err := api.Unixfs().Add(ctx, files.NewMapDirectory(entries))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	valid := add(map[string]files.Node{
+		"_redirects": files.NewBytesFile([]byte("/a /b\n")),
+	})
+	tooLarge := add(map[string]files.Node{
+		"_redirects": files.NewBytesFile([]byte(strings.Repeat("# comment\n", maxRedirectsFileSize/10+1))),
+	})
+	none := add(map[string]files.Node{
+		"index.html": files.NewBytesFile([]byte("index")),
+	})
+
+	for _, test := range []struct {
+		root  ipath.Resolved
+		rules int
+		err   bool
+	}{
+		{valid, 1, false},
+		{tooLarge, 0, true},
+		{none, 0, false},
+	} {
+		// the second time, from the cache
+		for n := 0; n < 2; n++ {
+			rules, err := i.getRedirectRules(ctx, test.root)
+			if len(rules) != test.rules || (err != nil) != test.err {
+				t.Fatalf("%s: got %d rules (error: %v), expected %d (error: %t)", test.root, len(rules), err, test.rules, test.err)
+			}
+			if _, ok := i.redirects.get(test.root.Cid()); !ok {
+				t.Fatalf("%s: not cached", test.root)
+			}
+		}
+	}
+}
+
+func TestRedirectsCacheEviction(t *testing.T) {
+	c := newRedirectsCache()
+	var roots []cid.Cid
+	for n := 0; n <= maxCachedRedirects; n++ {
+		root := merkledag.NewRawNode([]byte(strconv.Itoa(n))).Cid()
+		c.put(root, redirectsEntry{})
+		roots = append(roots, root)
+	}
+	if _, ok := c.get(roots[0]); ok {
+		t.Fatal("the oldest website was not evicted")
+	}
+	if _, ok := c.get(roots[maxCachedRedirects]); !ok {
+		t.Fatal("the newest website is not cached")
+	}
+	if len(c.entries) != maxCachedRedirects {
+		t.Fatalf("%d websites are cached, expected %d", len(c.entries), maxCachedRedirects)
+	}
+}
@@ -14,7 +14,7 @@ import (
 type listingTemplateData struct {
 	GatewayURL  string
 	DNSLink     bool
-	Listing     []directoryItem
+	Listing     <-chan directoryItem
 	Size        string
 	Path        string
 	Breadcrumbs []breadcrumb
@@ -1,12 +1,22 @@
 package corehttp
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -14,19 +24,30 @@ import (
 	version "github.com/ipfs/go-ipfs"
 	core "github.com/ipfs/go-ipfs/core"
 	"github.com/ipfs/go-ipfs/core/coreapi"
+	"github.com/ipfs/go-ipfs/core/coredag"
+	"github.com/ipfs/go-ipfs/denylist"
 	repo "github.com/ipfs/go-ipfs/repo"
 	namesys "github.com/ipfs/go-namesys"
 
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
 	datastore "github.com/ipfs/go-datastore"
 	syncds "github.com/ipfs/go-datastore/sync"
 	config "github.com/ipfs/go-ipfs-config"
 	files "github.com/ipfs/go-ipfs-files"
+	ipldcbor "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
+	merkledag "github.com/ipfs/go-merkledag"
 	path "github.com/ipfs/go-path"
+	ft "github.com/ipfs/go-unixfs"
+	uio "github.com/ipfs/go-unixfs/io"
 	iface "github.com/ipfs/interface-go-ipfs-core"
 	nsopts "github.com/ipfs/interface-go-ipfs-core/options/namesys"
 	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	gocar "github.com/ipld/go-car"
 	ci "github.com/libp2p/go-libp2p-core/crypto"
 	id "github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	mh "github.com/multiformats/go-multihash"
 )
 
 // `ipfs object new unixfs-dir`
@@ -706,6 +727,738 @@ func TestGoGetSupport(t *testing.T) {
 	}
 }
 
+func TestGatewayRawBlock(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+	t.Logf("test server url: %s", ts.URL)
+
+	k, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("fnord")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := api.Block().Get(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadAll(blk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path   string
+		accept string
+	}{
+		{k.String() + "?format=raw", ""},
+		{k.String(), "application/vnd.ipld.raw"},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status is %d, expected 200", test.path, res.StatusCode)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/vnd.ipld.raw" {
+			t.Fatalf("%s: unexpected Content-Type: %s", test.path, ct)
+		}
+		if cc := res.Header.Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+			t.Fatalf("%s: expected immutable Cache-Control, got %q", test.path, cc)
+		}
+		if string(body) != string(expected) {
+			t.Fatalf("%s: body does not match the raw block", test.path)
+		}
+	}
+}
+
+func TestGatewayCar(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+	t.Logf("test server url: %s", ts.URL)
+
+	dir := files.NewMapDirectory(map[string]files.Node{
+		"a.txt": files.NewBytesFile([]byte("fnord")),
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"b.txt": files.NewBytesFile([]byte("lorem ipsum")),
+		}),
+	})
+	k, err := api.Unixfs().Add(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := api.ResolvePath(ctx, ipath.Join(k, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path   string
+		accept string
+		root   cid.Cid
+		blocks int
+	}{
+		{k.String() + "?format=car", "", k.Cid(), 4},
+		{k.String(), "application/vnd.ipld.car", k.Cid(), 4},
+		{k.String() + "/sub", "text/html, application/vnd.ipld.car; version=1", sub.Cid(), 2},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status is %d, expected 200", test.path, res.StatusCode)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/vnd.ipld.car; version=1" {
+			t.Fatalf("%s: unexpected Content-Type: %s", test.path, ct)
+		}
+
+		cr, err := gocar.NewCarReader(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cr.Header.Roots) != 1 || !cr.Header.Roots[0].Equals(test.root) {
+			t.Fatalf("%s: unexpected roots: %v", test.path, cr.Header.Roots)
+		}
+		var n int
+		for {
+			blk, err := cr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// verify the block the same way an untrusting client would
+			c, err := blk.Cid().Prefix().Sum(blk.RawData())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !c.Equals(blk.Cid()) {
+				t.Fatalf("%s: block %s does not match its data", test.path, blk.Cid())
+			}
+			n++
+		}
+		if n != test.blocks {
+			t.Fatalf("%s: got %d blocks, expected %d", test.path, n, test.blocks)
+		}
+	}
+
+	// unsupported CAR versions and formats are rejected
+	for _, test := range []struct {
+		path   string
+		accept string
+	}{
+		{k.String(), "application/vnd.ipld.car; version=2"},
+		{k.String() + "?format=unknown", ""},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%s (%s): status is %d, expected 400", test.path, test.accept, res.StatusCode)
+		}
+	}
+}
+
+func TestGatewayArchive(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+	t.Logf("test server url: %s", ts.URL)
+
+	dir := files.NewMapDirectory(map[string]files.Node{
+		"a.txt": files.NewBytesFile([]byte("fnord")),
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"b.txt": files.NewBytesFile([]byte("lorem ipsum")),
+		}),
+	})
+	k, err := api.Unixfs().Add(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := k.Cid().String()
+	expected := map[string]string{
+		root + "/":          "",
+		root + "/a.txt":     "fnord",
+		root + "/sub/":      "",
+		root + "/sub/b.txt": "lorem ipsum",
+	}
+
+	get := func(path string) (*http.Response, []byte) {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status is %d, expected 200: %s", path, res.StatusCode, body)
+		}
+		return res, body
+	}
+
+	res, body := get(k.String() + "?format=tar")
+	if ct := res.Header.Get("Content-Type"); ct != "application/x-tar" {
+		t.Fatalf("unexpected Content-Type: %s", ct)
+	}
+	if cd := res.Header.Get("Content-Disposition"); !strings.Contains(cd, root+".tar") {
+		t.Fatalf("unexpected Content-Disposition: %s", cd)
+	}
+	got := make(map[string]string)
+	tr := tar.NewReader(bytes.NewReader(body))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := hdr.Name
+		if hdr.Typeflag == tar.TypeDir {
+			name += "/"
+		}
+		got[name] = string(data)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected tar contents: %v", got)
+	}
+
+	// the name can't put the entries outside of the extraction directory
+	for _, filename := range []string{"folder.zip", "../../folder.zip", `..\..\folder.zip`, "/tmp/folder.zip"} {
+		res, body = get(k.String() + "?format=zip&filename=" + url.QueryEscape(filename))
+		if ct := res.Header.Get("Content-Type"); ct != "application/zip" {
+			t.Fatalf("unexpected Content-Type: %s", ct)
+		}
+		if cd := res.Header.Get("Content-Disposition"); !strings.Contains(cd, `"folder.zip"`) {
+			t.Fatalf("%s: unexpected Content-Disposition: %s", filename, cd)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = make(map[string]string)
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[f.Name] = string(data)
+		}
+		for name, data := range expected {
+			name = "folder" + strings.TrimPrefix(name, root)
+			if got[name] != data {
+				t.Fatalf("%s: %s: got %q, expected %q (archive: %v)", filename, name, got[name], data, got)
+			}
+		}
+		if len(got) != len(expected) {
+			t.Fatalf("%s: unexpected zip contents: %v", filename, got)
+		}
+	}
+
+	for _, filename := range []string{"..", "../..", ".zip", "folder/..", `folder\..`} {
+		for _, format := range []string{"tar", "zip"} {
+			res, err := http.Get(ts.URL + k.String() + "?format=" + format + "&filename=" + url.QueryEscape(filename))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusBadRequest {
+				t.Fatalf("%s (%s): status is %d, expected 400", filename, format, res.StatusCode)
+			}
+		}
+	}
+}
+
+func TestGatewayArchiveUnsafeNames(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	// link names and symlink targets that would be extracted outside of
+	// the top-level directory
+	newFile := func(data string) *merkledag.ProtoNode {
+		return merkledag.NodeWithData(ft.FilePBData([]byte(data), uint64(len(data))))
+	}
+	newSymlink := func(target string) *merkledag.ProtoNode {
+		data, err := ft.SymlinkData(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return merkledag.NodeWithData(data)
+	}
+	dir := merkledag.NodeWithData(ft.FolderPBData())
+	links := map[string]*merkledag.ProtoNode{
+		"ok.txt":        newFile("fnord"),
+		"in":            newSymlink("ok.txt"),
+		"..":            newFile("evil"),
+		`..\evil.txt`:   newFile("evil"),
+		"a/../../b.txt": newFile("evil"),
+		"up":            newSymlink("../../etc/passwd"),
+		"abs":           newSymlink("/etc/passwd"),
+	}
+	nodes := []ipld.Node{dir}
+	for name, nd := range links {
+		if err := dir.AddNodeLink(name, nd); err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, nd)
+	}
+	if err := api.Dag().AddMany(ctx, nodes); err != nil {
+		t.Fatal(err)
+	}
+	root := dir.Cid().String()
+	expected := []string{root, root + "/in", root + "/ok.txt"}
+
+	for _, format := range []string{"tar", "zip"} {
+		res, err := http.Get(ts.URL + "/ipfs/" + root + "?format=" + format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status is %d, expected 200: %s", format, res.StatusCode, body)
+		}
+		if streamErr := res.Trailer.Get("X-Stream-Error"); streamErr != "" {
+			t.Fatalf("%s: unexpected stream error: %s", format, streamErr)
+		}
+
+		var names []string
+		if format == "tar" {
+			tr := tar.NewReader(bytes.NewReader(body))
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, hdr.Name)
+			}
+		} else {
+			zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range zr.File {
+				names = append(names, strings.TrimSuffix(f.Name, "/"))
+			}
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("%s: expected the entries %v, got %v", format, expected, names)
+		}
+		if bytes.Contains(body, []byte("evil")) || bytes.Contains(body, []byte("passwd")) {
+			t.Errorf("%s: an unsafe entry is in the archive", format)
+		}
+	}
+}
+
+func TestGatewayCodecNodes(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+	t.Logf("test server url: %s", ts.URL)
+
+	file, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ipldcbor.FromJSON(strings.NewReader(`{"name":"meta","file":{"/":"`+file.Cid().String()+`"},"list":[1,2]}`), math.MaxUint64, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Dag().Add(ctx, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	// the node can't decode dag-json blocks, store it as is
+	dagJSONData := []byte(`{"n":1,"parent":{"/":"` + meta.Cid().String() + `"}}`)
+	h, err := mh.Sum(dagJSONData, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := blocks.NewBlockWithCid(dagJSONData, cid.NewCidV1(coredag.DagJSON, h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Dag().Add(ctx, &merkledag.RawNode{Block: blk}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path, accept string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status is %d, expected 200: %s", path, res.StatusCode, body)
+		}
+		return res, string(body)
+	}
+
+	metaPath := "/ipfs/" + meta.Cid().String()
+	dagJSONPath := "/ipfs/" + blk.Cid().String()
+
+	// dag-cbor as dag-json, with links in the {"/": cid} form
+	res, body := get(metaPath, "application/json")
+	if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected Content-Type: %s", ct)
+	}
+	if expected := `{"file":{"/":"` + file.Cid().String() + `"},"list":[1,2],"name":"meta"}`; body != expected {
+		t.Fatalf("unexpected JSON: %s, expected %s", body, expected)
+	}
+
+	// values inside of a node
+	if _, body := get(metaPath+"/name", "application/json"); body != `"meta"` {
+		t.Fatalf("unexpected JSON for /name: %s", body)
+	}
+
+	// dag-json blocks are returned as-is
+	if _, body := get(dagJSONPath, "application/json"); body != string(dagJSONData) {
+		t.Fatalf("unexpected JSON for dag-json block: %s", body)
+	}
+	_, body = get(dagJSONPath, "text/html")
+	for _, expected := range []string{`href="` + metaPath + `"`, "<th>parent</th>", "dag-json"} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("expected %q in the HTML page:\n%s", expected, body)
+		}
+	}
+
+	// browsers get a HTML explorer with clickable links
+	res, body = get(metaPath, "text/html,application/xhtml+xml,*/*;q=0.8")
+	if ct := res.Header.Get("Content-Type"); ct != "text/html" {
+		t.Fatalf("unexpected Content-Type: %s", ct)
+	}
+	for _, expected := range []string{
+		`href="/ipfs/` + file.Cid().String() + `"`,
+		`href="` + metaPath + `/name"`,
+		`&#34;meta&#34;`,
+		"dag-cbor",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("expected %q in the HTML page:\n%s", expected, body)
+		}
+	}
+
+	// links to UnixFS are followed as usual
+	if _, body := get(metaPath+"/file", ""); body != "hello" {
+		t.Fatalf("unexpected body for linked file: %s", body)
+	}
+}
+
+func TestGatewayDirectoryListingPages(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+	t.Logf("test server url: %s", ts.URL)
+
+	// the last page has to be the same with and without sharding
+	for _, sharded := range []bool{false, true} {
+		uio.UseHAMTSharding = sharded
+		entries := make(map[string]files.Node)
+		for i := 0; i < 5; i++ {
+			entries[fmt.Sprintf("file-%d", i)] = files.NewBytesFile([]byte(fmt.Sprintf("%d", i)))
+		}
+		k, err := api.Unixfs().Add(ctx, files.NewMapDirectory(entries))
+		uio.UseHAMTSharding = false
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		listed := func(query string) ([]string, string) {
+			res, err := http.Get(ts.URL + k.String() + "/" + query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			body, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("%s: status is %d, expected 200: %s", query, res.StatusCode, body)
+			}
+			var names []string
+			for i := 0; i < len(entries); i++ {
+				name := fmt.Sprintf("file-%d", i)
+				if strings.Contains(string(body), ">"+name+"</a>") {
+					names = append(names, name)
+				}
+			}
+			return names, res.Header.Get("Link")
+		}
+
+		all, link := listed("")
+		if len(all) != len(entries) || link != "" {
+			t.Fatalf("sharded=%t: unexpected full listing: %v (link: %q)", sharded, all, link)
+		}
+
+		var paged []string
+		names, link := listed("?limit=2")
+		if len(names) != 2 || !strings.Contains(link, "offset=2") || !strings.Contains(link, `rel="next"`) {
+			t.Fatalf("sharded=%t: unexpected first page: %v (link: %q)", sharded, names, link)
+		}
+		paged = append(paged, names...)
+		names, _ = listed("?offset=2&limit=2")
+		paged = append(paged, names...)
+		names, link = listed("?offset=4&limit=2")
+		if len(names) != 1 || link != "" {
+			t.Fatalf("sharded=%t: unexpected last page: %v (link: %q)", sharded, names, link)
+		}
+		paged = append(paged, names...)
+
+		sort.Strings(paged)
+		if !reflect.DeepEqual(paged, all) {
+			t.Fatalf("sharded=%t: pages %v do not add up to %v", sharded, paged, all)
+		}
+	}
+
+	res, err := http.Get(ts.URL + emptyDir + "/?limit=-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status is %d, expected 400 for an invalid limit", res.StatusCode)
+	}
+}
+
+func TestGatewayDirectoryListingSizes(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	// the DAG of the file is larger than its content
+	k, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"file": files.NewBytesFile([]byte("0123456789")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Get(ts.URL + k.String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), ">10 B</td>") {
+		t.Fatalf("expected the size of the content of the file in the listing:\n%s", body)
+	}
+}
+
+func TestGatewayDenylist(t *testing.T) {
+	ns := mockNamesys{}
+	n, err := newNodeWithMockNamesys(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := n.Context()
+
+	blocked, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("forbidden bytes")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"blocked.txt": files.NewBytesFile([]byte("forbidden bytes")),
+		"ok.txt":      files.NewBytesFile([]byte("ok")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/blocked.example.com"] = path.FromString(dir.String())
+
+	listFile := filepath.Join(t.TempDir(), "denylist")
+	err = ioutil.WriteFile(listFile, []byte(blocked.Cid().String()+"\n/ipns/blocked.example.com\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Denylist, err = denylist.New(listFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+	dh.Handler, err = makeHandler(n, ts.Listener, GatewayOption(false, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{blocked.String(), http.StatusGone},
+		{dir.String() + "/blocked.txt", http.StatusGone},
+		{dir.String() + "/ok.txt", http.StatusOK},
+		{"/ipns/blocked.example.com/ok.txt", http.StatusGone},
+	} {
+		res, err := http.Get(ts.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != test.status {
+			t.Errorf("%s: status is %d, expected %d", test.path, res.StatusCode, test.status)
+		}
+	}
+
+	// the blocked file isn't served through its parent either
+	for _, format := range []string{"car", "tar", "zip"} {
+		res, err := http.Get(ts.URL + dir.String() + "?format=" + format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(body, []byte("forbidden bytes")) {
+			t.Errorf("%s: the blocked file is in the response", format)
+		}
+		if streamErr := res.Trailer.Get("X-Stream-Error"); !strings.Contains(streamErr, denylist.ErrBlocked.Error()) {
+			t.Errorf("%s: expected the stream to fail on the blocked file, got %q", format, streamErr)
+		}
+	}
+}
+
+// pathRepo is a repo.Mock with a directory, for the denylist file.
+type pathRepo struct {
+	*repo.Mock
+	path string
+}
+
+func (r *pathRepo) Path() string { return r.path }
+
+func TestGatewayDenylistReload(t *testing.T) {
+	r := &pathRepo{
+		Mock: &repo.Mock{
+			C: config.Config{
+				Identity: config.Identity{
+					PeerID: "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe", // required by offline node
+				},
+			},
+			D: syncds.MutexWrap(datastore.NewMapDatastore()),
+		},
+		path: t.TempDir(),
+	}
+	n, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	if n.Denylist == nil {
+		t.Fatal("expected the node to load the denylist of the repo")
+	}
+
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := api.Unixfs().Add(n.Context(), files.NewBytesFile([]byte("soon blocked")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+	dh.Handler, err = makeHandler(n, ts.Listener, GatewayOption(false, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// waitForStatus polls the gateway until the denylist is reloaded
+	waitForStatus := func(status int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			res, err := http.Get(ts.URL + p.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode == status {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("status is %d, expected %d", res.StatusCode, status)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitForStatus(http.StatusOK)
+	listFile := filepath.Join(r.path, "denylist")
+	if err := ioutil.WriteFile(listFile, []byte(p.Cid().String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(http.StatusGone)
+	if err := ioutil.WriteFile(listFile, []byte("# nothing blocked\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(http.StatusOK)
+}
+
 func TestVersion(t *testing.T) {
 	version.CurrentCommit = "theshortcommithash"
 
@@ -0,0 +1,278 @@
+package corehttp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core/node"
+	"github.com/ipfs/go-ipfs/repo"
+
+	config "github.com/ipfs/go-ipfs-config"
+)
+
+// gatewayTimeouts are the retrieval timeouts of the Gateway section of the
+// config, see docs/config.md. Unset or zero timeouts are disabled, which is
+// the default.
+type gatewayTimeouts struct {
+	ResolveTimeout   config.Duration
+	FirstByteTimeout config.Duration
+	StallTimeout     config.Duration
+}
+
+// addTimeoutsFromConfig sets the retrieval timeouts of c from the Gateway
+// section of the config of r.
+func addTimeoutsFromConfig(r repo.Repo, c *GatewayConfig) error {
+	var t gatewayTimeouts
+	if err := node.ExtraConfig(r, "Gateway", &t); err != nil {
+		return err
+	}
+	return t.apply(c)
+}
+
+func (t gatewayTimeouts) apply(c *GatewayConfig) error {
+	for _, d := range []struct {
+		name string
+		v    config.Duration
+		out  *time.Duration
+	}{
+		{"ResolveTimeout", t.ResolveTimeout, &c.ResolveTimeout},
+		{"FirstByteTimeout", t.FirstByteTimeout, &c.FirstByteTimeout},
+		{"StallTimeout", t.StallTimeout, &c.StallTimeout},
+	} {
+		if d.v < 0 {
+			return fmt.Errorf("invalid Gateway.%s %s: expected a positive duration", d.name, d.v)
+		}
+		*d.out = time.Duration(d.v)
+	}
+	return nil
+}
+
+// serveWithTimeouts runs h, giving up on the request when no response was
+// started within the first byte timeout, or when nothing was written for
+// longer than the stall timeout afterwards. Giving up cancels the context of
+// the request, so that pending block fetches stop. If the response wasn't
+// started yet, the client gets a 504.
+func (i *gatewayHandler) serveWithTimeouts(w http.ResponseWriter, r *http.Request, h http.HandlerFunc) {
+	if i.config.FirstByteTimeout <= 0 && i.config.StallTimeout <= 0 {
+		h(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	tw := &timeoutWriter{
+		w:         w,
+		h:         make(http.Header),
+		urlPath:   r.URL.Path,
+		firstByte: i.config.FirstByteTimeout,
+		stall:     i.config.StallTimeout,
+		cancel:    cancel,
+	}
+	tw.start()
+	defer tw.finish()
+
+	h(tw, r.WithContext(ctx))
+}
+
+// timeoutWriter is the http.ResponseWriter of serveWithTimeouts. Until the
+// response is started, headers are kept aside (like http.TimeoutHandler
+// does), so that a timeout can write its own response without racing with
+// the handler. It forwards the http.Flusher, http.CloseNotifier and
+// http.Hijacker interfaces of the underlying writer.
+type timeoutWriter struct {
+	w       http.ResponseWriter
+	h       http.Header
+	urlPath string
+
+	firstByte time.Duration
+	stall     time.Duration
+	cancel    context.CancelFunc
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	armed    bool      // the timer will fire
+	deadline time.Time // zero when no timeout applies
+	started  bool      // the status line was written
+	writing  bool      // a write to the client is in progress
+	timedOut bool
+	done     bool
+}
+
+func (tw *timeoutWriter) start() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.firstByte > 0 {
+		tw.deadline = time.Now().Add(tw.firstByte)
+		tw.timer = time.AfterFunc(tw.firstByte, tw.expire)
+		tw.armed = true
+	}
+}
+
+// finish sends the headers of a response that was never started (such as
+// HEAD responses), and stops the timer.
+func (tw *timeoutWriter) finish() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.started && !tw.timedOut {
+		copyHeader(tw.w.Header(), tw.h)
+	}
+	tw.stop()
+}
+
+// stop stops the timer for good. Must be called with mu held.
+func (tw *timeoutWriter) stop() {
+	tw.done = true
+	if tw.timer != nil {
+		tw.timer.Stop()
+	}
+}
+
+// progress moves the deadline after something was written.
+// Must be called with mu held.
+func (tw *timeoutWriter) progress() {
+	if tw.stall <= 0 {
+		tw.deadline = time.Time{}
+		return
+	}
+	tw.deadline = time.Now().Add(tw.stall)
+	switch {
+	case tw.timer == nil:
+		tw.timer = time.AfterFunc(tw.stall, tw.expire)
+	case !tw.armed:
+		tw.timer.Reset(tw.stall)
+	}
+	// an armed timer checks the new deadline and re-arms itself
+	tw.armed = true
+}
+
+func (tw *timeoutWriter) expire() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.done || tw.writing || tw.deadline.IsZero() {
+		// re-armed by the next call to progress, if any
+		tw.armed = false
+		return
+	}
+	if left := time.Until(tw.deadline); left > 0 {
+		tw.timer.Reset(left)
+		return
+	}
+
+	tw.armed = false
+	tw.timedOut = true
+	tw.cancel()
+	if tw.started {
+		log.Warnf("gateway: %s stalled for %s, aborting the response", tw.urlPath, tw.stall)
+		return
+	}
+	err := fmt.Errorf("no data could be retrieved within %s", tw.firstByte)
+	webError(tw.w, "ipfs cat "+tw.urlPath, err, http.StatusGatewayTimeout)
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.started {
+		// trailers are set after the response was started
+		return tw.w.Header()
+	}
+	return tw.h
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.started {
+		return
+	}
+	tw.writeHeader(code)
+}
+
+// Must be called with mu held.
+func (tw *timeoutWriter) writeHeader(code int) {
+	copyHeader(tw.w.Header(), tw.h)
+	tw.started = true
+	tw.progress()
+	tw.w.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(p []byte) (n int, err error) {
+	err = tw.toClient(func() {
+		n, err = tw.w.Write(p)
+	})
+	return n, err
+}
+
+// Flush implements http.Flusher.
+func (tw *timeoutWriter) Flush() {
+	f, ok := tw.w.(http.Flusher)
+	if !ok {
+		return
+	}
+	tw.toClient(f.Flush)
+}
+
+// toClient starts the response if needed, and calls write without holding
+// mu, as writes block for as long as the client takes to read. A slow client
+// is not a stalled retrieval: the timeouts are paused meanwhile.
+func (tw *timeoutWriter) toClient(write func()) error {
+	tw.mu.Lock()
+	if tw.timedOut {
+		tw.mu.Unlock()
+		return http.ErrHandlerTimeout
+	}
+	if !tw.started {
+		tw.writeHeader(http.StatusOK)
+	}
+	tw.writing = true
+	tw.mu.Unlock()
+
+	write()
+
+	tw.mu.Lock()
+	tw.writing = false
+	tw.progress()
+	tw.mu.Unlock()
+	return nil
+}
+
+// CloseNotify implements http.CloseNotifier.
+func (tw *timeoutWriter) CloseNotify() <-chan bool {
+	if cn, ok := tw.w.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// Hijack implements http.Hijacker. The timeouts no longer apply to a
+// hijacked connection.
+func (tw *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := tw.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("gateway: the response writer can't be hijacked")
+	}
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	conn, rw, err := hj.Hijack()
+	if err == nil {
+		tw.started = true
+		tw.stop()
+	}
+	return conn, rw, err
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, vv := range src {
+		dst[k] = vv
+	}
+}
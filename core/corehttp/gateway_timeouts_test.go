@@ -0,0 +1,228 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+func TestFirstByteTimeout(t *testing.T) {
+	gw := newGatewayHandler(GatewayConfig{FirstByteTimeout: 50 * time.Millisecond}, nil)
+
+	var writeErr error
+	rec := httptest.NewRecorder()
+	gw.serveWithTimeouts(rec, httptest.NewRequest(http.MethodGet, "/ipfs/foo", nil), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		<-r.Context().Done()
+		_, writeErr = io.WriteString(w, "too late")
+	})
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status is %d, expected 504", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "no data could be retrieved within 50ms") {
+		t.Fatalf("unexpected body: %q", body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("headers of the handler leaked into the error response: %s", ct)
+	}
+	if writeErr != http.ErrHandlerTimeout {
+		t.Fatalf("expected writes after the timeout to fail, got %v", writeErr)
+	}
+}
+
+func TestStallTimeout(t *testing.T) {
+	gw := newGatewayHandler(GatewayConfig{
+		FirstByteTimeout: time.Second,
+		StallTimeout:     50 * time.Millisecond,
+	}, nil)
+
+	rec := httptest.NewRecorder()
+	gw.serveWithTimeouts(rec, httptest.NewRequest(http.MethodGet, "/ipfs/foo", nil), func(w http.ResponseWriter, r *http.Request) {
+		// steady progress keeps the response alive
+		for i := 0; i < 5; i++ {
+			time.Sleep(20 * time.Millisecond)
+			if _, err := io.WriteString(w, "a"); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+			t.Error("stalled request was not canceled")
+		}
+	})
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status is %d, expected 200", rec.Code)
+	}
+	if body := rec.Body.String(); body != "aaaaa" {
+		t.Fatalf("unexpected body: %q", body)
+	}
+}
+
+func TestTimeoutsKeepHeaders(t *testing.T) {
+	gw := newGatewayHandler(GatewayConfig{FirstByteTimeout: time.Second}, nil)
+
+	rec := httptest.NewRecorder()
+	gw.serveWithTimeouts(rec, httptest.NewRequest(http.MethodHead, "/ipfs/foo", nil), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", `"foo"`)
+	})
+	if etag := rec.Header().Get("Etag"); etag != `"foo"` {
+		t.Fatalf("headers of a response without a body were lost: %v", rec.Header())
+	}
+}
+
+type blockingResolveAPI struct {
+	iface.CoreAPI
+}
+
+func (blockingResolveAPI) ResolvePath(ctx context.Context, p ipath.Path) (ipath.Resolved, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestResolveTimeout(t *testing.T) {
+	gw := newGatewayHandler(GatewayConfig{ResolveTimeout: 50 * time.Millisecond}, blockingResolveAPI{})
+
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status is %d, expected 504", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "path could not be resolved within 50ms") {
+		t.Fatalf("unexpected body: %q", body)
+	}
+}
+
+func TestTimeoutsFromConfig(t *testing.T) {
+	var timeouts gatewayTimeouts
+	if err := json.Unmarshal([]byte(`{"ResolveTimeout": "5s", "StallTimeout": "0s"}`), &timeouts); err != nil {
+		t.Fatal(err)
+	}
+	var c GatewayConfig
+	if err := timeouts.apply(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.ResolveTimeout != 5*time.Second || c.FirstByteTimeout != 0 || c.StallTimeout != 0 {
+		t.Fatalf("unexpected timeouts: %+v", c)
+	}
+
+	timeouts.StallTimeout = -1
+	if err := timeouts.apply(&c); err == nil {
+		t.Fatal("expected an error for a negative timeout")
+	}
+}
+
+// blockingWriter is a client that doesn't read the response until unblock
+// is closed.
+type blockingWriter struct {
+	*httptest.ResponseRecorder
+	writing chan struct{}
+	unblock chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	close(w.writing)
+	<-w.unblock
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestTimeoutsSlowClient(t *testing.T) {
+	gw := newGatewayHandler(GatewayConfig{
+		FirstByteTimeout: time.Second,
+		StallTimeout:     20 * time.Millisecond,
+	}, nil)
+
+	bw := &blockingWriter{
+		ResponseRecorder: httptest.NewRecorder(),
+		writing:          make(chan struct{}),
+		unblock:          make(chan struct{}),
+	}
+	var tw http.ResponseWriter
+	go func() {
+		<-bw.writing
+		// the writer isn't locked while the client is slow to read, and
+		// doesn't time out
+		unlocked := make(chan struct{})
+		go func() {
+			tw.Header()
+			close(unlocked)
+		}()
+		select {
+		case <-unlocked:
+		case <-time.After(time.Second):
+			t.Error("the writer is locked while the client reads")
+		}
+		time.Sleep(100 * time.Millisecond)
+		close(bw.unblock)
+	}()
+	gw.serveWithTimeouts(bw, httptest.NewRequest(http.MethodGet, "/ipfs/foo", nil), func(w http.ResponseWriter, r *http.Request) {
+		tw = w
+		if _, err := io.WriteString(w, "slow"); err != nil {
+			t.Error(err)
+		}
+		if err := r.Context().Err(); err != nil {
+			t.Errorf("a slow client timed the response out: %s", err)
+		}
+	})
+	if body := bw.Body.String(); body != "slow" {
+		t.Fatalf("unexpected body: %q", body)
+	}
+}
+
+func TestTimeoutWriterInterfaces(t *testing.T) {
+	gw := newGatewayHandler(GatewayConfig{
+		FirstByteTimeout: 50 * time.Millisecond,
+		StallTimeout:     50 * time.Millisecond,
+	}, nil)
+
+	rec := httptest.NewRecorder()
+	gw.serveWithTimeouts(rec, httptest.NewRequest(http.MethodGet, "/ipfs/foo", nil), func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.CloseNotifier); !ok {
+			t.Error("expected the writer to be a CloseNotifier")
+		}
+		w.(http.Flusher).Flush()
+	})
+	if !rec.Flushed || rec.Code != http.StatusOK {
+		t.Fatalf("expected the response to be flushed, got %d, %t", rec.Code, rec.Flushed)
+	}
+
+	// a hijacked connection isn't timed out
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gw.serveWithTimeouts(w, r, func(w http.ResponseWriter, r *http.Request) {
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			time.Sleep(100 * time.Millisecond)
+			buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 9\r\nConnection: close\r\n\r\nhijacked!")
+			buf.Flush()
+		})
+	}))
+	defer srv.Close()
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || string(body) != "hijacked!" {
+		t.Fatalf("unexpected response: %d %q", res.StatusCode, body)
+	}
+}
@@ -0,0 +1,309 @@
+package corehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	mdag "github.com/ipfs/go-merkledag"
+	unixfs "github.com/ipfs/go-unixfs"
+	unixfs_pb "github.com/ipfs/go-unixfs/pb"
+)
+
+// maxRangeFetch caps how many bytes of leaves are fetched (and buffered) at
+// once for a single read.
+const maxRangeFetch = 2 << 20
+
+var errNotUnixfsFile = errors.New("not a unixfs file")
+
+// byteRange is a single, already validated, range of a Range header.
+type byteRange struct {
+	start, end int64 // [start, end)
+}
+
+// dagRangeSeeker reads a UnixFS file directly from its DAG, fetching only the
+// leaves that cover the byte ranges requested by the client.
+//
+// http.ServeContent parses the Range header itself and then seeks and reads
+// each range in turn. It never tells us where a range ends, so the ranges are
+// passed in up front and used to bound how far ahead we fetch: without them,
+// any read-ahead would fetch blocks past the end of the range.
+type dagRangeSeeker struct {
+	ctx  context.Context
+	dag  ipld.NodeGetter
+	root ipld.Node
+	size int64
+
+	// ranges the client asked for, sorted by start. nil means the whole
+	// file will be read.
+	ranges []byteRange
+
+	offset int64
+
+	buf      []byte
+	bufStart int64
+}
+
+func newDagRangeSeeker(ctx context.Context, dag ipld.NodeGetter, root ipld.Node, size int64, ranges []byteRange) (*dagRangeSeeker, error) {
+	if _, _, err := fileNodeLayout(root); err != nil {
+		return nil, err
+	}
+	sorted := append([]byteRange(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+	return &dagRangeSeeker{
+		ctx:    ctx,
+		dag:    dag,
+		root:   root,
+		size:   size,
+		ranges: sorted,
+	}, nil
+}
+
+func (s *dagRangeSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekEnd:
+		offset += s.size
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekStart:
+	default:
+		return s.offset, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return s.offset, fmt.Errorf("invalid seek offset")
+	}
+	s.offset = offset
+	return s.offset, nil
+}
+
+func (s *dagRangeSeeker) Read(b []byte) (int, error) {
+	if s.offset >= s.size {
+		return 0, io.EOF
+	}
+
+	if s.offset < s.bufStart || s.offset >= s.bufStart+int64(len(s.buf)) {
+		end := s.windowEnd(s.offset)
+		if end-s.offset > maxRangeFetch {
+			end = s.offset + maxRangeFetch
+		}
+		if err := s.fill(s.offset, end); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, s.buf[s.offset-s.bufStart:])
+	s.offset += int64(n)
+	return n, nil
+}
+
+// windowEnd returns how far we may fetch ahead when reading from offset: the
+// end of the requested range containing it, or the end of the file if the
+// whole file was requested. Reads outside of the requested ranges (such as
+// content type sniffing) only fetch the leaf they need.
+func (s *dagRangeSeeker) windowEnd(offset int64) int64 {
+	if s.ranges == nil {
+		return s.size
+	}
+	for _, r := range s.ranges {
+		if offset >= r.start && offset < r.end {
+			return r.end
+		}
+	}
+	return offset + 1
+}
+
+// fill replaces the buffer with the data of the leaves overlapping
+// [start, end).
+func (s *dagRangeSeeker) fill(start, end int64) error {
+	s.buf = s.buf[:0]
+	s.bufStart = -1
+	if err := s.appendRange(s.root, 0, start, end); err != nil {
+		return err
+	}
+	if s.bufStart < 0 || s.bufStart > start || s.bufStart+int64(len(s.buf)) <= start {
+		return fmt.Errorf("DAG does not contain data at offset %d", start)
+	}
+	return nil
+}
+
+// appendRange appends the data of all the leaves under nd that overlap
+// [start, end), given that nd starts at file offset base. Children are
+// fetched in parallel, but only if they overlap the range.
+func (s *dagRangeSeeker) appendRange(nd ipld.Node, base, start, end int64) error {
+	data, sizes, err := fileNodeLayout(nd)
+	if err != nil {
+		return err
+	}
+
+	// inline data comes before the children
+	if len(data) > 0 && base < end && base+int64(len(data)) > start {
+		s.appendData(base, data)
+	}
+	off := base + int64(len(data))
+
+	links := nd.Links()
+	var (
+		want    []cid.Cid
+		offsets []int64
+	)
+	for i, size := range sizes {
+		if off < end && off+int64(size) > start {
+			want = append(want, links[i].Cid)
+			offsets = append(offsets, off)
+		}
+		off += int64(size)
+	}
+
+	for i, p := range ipld.GetNodes(s.ctx, s.dag, want) {
+		child, err := p.Get(s.ctx)
+		if err != nil {
+			return err
+		}
+		if err := s.appendRange(child, offsets[i], start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *dagRangeSeeker) appendData(off int64, data []byte) {
+	if s.bufStart < 0 {
+		s.bufStart = off
+	}
+	s.buf = append(s.buf, data...)
+}
+
+// fileNodeLayout returns the inline data of a UnixFS file node, and the sizes
+// of its children (in the same order as its links).
+func fileNodeLayout(nd ipld.Node) ([]byte, []uint64, error) {
+	switch nd := nd.(type) {
+	case *mdag.RawNode:
+		return nd.RawData(), nil, nil
+	case *mdag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+		if err != nil {
+			return nil, nil, err
+		}
+		switch fsn.Type() {
+		case unixfs_pb.Data_File, unixfs_pb.Data_Raw:
+		default:
+			return nil, nil, errNotUnixfsFile
+		}
+		if fsn.NumChildren() != len(nd.Links()) {
+			return nil, nil, fmt.Errorf("inconsistent unixfs node %s: %d block sizes for %d links", nd.Cid(), fsn.NumChildren(), len(nd.Links()))
+		}
+		return fsn.Data(), fsn.BlockSizes(), nil
+	default:
+		return nil, nil, errNotUnixfsFile
+	}
+}
+
+// requestedRanges returns the ranges http.ServeContent is going to serve for
+// this request, or nil if it is going to serve the whole file. It mirrors the
+// checks ServeContent performs, including If-Range, which is evaluated against
+// the Etag set on the response and modtime.
+func requestedRanges(r *http.Request, etag string, modtime time.Time, size int64) []byteRange {
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" || r.Method != http.MethodGet {
+		return nil
+	}
+	if !ifRangeMatches(r.Header.Get("If-Range"), etag, modtime) {
+		return nil
+	}
+
+	ranges, err := parseRangeHeader(rangeHeader, size)
+	if err != nil || len(ranges) == 0 {
+		return nil
+	}
+	var total int64
+	for _, ra := range ranges {
+		total += ra.end - ra.start
+	}
+	if total > size {
+		// ServeContent sends the whole file instead
+		return nil
+	}
+	return ranges
+}
+
+// ifRangeMatches reports whether the ranges of a request should be honored.
+// An If-Range validator that doesn't match means the client's partial copy
+// is stale, and it gets the whole file.
+func ifRangeMatches(ifRange string, etag string, modtime time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, `W/"`) {
+		// only strong validators are allowed in If-Range
+		return etag != "" && !strings.HasPrefix(etag, "W/") && ifRange == etag
+	}
+	if modtime.IsZero() || modtime.Equal(time.Unix(0, 0)) {
+		return false
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && t.Unix() == modtime.Unix()
+}
+
+// parseRangeHeader parses a Range header the same way http.ServeContent does
+// (RFC 7233), skipping ranges that start past the end of the file.
+func parseRangeHeader(s string, size int64) ([]byteRange, error) {
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return nil, errors.New("invalid range")
+	}
+	var ranges []byteRange
+	for _, ra := range strings.Split(s[len(b):], ",") {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+		i := strings.Index(ra, "-")
+		if i < 0 {
+			return nil, errors.New("invalid range")
+		}
+		start, end := strings.TrimSpace(ra[:i]), strings.TrimSpace(ra[i+1:])
+		var r byteRange
+		if start == "" {
+			// suffix range: the last N bytes
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errors.New("invalid range")
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{start: size - n, end: size}
+		} else {
+			i, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || i < 0 {
+				return nil, errors.New("invalid range")
+			}
+			if i >= size {
+				continue
+			}
+			r.start = i
+			if end == "" {
+				r.end = size
+			} else {
+				j, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || i > j {
+					return nil, errors.New("invalid range")
+				}
+				if j >= size {
+					j = size - 1
+				}
+				r.end = j + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
@@ -0,0 +1,255 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	mdtest "github.com/ipfs/go-merkledag/test"
+	importer "github.com/ipfs/go-unixfs/importer"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
+)
+
+type countingNodeGetter struct {
+	ipld.NodeGetter
+
+	mu      sync.Mutex
+	fetched map[cid.Cid]int
+}
+
+func (ng *countingNodeGetter) count(c cid.Cid) {
+	ng.mu.Lock()
+	ng.fetched[c]++
+	ng.mu.Unlock()
+}
+
+func (ng *countingNodeGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	ng.count(c)
+	return ng.NodeGetter.Get(ctx, c)
+}
+
+func (ng *countingNodeGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	for _, c := range cids {
+		ng.count(c)
+	}
+	return ng.NodeGetter.GetMany(ctx, cids)
+}
+
+func newTestFileDag(t *testing.T, size int) ([]byte, ipld.Node, *countingNodeGetter) {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(42)).Read(data)
+
+	ds := mdtest.Mock()
+	// 10 byte leaves, so that the file has more than one layer of nodes
+	root, err := importer.BuildDagFromReader(ds, chunker.NewSizeSplitter(bytes.NewReader(data), 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, root, &countingNodeGetter{NodeGetter: ds, fetched: make(map[cid.Cid]int)}
+}
+
+func TestDagRangeSeeker(t *testing.T) {
+	ctx := context.Background()
+	data, root, ng := newTestFileDag(t, 5000)
+
+	s, err := newDagRangeSeeker(ctx, ng, root, int64(len(data)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := ioutil.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(all, data) {
+		t.Fatal("full read does not match the file")
+	}
+
+	for _, off := range []int64{0, 9, 10, 1234, 4990, 4999} {
+		if _, err := s.Seek(off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 7)
+		n, err := io.ReadFull(s, b)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b[:n], data[off:off+int64(n)]) {
+			t.Fatalf("read at %d does not match the file", off)
+		}
+	}
+}
+
+func TestDagRangeSeekerFetchesOnlyRange(t *testing.T) {
+	ctx := context.Background()
+	data, root, ng := newTestFileDag(t, 5000)
+
+	ranges := []byteRange{{start: 2005, end: 2025}, {start: 10, end: 20}}
+	s, err := newDagRangeSeeker(ctx, ng, root, int64(len(data)), ranges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range ranges {
+		if _, err := s.Seek(r.start, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, r.end-r.start)
+		if _, err := io.ReadFull(s, b); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, data[r.start:r.end]) {
+			t.Fatalf("range %d-%d does not match the file", r.start, r.end)
+		}
+	}
+
+	leaves := 0
+	for c := range ng.fetched {
+		if c.Type() == cid.Raw || c.Prefix().Codec == cid.DagProtobuf {
+			nd, err := ng.NodeGetter.Get(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(nd.Links()) == 0 {
+				leaves++
+			}
+		}
+	}
+	// 2005-2025 spans 3 leaves, 10-20 exactly one
+	if leaves != 4 {
+		t.Fatalf("expected 4 leaves to be fetched, got %d", leaves)
+	}
+}
+
+func TestParseRangeHeader(t *testing.T) {
+	for _, test := range []struct {
+		header string
+		ranges []byteRange
+		err    bool
+	}{
+		{"bytes=0-9", []byteRange{{0, 10}}, false},
+		{"bytes=90-", []byteRange{{90, 100}}, false},
+		{"bytes=-5", []byteRange{{95, 100}}, false},
+		{"bytes=0-0, 50-200", []byteRange{{0, 1}, {50, 100}}, false},
+		{"bytes=200-300", nil, false},
+		{"bytes=5-1", nil, true},
+		{"items=0-9", nil, true},
+		{"bytes=a-b", nil, true},
+	} {
+		ranges, err := parseRangeHeader(test.header, 100)
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.header, err)
+			continue
+		}
+		if len(ranges) != len(test.ranges) {
+			t.Errorf("%s: got %v, expected %v", test.header, ranges, test.ranges)
+			continue
+		}
+		for i := range ranges {
+			if ranges[i] != test.ranges[i] {
+				t.Errorf("%s: got %v, expected %v", test.header, ranges, test.ranges)
+			}
+		}
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	modtime := time.Unix(1, 0)
+	etag := `"bafy"`
+	for _, test := range []struct {
+		ifRange string
+		etag    string
+		match   bool
+	}{
+		{"", etag, true},
+		{etag, etag, true},
+		{`"other"`, etag, false},
+		{`W/"bafy"`, etag, false},
+		{etag, `W/"bafy"`, false},
+		{modtime.UTC().Format(http.TimeFormat), etag, true},
+		{time.Unix(1000, 0).UTC().Format(http.TimeFormat), etag, false},
+	} {
+		if m := ifRangeMatches(test.ifRange, test.etag, modtime); m != test.match {
+			t.Errorf("If-Range %q with Etag %q: got %t, expected %t", test.ifRange, test.etag, m, test.match)
+		}
+	}
+}
+
+func TestGatewayRangeRequests(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	data := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(data)
+	k, err := api.Unixfs().Add(ctx, files.NewBytesFile(data), options.Unixfs.Chunker("size-10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := `"` + k.Cid().String() + `"`
+
+	get := func(rangeHeader, ifRange string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+k.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Range", rangeHeader)
+		if ifRange != "" {
+			req.Header.Set("If-Range", ifRange)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	// single range
+	res := get("bytes=105-114", "")
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent || !bytes.Equal(body, data[105:115]) {
+		t.Fatalf("single range: got %d with %d bytes", res.StatusCode, len(body))
+	}
+
+	// multiple ranges, with a matching If-Range
+	res = get("bytes=0-4,500-519", etag)
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent {
+		t.Fatalf("multi range: status is %d, expected 206", res.StatusCode)
+	}
+	mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("multi range: unexpected Content-Type %q", res.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(res.Body, params["boundary"])
+	for _, expected := range [][]byte{data[0:5], data[500:520]} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, expected) {
+			t.Fatalf("multi range: part does not match the file")
+		}
+	}
+
+	// a stale If-Range gets the whole file
+	res = get("bytes=0-4", `"stale"`)
+	body, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !bytes.Equal(body, data) {
+		t.Fatalf("stale If-Range: got %d with %d bytes", res.StatusCode, len(body))
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/ipfs/go-ipfs/core"
+	corenode "github.com/ipfs/go-ipfs/core/node"
 	"github.com/ipfs/go-ipfs/gc"
 	"github.com/ipfs/go-ipfs/repo"
 
@@ -20,6 +22,15 @@ var log = logging.Logger("corerepo")
 
 var ErrMaxStorageExceeded = errors.New("maximum storage limit exceeded. Try to unpin some files")
 
+// lruTargetConfigKey is the config key setting the percentage of
+// Datastore.StorageMax down to which PeriodicLRUGC evicts blocks.
+const lruTargetConfigKey = "Datastore.StorageLRUTarget"
+
+// DefaultLRUTargetMargin is how many percents of Datastore.StorageMax below
+// Datastore.StorageGCWatermark PeriodicLRUGC evicts blocks down to, by
+// default.
+const DefaultLRUTargetMargin = 10
+
 type GC struct {
 	Node       *core.IpfsNode
 	Repo       repo.Repo
@@ -27,6 +38,17 @@ type GC struct {
 	StorageGC  uint64
 	SlackGB    uint64
 	Storage    uint64
+
+	// Incremental runs the collections with GarbageCollectIncremental.
+	Incremental bool
+
+	// LRU evicts the least recently used blocks with GarbageCollectLRU,
+	// until the storage drops below LRUTarget.
+	LRU       bool
+	LRUTarget uint64
+
+	// flush writes what must be kept between the collections.
+	flush func() error
 }
 
 func NewGC(n *core.IpfsNode) (*GC, error) {
@@ -93,6 +115,30 @@ func GarbageCollect(n *core.IpfsNode, ctx context.Context) error {
 	return CollectResult(ctx, rmed, nil)
 }
 
+// GarbageCollectIncremental runs an incremental garbage collection, which
+// doesn't block adds and pins for as long as it runs, see gc.IncrementalGC.
+func GarbageCollectIncremental(n *core.IpfsNode, ctx context.Context) error {
+	rmed := GarbageCollectIncrementalAsync(n, ctx)
+
+	return CollectResult(ctx, rmed, nil)
+}
+
+// GarbageCollectLRU evicts the least recently used blocks which aren't
+// pinned, until free bytes are freed, see gc.EvictLRU.
+func GarbageCollectLRU(n *core.IpfsNode, ctx context.Context, free uint64) error {
+	bs, ok := n.Blockstore.(*gc.TrackingBlockstore)
+	if !ok {
+		return gc.ErrIncrementalGCUnsupported
+	}
+
+	roots := func() ([]cid.Cid, error) {
+		return BestEffortRoots(n.FilesRoot)
+	}
+	rmed := gc.EvictLRU(ctx, bs, n.Repo.Datastore(), n.Pinning, roots, free)
+
+	return CollectResult(ctx, rmed, nil)
+}
+
 // CollectResult collects the output of a garbage collection run and calls the
 // given callback for each object removed.  It also collects all errors into a
 // MultiError which is returned after the gc is completed.
@@ -159,7 +205,96 @@ func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context) <-chan gc.Result
 	return gc.GC(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots)
 }
 
+// GarbageCollectIncrementalAsync is GarbageCollectAsync for incremental
+// garbage collections.
+func GarbageCollectIncrementalAsync(n *core.IpfsNode, ctx context.Context) <-chan gc.Result {
+	bs, ok := n.Blockstore.(*gc.TrackingBlockstore)
+	if !ok {
+		out := make(chan gc.Result, 1)
+		out <- gc.Result{Error: gc.ErrIncrementalGCUnsupported}
+		close(out)
+		return out
+	}
+
+	roots := func() ([]cid.Cid, error) {
+		return BestEffortRoots(n.FilesRoot)
+	}
+	return gc.IncrementalGC(ctx, bs, n.Repo.Datastore(), n.Pinning, roots)
+}
+
+// GarbageCollectDryRunAsync reports the objects that GarbageCollectAsync
+// would remove, without removing them, see gc.DryRun.
+func GarbageCollectDryRunAsync(n *core.IpfsNode, ctx context.Context) <-chan gc.Result {
+	roots, err := BestEffortRoots(n.FilesRoot)
+	if err != nil {
+		out := make(chan gc.Result, 1)
+		out <- gc.Result{Error: err}
+		close(out)
+		return out
+	}
+
+	return gc.DryRun(ctx, n.Blockstore, n.Pinning, roots)
+}
+
+// ExplainGC finds why garbage collections keep the given objects, see
+// gc.Explain.
+func ExplainGC(n *core.IpfsNode, ctx context.Context, keys []cid.Cid) (map[cid.Cid][]gc.Retention, error) {
+	roots, err := BestEffortRoots(n.FilesRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	return gc.Explain(ctx, n.Blockstore, n.Pinning, roots, keys)
+}
+
 func PeriodicGC(ctx context.Context, node *core.IpfsNode) error {
+	return periodicGC(ctx, node, nil)
+}
+
+// PeriodicIncrementalGC is PeriodicGC with incremental garbage collections.
+func PeriodicIncrementalGC(ctx context.Context, node *core.IpfsNode) error {
+	return periodicGC(ctx, node, func(g *GC) error {
+		g.Incremental = true
+		return nil
+	})
+}
+
+// PeriodicLRUGC is PeriodicGC, which only evicts the least recently used
+// blocks that aren't pinned, until the storage drops DefaultLRUTargetMargin
+// percents of Datastore.StorageMax below the watermark, or to the percentage
+// set in Datastore.StorageLRUTarget. The access times of the blocks are
+// tracked from then on, and kept in the repo.
+func PeriodicLRUGC(ctx context.Context, node *core.IpfsNode) error {
+	return periodicGC(ctx, node, func(g *GC) error {
+		bs, ok := node.Blockstore.(*gc.TrackingBlockstore)
+		if !ok {
+			return gc.ErrIncrementalGCUnsupported
+		}
+
+		g.LRU = true
+		if margin := g.StorageMax * DefaultLRUTargetMargin / 100; margin < g.StorageGC {
+			g.LRUTarget = g.StorageGC - margin
+		}
+		var target *uint64
+		if err := corenode.ExtraConfig(g.Repo, lruTargetConfigKey, &target); err != nil {
+			return err
+		}
+		if target != nil {
+			pct := *target
+			if g.StorageMax*pct/100 >= g.StorageGC {
+				return fmt.Errorf("invalid %s %d: expected a percentage of Datastore.StorageMax lower than Datastore.StorageGCWatermark", lruTargetConfigKey, pct)
+			}
+			g.LRUTarget = g.StorageMax * pct / 100
+		}
+
+		at := gc.NewAccessTimes(node.Repo.Datastore())
+		bs.TrackAccessTimes(at)
+		g.flush = at.Flush
+		return nil
+	})
+}
+
+func periodicGC(ctx context.Context, node *core.IpfsNode, setup func(*GC) error) error {
 	cfg, err := node.Repo.Config()
 	if err != nil {
 		return err
@@ -182,10 +317,18 @@ func PeriodicGC(ctx context.Context, node *core.IpfsNode) error {
 	if err != nil {
 		return err
 	}
+	if setup != nil {
+		if err := setup(gc); err != nil {
+			return err
+		}
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
+			if gc.flush != nil {
+				return gc.flush()
+			}
 			return nil
 		case <-time.After(period):
 			// the private func maybeGC doesn't compute storageMax, storageGC, slackGC so that they are not re-computed for every cycle
@@ -218,7 +361,17 @@ func (gc *GC) maybeGC(ctx context.Context, offset uint64) error {
 		// Do GC here
 		log.Info("Watermark exceeded. Starting repo GC...")
 
-		if err := GarbageCollect(gc.Node, ctx); err != nil {
+		collect := GarbageCollect
+		switch {
+		case gc.LRU:
+			free := storage + offset - gc.LRUTarget
+			collect = func(n *core.IpfsNode, ctx context.Context) error {
+				return GarbageCollectLRU(n, ctx, free)
+			}
+		case gc.Incremental:
+			collect = GarbageCollectIncremental
+		}
+		if err := collect(gc.Node, ctx); err != nil {
 			return err
 		}
 		log.Infof("Repo GC done. See `ipfs repo stat` to see how much space got freed.\n")
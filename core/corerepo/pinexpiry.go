@@ -0,0 +1,39 @@
+package corerepo
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreapi"
+)
+
+// PinExpiryPeriod is how often the daemon removes the expired pins.
+const PinExpiryPeriod = time.Minute
+
+// PeriodicPinExpiry removes the pins whose expiry time has passed, see the
+// pinmeta package, right away and then every PinExpiryPeriod, until ctx is
+// done.
+func PeriodicPinExpiry(ctx context.Context, node *core.IpfsNode) error {
+	api, err := coreapi.NewCoreAPI(node)
+	if err != nil {
+		return err
+	}
+	pins := api.Pin().(coreapi.PinInfoAPI)
+
+	for {
+		removed, err := pins.RemoveExpired(ctx, time.Now())
+		if err != nil {
+			log.Errorf("removing expired pins: %s", err)
+		}
+		for _, c := range removed {
+			log.Infof("removed expired pin %s", c)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(PinExpiryPeriod):
+		}
+	}
+}
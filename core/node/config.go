@@ -0,0 +1,29 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ipfs/go-ipfs/repo"
+)
+
+// ExtraConfig decodes the value of the given key of the config file, such as
+// "Pinning.Queue", into v. It reads the options that go-ipfs-config doesn't
+// declare yet: 'ipfs config' keeps them in the config file, but they aren't
+// part of repo.Config. v is left as is when the key isn't set.
+func ExtraConfig(r repo.Repo, key string, v interface{}) error {
+	raw, err := r.GetConfigKey(key)
+	if err != nil {
+		// the key isn't set, or the repo has no config file, as with mock
+		// repos
+		return nil
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("invalid config %s: %s", key, err)
+	}
+	return nil
+}
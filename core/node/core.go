@@ -24,6 +24,7 @@ import (
 	"go.uber.org/fx"
 
 	"github.com/ipfs/go-ipfs/core/node/helpers"
+	"github.com/ipfs/go-ipfs/pinmeta"
 	"github.com/ipfs/go-ipfs/repo"
 )
 
@@ -63,6 +64,12 @@ func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo)
 	return pinning, nil
 }
 
+// PinMeta returns the store of the names, metadata and expiry times of the
+// pins, which lives in the repo datastore next to the pins.
+func PinMeta(repo repo.Repo) *pinmeta.Store {
+	return pinmeta.New(repo.Datastore())
+}
+
 var (
 	_ merkledag.SessionMaker = new(syncDagService)
 	_ format.DAGService      = new(syncDagService)
@@ -0,0 +1,46 @@
+package node
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/ipfs/go-ipfs/denylist"
+	"github.com/ipfs/go-ipfs/repo"
+	"go.uber.org/fx"
+)
+
+// DenylistFilename is the name of the denylist file in the repo directory.
+const DenylistFilename = "denylist"
+
+// Denylist loads the denylist from the repo directory and keeps it up to date
+// for the lifetime of the node. Repos that don't live on disk (e.g. in tests)
+// get a nil denylist, which blocks nothing.
+func Denylist(lc fx.Lifecycle, r repo.Repo) (*denylist.Denylist, error) {
+	path, ok := repoPath(r)
+	if !ok {
+		return nil, nil
+	}
+
+	dl, err := denylist.New(filepath.Join(path, DenylistFilename))
+	if err != nil {
+		return nil, err
+	}
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			return dl.Start()
+		},
+		OnStop: func(context.Context) error {
+			return dl.Close()
+		},
+	})
+	return dl, nil
+}
+
+// repoPath returns the directory of r, or false if it doesn't live on disk.
+func repoPath(r repo.Repo) (string, bool) {
+	fsRepo, ok := r.(interface{ Path() string })
+	if !ok || fsRepo.Path() == "" {
+		return "", false
+	}
+	return fsRepo.Path(), true
+}
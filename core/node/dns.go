@@ -79,3 +79,32 @@ func DNSResolver(cfg *config.Config) (*madns.Resolver, error) {
 
 	return madns.NewResolver(opts...)
 }
+
+// DNSResolverURL returns the URL of the DNS over HTTPS resolver DNSResolver
+// uses for domain, or "" if it uses the system resolver.
+func DNSResolverURL(cfg *config.Config, domain string) string {
+	urls := make(map[string]string)
+	for d, url := range defaultResolvers {
+		urls[d] = url
+	}
+	for d, url := range cfg.DNS.Resolvers {
+		if url == "" {
+			delete(urls, d)
+		} else {
+			urls[d] = url
+		}
+	}
+
+	// like madns, the most specific domain wins, then the default
+	fqdn := dns.Fqdn(domain)
+	for {
+		if url, ok := urls[fqdn]; ok {
+			return url
+		}
+		i := strings.Index(fqdn, ".")
+		if i == -1 || i == len(fqdn)-1 {
+			return urls["."]
+		}
+		fqdn = fqdn[i+1:]
+	}
+}
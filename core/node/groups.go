@@ -266,6 +266,7 @@ func Online(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 		fx.Provide(OnlineExchange(shouldBitswapProvide)),
 		maybeProvide(Graphsync, cfg.Experimental.GraphsyncEnabled),
 		fx.Provide(DNSResolver),
+		fx.Provide(NewIpnsRecords),
 		fx.Provide(Namesys(ipnsCacheSize)),
 		fx.Provide(Peering),
 		PeerWith(cfg.Peering.Peers...),
@@ -284,6 +285,7 @@ func Offline(cfg *config.Config) fx.Option {
 	return fx.Options(
 		fx.Provide(offline.Exchange),
 		fx.Provide(DNSResolver),
+		fx.Provide(NewIpnsRecords),
 		fx.Provide(Namesys(0)),
 		fx.Provide(offroute.NewOfflineRouter),
 		OfflineProviders(cfg.Experimental.StrategicProviding, cfg.Experimental.AcceleratedDHTClient, cfg.Reprovider.Strategy, cfg.Reprovider.Interval),
@@ -296,7 +298,10 @@ var Core = fx.Options(
 	fx.Provide(Dag),
 	fx.Provide(resolver.NewBasicResolver),
 	fx.Provide(Pinning),
+	fx.Provide(PinMeta),
+	fx.Provide(PinQueue),
 	fx.Provide(Files),
+	fx.Provide(Denylist),
 )
 
 func Networked(bcfg *BuildCfg, cfg *config.Config) fx.Option {
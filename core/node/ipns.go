@@ -1,12 +1,16 @@
 package node
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/ipfs/go-ipfs-util"
 	"github.com/ipfs/go-ipns"
+	ipns_pb "github.com/ipfs/go-ipns/pb"
 	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/peerstore"
 	"github.com/libp2p/go-libp2p-core/routing"
 	"github.com/libp2p/go-libp2p-record"
@@ -27,9 +31,100 @@ func RecordValidator(ps peerstore.Peerstore) record.Validator {
 	}
 }
 
+// maxIpnsRecords bounds how many names IpnsRecords remembers.
+const maxIpnsRecords = 1024
+
+// IpnsRecords remembers the last IPNS record of each name the name system got
+// from, or put to, the routing, so that what the name resolved to can be
+// cached for as long as the record allows without fetching it again.
+type IpnsRecords struct {
+	mu      sync.Mutex
+	records map[peer.ID]*ipns_pb.IpnsEntry
+}
+
+// NewIpnsRecords returns an empty IpnsRecords.
+func NewIpnsRecords() *IpnsRecords {
+	return &IpnsRecords{records: make(map[peer.ID]*ipns_pb.IpnsEntry)}
+}
+
+// Get returns the last record of the name of pid, or false if the name
+// system didn't resolve it since the node started.
+func (r *IpnsRecords) Get(pid peer.ID) (*ipns_pb.IpnsEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.records[pid]
+	return entry, ok
+}
+
+func (r *IpnsRecords) remember(key string, val []byte) {
+	ns, pid, err := record.SplitKey(key)
+	if err != nil || ns != "ipns" {
+		return
+	}
+	entry := new(ipns_pb.IpnsEntry)
+	if err := entry.Unmarshal(val); err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.records) >= maxIpnsRecords {
+		r.records = make(map[peer.ID]*ipns_pb.IpnsEntry)
+	}
+	r.records[peer.ID(pid)] = entry
+}
+
+// ValueStore returns vs, remembering the IPNS records read from, and written
+// to it.
+func (r *IpnsRecords) ValueStore(vs routing.ValueStore) routing.ValueStore {
+	return &ipnsRecordingValueStore{ValueStore: vs, records: r}
+}
+
+type ipnsRecordingValueStore struct {
+	routing.ValueStore
+	records *IpnsRecords
+}
+
+func (vs *ipnsRecordingValueStore) PutValue(ctx context.Context, key string, val []byte, opts ...routing.Option) error {
+	if err := vs.ValueStore.PutValue(ctx, key, val, opts...); err != nil {
+		return err
+	}
+	vs.records.remember(key, val)
+	return nil
+}
+
+func (vs *ipnsRecordingValueStore) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	val, err := vs.ValueStore.GetValue(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	vs.records.remember(key, val)
+	return val, nil
+}
+
+func (vs *ipnsRecordingValueStore) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	vals, err := vs.ValueStore.SearchValue(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// every value is better than the previous one
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		for val := range vals {
+			vs.records.remember(key, val)
+			select {
+			case out <- val:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
 // Namesys creates new name system
-func Namesys(cacheSize int) func(rt routing.Routing, rslv *madns.Resolver, repo repo.Repo) (namesys.NameSystem, error) {
-	return func(rt routing.Routing, rslv *madns.Resolver, repo repo.Repo) (namesys.NameSystem, error) {
+func Namesys(cacheSize int) func(rt routing.Routing, rslv *madns.Resolver, repo repo.Repo, records *IpnsRecords) (namesys.NameSystem, error) {
+	return func(rt routing.Routing, rslv *madns.Resolver, repo repo.Repo, records *IpnsRecords) (namesys.NameSystem, error) {
 		opts := []namesys.Option{
 			namesys.WithDatastore(repo.Datastore()),
 			namesys.WithDNSResolver(rslv),
@@ -39,7 +134,7 @@ func Namesys(cacheSize int) func(rt routing.Routing, rslv *madns.Resolver, repo
 			opts = append(opts, namesys.WithCache(cacheSize))
 		}
 
-		return namesys.NewNameSystem(rt, opts...)
+		return namesys.NewNameSystem(records.ValueStore(rt), opts...)
 	}
 }
 
@@ -0,0 +1,137 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipfs/go-ipfs-config"
+	"github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-ipfs-provider"
+	"github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"go.uber.org/fx"
+
+	"github.com/ipfs/go-ipfs/pinmeta"
+	"github.com/ipfs/go-ipfs/pinqueue"
+	"github.com/ipfs/go-ipfs/repo"
+)
+
+// pinQueueConfigKey is the section of the config with the limits of the pin
+// queue.
+const pinQueueConfigKey = "Pinning.Queue"
+
+// pinQueueConfig is the Pinning.Queue section of the config, see
+// docs/config.md. Unset values take the defaults of pinqueue.DefaultOptions.
+type pinQueueConfig struct {
+	Workers       int
+	Attempts      int
+	RetryDelay    *config.Duration
+	MaxRetryDelay *config.Duration
+	Retention     *config.Duration
+}
+
+// PinQueue loads the queue of the pins added in the background. Its workers
+// are only started by the daemon, see pinqueue.Queue.Start, so that
+// short-lived commands don't pick up the queued pins.
+func PinQueue(lc fx.Lifecycle, r repo.Repo, bs blockstore.GCBlockstore, dag format.DAGService, pinning pin.Pinner, meta *pinmeta.Store, prov provider.System) (*pinqueue.Queue, error) {
+	opts, err := pinQueueOptions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	pinFn := func(ctx context.Context, c cid.Cid, recursive bool, info *pinmeta.Info) error {
+		// fetch the blocks first, so that garbage collection isn't blocked
+		// for as long as it takes
+		nd, err := dag.Get(ctx, c)
+		if err != nil {
+			return err
+		}
+		if recursive {
+			if err := merkledag.FetchGraph(ctx, c, dag); err != nil {
+				return err
+			}
+		}
+
+		if err := pinLocked(ctx, bs, pinning, meta, nd, recursive, info); err != nil {
+			return err
+		}
+		return prov.Provide(c)
+	}
+
+	q, err := pinqueue.New(r.Datastore(), pinFn, opts)
+	if err != nil {
+		return nil, err
+	}
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			return q.Close()
+		},
+	})
+	return q, nil
+}
+
+// pinLocked pins nd and saves its record under the pin lock.
+func pinLocked(ctx context.Context, bs blockstore.GCBlockstore, pinning pin.Pinner, meta *pinmeta.Store, nd format.Node, recursive bool, info *pinmeta.Info) error {
+	defer bs.PinLock().Unlock()
+	// 'ipfs pin rm' cancels the job under the lock: a canceled job must not
+	// pin once it gets the lock
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := pinning.Pin(ctx, nd, recursive); err != nil {
+		return err
+	}
+	if err := pinning.Flush(ctx); err != nil {
+		return err
+	}
+	if info.Empty() {
+		return nil
+	}
+	return meta.Put(nd.Cid(), info)
+}
+
+// pinQueueOptions returns the limits of the pin queue, from the config or
+// the defaults.
+func pinQueueOptions(r repo.Repo) (pinqueue.Options, error) {
+	opts := pinqueue.DefaultOptions
+	var cfg pinQueueConfig
+	if err := ExtraConfig(r, pinQueueConfigKey, &cfg); err != nil {
+		return opts, err
+	}
+	for _, n := range []struct {
+		name string
+		v    int
+		out  *int
+	}{
+		{"Workers", cfg.Workers, &opts.Workers},
+		{"Attempts", cfg.Attempts, &opts.MaxAttempts},
+	} {
+		if n.v < 0 {
+			return opts, fmt.Errorf("invalid %s.%s %d: expected a positive number", pinQueueConfigKey, n.name, n.v)
+		}
+		if n.v > 0 {
+			*n.out = n.v
+		}
+	}
+	for _, d := range []struct {
+		name string
+		v    *config.Duration
+		out  *time.Duration
+	}{
+		{"RetryDelay", cfg.RetryDelay, &opts.RetryDelay},
+		{"MaxRetryDelay", cfg.MaxRetryDelay, &opts.MaxRetryDelay},
+		{"Retention", cfg.Retention, &opts.Retention},
+	} {
+		if d.v == nil {
+			continue
+		}
+		if *d.v < 0 {
+			return opts, fmt.Errorf("invalid %s.%s %s: expected a positive duration", pinQueueConfigKey, d.name, d.v)
+		}
+		*d.out = time.Duration(*d.v)
+	}
+	return opts, nil
+}
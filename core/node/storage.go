@@ -1,13 +1,18 @@
 package node
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/ipfs/go-datastore"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	config "github.com/ipfs/go-ipfs-config"
 	"go.uber.org/fx"
 
 	"github.com/ipfs/go-filestore"
+	"github.com/ipfs/go-ipfs/blocks/carstore"
 	"github.com/ipfs/go-ipfs/core/node/helpers"
+	"github.com/ipfs/go-ipfs/gc"
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/thirdparty/cidv0v1"
 	"github.com/ipfs/go-ipfs/thirdparty/verifbs"
@@ -26,9 +31,14 @@ func Datastore(repo repo.Repo) datastore.Datastore {
 // BaseBlocks is the lower level blockstore without GC or Filestore layers
 type BaseBlocks blockstore.Blockstore
 
+// CarMountsConfigKey is the config key of the list of the absolute paths of
+// the mounted CAR files, which go-ipfs-config doesn't declare.
+const CarMountsConfigKey = "Datastore.CarMounts"
+
 // BaseBlockstoreCtor creates cached blockstore backed by the provided datastore
-func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead bool) func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, err error) {
-	return func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, err error) {
+// and the CAR files mounted under it
+func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead bool) func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, cars *carstore.Store, err error) {
+	return func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, cars *carstore.Store, err error) {
 		// hash security
 		bs = blockstore.NewBlockstore(repo.Datastore())
 		bs = &verifbs.VerifBS{Blockstore: bs}
@@ -36,8 +46,29 @@ func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead
 		if !nilRepo {
 			bs, err = blockstore.CachedBlockstore(helpers.LifecycleCtx(mctx, lc), bs, cacheOpts)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
+			}
+		}
+
+		// above the cache, as its bloom filter only knows the blocks of the
+		// repo. Repos that don't live on disk have no mounts.
+		if _, ok := repoPath(repo); ok && !nilRepo {
+			var paths []string
+			if err := ExtraConfig(repo, CarMountsConfigKey, &paths); err != nil {
+				return nil, nil, err
+			}
+			cars, err = carstore.New(bs, paths, func(paths []string) error {
+				return repo.SetConfigKey(CarMountsConfigKey, paths)
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid config %s: %s", CarMountsConfigKey, err)
 			}
+			lc.Append(fx.Hook{
+				OnStop: func(context.Context) error {
+					return cars.Close()
+				},
+			})
+			bs = cars
 		}
 
 		bs = blockstore.NewIdStore(bs)
@@ -55,6 +86,8 @@ func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead
 func GcBlockstoreCtor(bb BaseBlocks) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore) {
 	gclocker = blockstore.NewGCLocker()
 	gcbs = blockstore.NewGCBlockstore(bb, gclocker)
+	// record the blocks accessed during incremental garbage collections
+	gcbs = gc.NewTrackingBlockstore(gcbs)
 
 	bs = gcbs
 	return
@@ -68,6 +101,7 @@ func FilestoreBlockstoreCtor(repo repo.Repo, bb BaseBlocks) (gclocker blockstore
 	fstore = filestore.NewFilestore(bb, repo.FileManager())
 	gcbs = blockstore.NewGCBlockstore(fstore, gclocker)
 	gcbs = &verifbs.VerifBSGC{GCBlockstore: gcbs}
+	gcbs = gc.NewTrackingBlockstore(gcbs)
 
 	bs = gcbs
 	return
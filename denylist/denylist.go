@@ -0,0 +1,358 @@
+// Package denylist implements a local list of content the node refuses to
+// resolve and serve.
+//
+// The list is read from a text file with one entry per line:
+//
+//	# comments and empty lines are ignored
+//	/ipfs/bafy...            blocks the CID wherever it appears
+//	/ipfs/bafy.../sub/dir    blocks the path (and everything under it)
+//	/ipns/example.com        blocks an IPNS key or DNSLink name
+//	bafy...                  shorthand for /ipfs/bafy...
+//
+// CIDs are matched by multihash, so CIDv0 and CIDv1 of the same content are
+// blocked by a single entry. The file is reloaded when it changes on disk.
+package denylist
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	fsnotify "github.com/fsnotify/fsnotify"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log"
+	ipfspath "github.com/ipfs/go-path"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+var log = logging.Logger("denylist")
+
+// ErrBlocked is returned (wrapped) for content that is on the denylist.
+var ErrBlocked = errors.New("blocked by the denylist")
+
+// rules is an immutable snapshot of a parsed denylist file.
+type rules struct {
+	cids     map[string]struct{}   // multihash -> blocked
+	subpaths map[string][][]string // multihash -> blocked path segments under it
+	names    map[string]struct{}   // normalized IPNS name -> blocked
+}
+
+// Denylist is a set of blocked CIDs, paths and names. A nil *Denylist blocks
+// nothing, so callers don't need to check whether one is configured.
+type Denylist struct {
+	filename string
+
+	mu    sync.RWMutex
+	rules *rules
+
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+// New returns a denylist backed by the given file. A missing file is treated
+// as an empty list, so that it can be created later while the node runs.
+func New(filename string) (*Denylist, error) {
+	d := &Denylist{
+		filename: filename,
+		rules:    emptyRules(),
+	}
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Start watches the denylist file and reloads it when it changes.
+func (d *Denylist) Start() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// Watch the directory rather than the file: editors usually replace the
+	// file on save, and the file may not exist yet.
+	if err := watcher.Add(filepath.Dir(d.filename)); err != nil {
+		watcher.Close()
+		return err
+	}
+	d.watcher = watcher
+	d.done = make(chan struct{})
+
+	go func() {
+		defer close(d.done)
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) != filepath.Clean(d.filename) {
+					continue
+				}
+				if err := d.Reload(); err != nil {
+					log.Errorf("failed to reload %s, keeping previous list: %s", d.filename, err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("denylist watcher: %s", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops watching the denylist file.
+func (d *Denylist) Close() error {
+	if d.watcher == nil {
+		return nil
+	}
+	err := d.watcher.Close()
+	<-d.done
+	return err
+}
+
+// Reload reads the denylist file again. On error, the previous list stays in
+// effect.
+func (d *Denylist) Reload() error {
+	f, err := os.Open(d.filename)
+	if os.IsNotExist(err) {
+		d.setRules(emptyRules())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := parse(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", d.filename, err)
+	}
+	d.setRules(r)
+	log.Infof("loaded %d entries from %s", r.len(), d.filename)
+	return nil
+}
+
+func (d *Denylist) setRules(r *rules) {
+	d.mu.Lock()
+	d.rules = r
+	d.mu.Unlock()
+}
+
+func (d *Denylist) getRules() *rules {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.rules
+}
+
+// CheckCid returns an error wrapping ErrBlocked if c is blocked.
+func (d *Denylist) CheckCid(c cid.Cid) error {
+	if d == nil || !c.Defined() {
+		return nil
+	}
+	if _, ok := d.getRules().cids[string(c.Hash())]; ok {
+		return fmt.Errorf("%s: %w", c, ErrBlocked)
+	}
+	return nil
+}
+
+// CheckPath returns an error wrapping ErrBlocked if p is an /ipns/ path with
+// a blocked name, or an /ipfs/ path with a blocked root CID or subpath.
+func (d *Denylist) CheckPath(p ipfspath.Path) error {
+	if d == nil {
+		return nil
+	}
+	segs := p.Segments()
+	if len(segs) < 2 {
+		return nil
+	}
+	r := d.getRules()
+
+	switch segs[0] {
+	case "ipns":
+		if _, ok := r.names[normalizeName(segs[1])]; ok {
+			return fmt.Errorf("%s: %w", p, ErrBlocked)
+		}
+	case "ipfs", "ipld":
+		root, err := cid.Decode(segs[1])
+		if err != nil {
+			return nil // not our problem, the resolver will complain
+		}
+		mh := string(root.Hash())
+		if _, ok := r.cids[mh]; ok {
+			return fmt.Errorf("%s: %w", p, ErrBlocked)
+		}
+		rest := trimEmpty(segs[2:])
+		for _, blocked := range r.subpaths[mh] {
+			if hasSegmentsPrefix(rest, blocked) {
+				return fmt.Errorf("%s: %w", p, ErrBlocked)
+			}
+		}
+	}
+	return nil
+}
+
+// NodeGetter wraps ng so that fetching a blocked node fails with ErrBlocked
+// instead of hitting the network.
+func (d *Denylist) NodeGetter(ng ipld.NodeGetter) ipld.NodeGetter {
+	if d == nil {
+		return ng
+	}
+	return &nodeGetter{NodeGetter: ng, d: d}
+}
+
+type nodeGetter struct {
+	ipld.NodeGetter
+	d *Denylist
+}
+
+func (ng *nodeGetter) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	if err := ng.d.CheckCid(c); err != nil {
+		return nil, err
+	}
+	return ng.NodeGetter.Get(ctx, c)
+}
+
+func (ng *nodeGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	for _, c := range cids {
+		if err := ng.d.CheckCid(c); err != nil {
+			out := make(chan *ipld.NodeOption, 1)
+			out <- &ipld.NodeOption{Err: err}
+			close(out)
+			return out
+		}
+	}
+	return ng.NodeGetter.GetMany(ctx, cids)
+}
+
+// DAGService wraps ds so that fetching a blocked node fails with ErrBlocked,
+// and so do the sessions made from it. Everything that walks a DAG through it,
+// such as CAR and archive exports, stops at the blocked nodes.
+func (d *Denylist) DAGService(ds ipld.DAGService) ipld.DAGService {
+	if d == nil {
+		return ds
+	}
+	return &dagService{DAGService: ds, ng: nodeGetter{NodeGetter: ds, d: d}}
+}
+
+type dagService struct {
+	ipld.DAGService
+	ng nodeGetter
+}
+
+func (ds *dagService) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	return ds.ng.Get(ctx, c)
+}
+
+func (ds *dagService) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	return ds.ng.GetMany(ctx, cids)
+}
+
+// Session implements merkledag.SessionMaker, so that the sessions of the
+// wrapped DAGService are kept.
+func (ds *dagService) Session(ctx context.Context) ipld.NodeGetter {
+	if sm, ok := ds.DAGService.(interface {
+		Session(context.Context) ipld.NodeGetter
+	}); ok {
+		return ds.ng.d.NodeGetter(sm.Session(ctx))
+	}
+	return &ds.ng
+}
+
+func emptyRules() *rules {
+	return &rules{
+		cids:     make(map[string]struct{}),
+		subpaths: make(map[string][][]string),
+		names:    make(map[string]struct{}),
+	}
+}
+
+func (r *rules) len() int {
+	n := len(r.cids) + len(r.names)
+	for _, sp := range r.subpaths {
+		n += len(sp)
+	}
+	return n
+}
+
+func parse(in io.Reader) (*rules, error) {
+	r := emptyRules()
+	s := bufio.NewScanner(in)
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, "/") {
+			line = "/ipfs/" + line
+		}
+
+		segs := trimEmpty(strings.Split(line, "/"))
+		if len(segs) < 2 {
+			return nil, fmt.Errorf("line %d: invalid entry %q", lineNo, line)
+		}
+		switch segs[0] {
+		case "ipns":
+			if len(segs) > 2 {
+				return nil, fmt.Errorf("line %d: subpaths are not supported for /ipns/ entries: %q", lineNo, line)
+			}
+			r.names[normalizeName(segs[1])] = struct{}{}
+		case "ipfs":
+			c, err := cid.Decode(segs[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid CID %q: %s", lineNo, segs[1], err)
+			}
+			mh := string(c.Hash())
+			if len(segs) == 2 {
+				r.cids[mh] = struct{}{}
+			} else {
+				r.subpaths[mh] = append(r.subpaths[mh], segs[2:])
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unsupported namespace in %q", lineNo, line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// normalizeName makes the different encodings of a libp2p key (base58
+// multihash, CIDv1) compare equal. DNSLink names are case insensitive.
+func normalizeName(name string) string {
+	if id, err := peer.Decode(name); err == nil {
+		return "/key/" + string(id)
+	}
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func trimEmpty(segs []string) []string {
+	out := make([]string, 0, len(segs))
+	for _, s := range segs {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func hasSegmentsPrefix(segs, prefix []string) bool {
+	if len(segs) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if segs[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,159 @@
+package denylist
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ipfspath "github.com/ipfs/go-path"
+)
+
+const (
+	blockedV0 = "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn"
+	blockedV1 = "bafybeiczsscdsbs7ffqz55asqdf3smv6klcw3gofszvwlyarci47bgf354"
+	otherCid  = "QmbWqxBEKC3P8tqsKc98xmWNzrzDtRLMiMPL8wBuTGsMnR"
+	peerIDB58 = "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe"
+)
+
+func TestParse(t *testing.T) {
+	r, err := parse(strings.NewReader(`
+# blocked content
+/ipfs/` + blockedV0 + `
+/ipfs/` + otherCid + `/bad/dir
+/ipns/Example.com
+/ipns/` + peerIDB58 + `
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.len() != 4 {
+		t.Fatalf("expected 4 entries, got %d", r.len())
+	}
+
+	for _, bad := range []string{
+		"/ipfs/notacid",
+		"/ipns/example.com/sub",
+		"/foo/bar",
+		"/ipfs",
+	} {
+		if _, err := parse(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "denylist")
+	err := ioutil.WriteFile(filename, []byte(strings.Join([]string{
+		blockedV0,
+		"/ipfs/" + otherCid + "/bad/dir",
+		"/ipns/example.com",
+		"/ipns/" + peerIDB58,
+	}, "\n")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := New(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path    string
+		blocked bool
+	}{
+		{"/ipfs/" + blockedV0, true},
+		{"/ipfs/" + blockedV1 + "/any/path", true},
+		{"/ipfs/" + otherCid, false},
+		{"/ipfs/" + otherCid + "/bad", false},
+		{"/ipfs/" + otherCid + "/bad/dir", true},
+		{"/ipfs/" + otherCid + "/bad/dir/file.txt", true},
+		{"/ipfs/" + otherCid + "/bad/dirt", false},
+		{"/ipns/example.com/index.html", true},
+		{"/ipns/EXAMPLE.com", true},
+		{"/ipns/example.org", false},
+		{"/ipns/" + peerIDB58, true},
+	} {
+		err := d.CheckPath(ipfspath.Path(test.path))
+		if blocked := errors.Is(err, ErrBlocked); blocked != test.blocked {
+			t.Errorf("%s: blocked is %t, expected %t (%v)", test.path, blocked, test.blocked, err)
+		}
+	}
+
+	v1, err := cid.Decode(blockedV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CheckCid(v1); !errors.Is(err, ErrBlocked) {
+		t.Errorf("expected CIDv1 to be blocked, got %v", err)
+	}
+	other, err := cid.Decode(otherCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CheckCid(other); err != nil {
+		t.Errorf("expected %s not to be blocked, got %v", otherCid, err)
+	}
+
+	var nilList *Denylist
+	if err := nilList.CheckPath(ipfspath.Path("/ipfs/" + blockedV0)); err != nil {
+		t.Errorf("nil denylist should not block anything, got %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "denylist")
+
+	// a missing file is an empty list
+	d, err := New(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	p := ipfspath.Path("/ipfs/" + blockedV0)
+	if err := d.CheckPath(p); err != nil {
+		t.Fatalf("expected nothing to be blocked, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte(blockedV0+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return d.CheckPath(p) != nil })
+
+	// a broken file keeps the previous list
+	if err := ioutil.WriteFile(filename, []byte("/ipfs/notacid\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := d.CheckPath(p); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("expected previous list to stay in effect, got %v", err)
+	}
+
+	if err := os.Remove(filename); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return d.CheckPath(p) == nil })
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for the denylist to reload")
+}
@@ -0,0 +1,210 @@
+package gc
+
+import (
+	"context"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-verifcid"
+)
+
+// DryRun reports the blocks that GC would remove, as KeyRemoved results,
+// without removing them. It doesn't take the GC lock, so that adds and pins
+// aren't blocked while it runs: the blocks of the adds in progress may be
+// reported as well.
+func DryRun(ctx context.Context, bs bstore.Blockstore, pn pin.Pinner, bestEffortRoots []cid.Cid) <-chan Result {
+	ctx, cancel := context.WithCancel(ctx)
+
+	bs = Untracked(bs)
+
+	bsrv := bserv.New(bs, offline.Exchange(bs))
+	ds := dag.NewDAGService(bsrv)
+
+	output := make(chan Result, 128)
+
+	go func() {
+		defer cancel()
+		defer close(output)
+
+		gcs, err := ColoredSet(ctx, pn, ds, bestEffortRoots, output)
+		if err != nil {
+			select {
+			case output <- Result{Error: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+		keychan, err := bs.AllKeysChan(ctx)
+		if err != nil {
+			select {
+			case output <- Result{Error: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+	loop:
+		for ctx.Err() == nil {
+			select {
+			case k, ok := <-keychan:
+				if !ok {
+					break loop
+				}
+				if gcs.Has(k) {
+					continue
+				}
+				select {
+				case output <- Result{KeyRemoved: k}:
+				case <-ctx.Done():
+					break loop
+				}
+			case <-ctx.Done():
+				break loop
+			}
+		}
+	}()
+
+	return output
+}
+
+// Untracked returns the blockstore wrapped by bs if it is a
+// TrackingBlockstore, so that reading every block doesn't count as accesses
+// to them, nor keep them in an incremental collection that runs meanwhile.
+func Untracked(bs bstore.Blockstore) bstore.Blockstore {
+	if tbs, ok := bs.(*TrackingBlockstore); ok {
+		return tbs.GCBlockstore
+	}
+	return bs
+}
+
+// RootKind is the kind of a root of the marked set of a garbage collection.
+type RootKind string
+
+// The kinds of roots, see ColoredSet.
+const (
+	RecursivePin   RootKind = "recursive"
+	DirectPin      RootKind = "direct"
+	InternalPin    RootKind = "internal"
+	BestEffortRoot RootKind = "best-effort"
+)
+
+// Retention is a reason why garbage collections keep a block: a root of the
+// marked set reaches it.
+type Retention struct {
+	Kind RootKind
+	// Path goes from the root to the block, both included.
+	Path []cid.Cid
+}
+
+// Root returns the root which reaches the block.
+func (r Retention) Root() cid.Cid {
+	return r.Path[0]
+}
+
+// Explain finds why garbage collections keep the given blocks: all the roots
+// of the marked set which reach them, with the shortest path from each. The
+// blocks without retentions would be removed. As with DryRun, the GC lock
+// isn't taken.
+//
+// Each root is walked on its own, so this takes longer than ColoredSet.
+func Explain(ctx context.Context, bs bstore.Blockstore, pn pin.Pinner, bestEffortRoots []cid.Cid, keys []cid.Cid) (map[cid.Cid][]Retention, error) {
+	bs = Untracked(bs)
+	ng := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+
+	targets := cid.NewSet()
+	for _, k := range keys {
+		targets.Add(k)
+	}
+	out := make(map[cid.Cid][]Retention, targets.Len())
+
+	walk := func(kind RootKind, roots []cid.Cid) error {
+		for _, root := range roots {
+			paths, err := findPaths(ctx, ng, root, targets, kind == BestEffortRoot)
+			if err != nil {
+				return err
+			}
+			for k, p := range paths {
+				out[k] = append(out[k], Retention{Kind: kind, Path: p})
+			}
+		}
+		return nil
+	}
+
+	rkeys, err := pn.RecursiveKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := walk(RecursivePin, rkeys); err != nil {
+		return nil, err
+	}
+
+	dkeys, err := pn.DirectKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range dkeys {
+		if targets.Has(k) {
+			out[k] = append(out[k], Retention{Kind: DirectPin, Path: []cid.Cid{k}})
+		}
+	}
+
+	if err := walk(BestEffortRoot, bestEffortRoots); err != nil {
+		return nil, err
+	}
+
+	ikeys, err := pn.InternalPins(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := walk(InternalPin, ikeys); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// findPaths walks the DAG from root breadth-first, and returns the shortest
+// path to each of the targets it reaches. As with ColoredSet, the blocks
+// missing under a best effort root are skipped.
+func findPaths(ctx context.Context, ng ipld.NodeGetter, root cid.Cid, targets *cid.Set, bestEffort bool) (map[cid.Cid][]cid.Cid, error) {
+	parents := map[cid.Cid]cid.Cid{root: cid.Undef}
+	found := make(map[cid.Cid][]cid.Cid)
+
+	queue := []cid.Cid{root}
+	for len(queue) > 0 && len(found) < targets.Len() {
+		c := queue[0]
+		queue = queue[1:]
+
+		if targets.Has(c) {
+			var path []cid.Cid
+			for p := c; p.Defined(); p = parents[p] {
+				path = append([]cid.Cid{p}, path...)
+			}
+			found[c] = path
+		}
+
+		if err := verifcid.ValidateCid(c); err != nil {
+			return nil, err
+		}
+		links, err := ipld.GetLinks(ctx, ng, c)
+		if err != nil {
+			if bestEffort && err == ipld.ErrNotFound {
+				continue
+			}
+			return nil, &CannotFetchLinksError{c, err}
+		}
+		for _, l := range links {
+			if _, ok := parents[l.Cid]; ok {
+				continue
+			}
+			parents[l.Cid] = c
+			queue = append(queue, l.Cid)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,159 @@
+package gc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+type testRepo struct {
+	bs      bstore.GCBlockstore
+	dserv   ipld.DAGService
+	pinning pin.Pinner
+}
+
+func newTestRepo(t *testing.T, ctx context.Context) *testRepo {
+	t.Helper()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	bs := bstore.NewGCBlockstore(bstore.NewBlockstore(ds), bstore.NewGCLocker())
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	pinning, err := dspinner.New(ctx, ds, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testRepo{bs: bs, dserv: dserv, pinning: pinning}
+}
+
+func (r *testRepo) pin(t *testing.T, ctx context.Context, nd ipld.Node, recursive bool) {
+	t.Helper()
+	if err := r.pinning.Pin(ctx, nd, recursive); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.pinning.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := newTestRepo(t, ctx)
+
+	pinned, _ := addTree(t, ctx, r.dserv, "pinned")
+	r.pin(t, ctx, pinned, true)
+	root, _ := addTree(t, ctx, r.dserv, "root")
+	garbage, garbageChild := addTree(t, ctx, r.dserv, "garbage")
+
+	reported := cid.NewSet()
+	for res := range DryRun(ctx, r.bs, r.pinning, []cid.Cid{root.Cid()}) {
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		reported.Add(res.KeyRemoved)
+	}
+	if reported.Len() != 2 || !reported.Has(garbage.Cid()) || !reported.Has(garbageChild.Cid()) {
+		t.Fatalf("expected the unpinned tree to be reported, got %v", reported.Keys())
+	}
+
+	// nothing is removed
+	for _, c := range reported.Keys() {
+		has, err := r.bs.Has(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Fatalf("expected %s to be kept", c)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := newTestRepo(t, ctx)
+
+	shared := dag.NewRawNode([]byte("shared"))
+	pinned := dag.NodeWithData([]byte("pinned"))
+	if err := pinned.AddNodeLink("shared", shared); err != nil {
+		t.Fatal(err)
+	}
+	root := dag.NodeWithData([]byte("root"))
+	if err := root.AddNodeLink("pinned", pinned); err != nil {
+		t.Fatal(err)
+	}
+	missing := dag.NewRawNode([]byte("missing"))
+	if err := root.AddNodeLink("missing", missing); err != nil {
+		t.Fatal(err)
+	}
+	direct := dag.NewRawNode([]byte("direct"))
+	garbage := dag.NewRawNode([]byte("garbage"))
+	if err := r.dserv.AddMany(ctx, []ipld.Node{shared, pinned, root, direct, garbage}); err != nil {
+		t.Fatal(err)
+	}
+	r.pin(t, ctx, pinned, true)
+	r.pin(t, ctx, direct, false)
+
+	keys := []cid.Cid{shared.Cid(), direct.Cid(), garbage.Cid()}
+	out, err := Explain(ctx, r.bs, r.pinning, []cid.Cid{root.Cid()}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[cid.Cid][]Retention{
+		shared.Cid(): {
+			{Kind: RecursivePin, Path: []cid.Cid{pinned.Cid(), shared.Cid()}},
+			{Kind: BestEffortRoot, Path: []cid.Cid{root.Cid(), pinned.Cid(), shared.Cid()}},
+		},
+		direct.Cid(): {
+			{Kind: DirectPin, Path: []cid.Cid{direct.Cid()}},
+		},
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Fatalf("expected %v, got %v", expected, out)
+	}
+}
+
+func TestDryRunIsNotAnAccess(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := newTestRepo(t, ctx)
+	bs := NewTrackingBlockstore(r.bs)
+
+	pinned, _ := addTree(t, ctx, r.dserv, "pinned")
+	r.pin(t, ctx, pinned, true)
+	garbage, _ := addTree(t, ctx, r.dserv, "garbage")
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	at := NewAccessTimes(ds)
+	bs.TrackAccessTimes(at)
+
+	for res := range DryRun(ctx, bs, r.pinning, nil) {
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+	}
+	if _, err := Explain(ctx, bs, r.pinning, nil, []cid.Cid{garbage.Cid()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := at.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	atimes, err := at.all()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(atimes) != 0 {
+		t.Fatalf("expected the blocks read by a dry run not to be accessed, got %v", atimes)
+	}
+}
@@ -0,0 +1,353 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	blocks "github.com/ipfs/go-block-format"
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	dstore "github.com/ipfs/go-datastore"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-verifcid"
+)
+
+// SweepBatchSize is how many blocks an incremental garbage collection
+// deletes each time it takes the GC lock.
+const SweepBatchSize = 256
+
+// TrackingBlockstore is a GCBlockstore that records the blocks written and
+// read while an incremental garbage collection runs, so that the collection
+// keeps them and everything they link to, see IncrementalGC.
+type TrackingBlockstore struct {
+	bstore.GCBlockstore
+
+	// running serializes the incremental collections.
+	running sync.Mutex
+
+	tracking int32
+	mu       sync.Mutex
+	touched  *cid.Set
+
+	atimes atomic.Value // *AccessTimes
+}
+
+// NewTrackingBlockstore wraps bs to support incremental garbage collections.
+func NewTrackingBlockstore(bs bstore.GCBlockstore) *TrackingBlockstore {
+	return &TrackingBlockstore{GCBlockstore: bs}
+}
+
+func (bs *TrackingBlockstore) track(c cid.Cid) {
+	if atomic.LoadInt32(&bs.tracking) == 0 {
+		return
+	}
+	bs.mu.Lock()
+	if bs.touched != nil {
+		bs.touched.Add(c)
+	}
+	bs.mu.Unlock()
+}
+
+// TrackAccessTimes starts recording in at when the blocks are read or
+// written, for EvictLRU.
+func (bs *TrackingBlockstore) TrackAccessTimes(at *AccessTimes) {
+	bs.atimes.Store(at)
+}
+
+func (bs *TrackingBlockstore) accessTimes() *AccessTimes {
+	at, _ := bs.atimes.Load().(*AccessTimes)
+	return at
+}
+
+// access records that c is read or written.
+func (bs *TrackingBlockstore) access(c cid.Cid) {
+	bs.track(c)
+	if at := bs.accessTimes(); at != nil {
+		at.touch(c)
+	}
+}
+
+func (bs *TrackingBlockstore) setTracking(on bool) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if on {
+		atomic.StoreInt32(&bs.tracking, 1)
+		bs.touched = cid.NewSet()
+	} else {
+		atomic.StoreInt32(&bs.tracking, 0)
+		bs.touched = nil
+	}
+}
+
+// drain returns the blocks touched since the last call.
+func (bs *TrackingBlockstore) drain() []cid.Cid {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if bs.touched == nil || bs.touched.Len() == 0 {
+		return nil
+	}
+	touched := bs.touched.Keys()
+	bs.touched = cid.NewSet()
+	return touched
+}
+
+func (bs *TrackingBlockstore) Put(b blocks.Block) error {
+	bs.access(b.Cid())
+	return bs.GCBlockstore.Put(b)
+}
+
+func (bs *TrackingBlockstore) PutMany(bls []blocks.Block) error {
+	for _, b := range bls {
+		bs.access(b.Cid())
+	}
+	return bs.GCBlockstore.PutMany(bls)
+}
+
+func (bs *TrackingBlockstore) Get(c cid.Cid) (blocks.Block, error) {
+	bs.access(c)
+	return bs.GCBlockstore.Get(c)
+}
+
+func (bs *TrackingBlockstore) Has(c cid.Cid) (bool, error) {
+	bs.track(c)
+	return bs.GCBlockstore.Has(c)
+}
+
+func (bs *TrackingBlockstore) GetSize(c cid.Cid) (int, error) {
+	bs.track(c)
+	return bs.GCBlockstore.GetSize(c)
+}
+
+func (bs *TrackingBlockstore) DeleteBlock(c cid.Cid) error {
+	if at := bs.accessTimes(); at != nil {
+		at.remove(c)
+	}
+	return bs.GCBlockstore.DeleteBlock(c)
+}
+
+// IncrementalGC is a garbage collection like GC, which only holds the GC
+// lock of bs for short periods, so that adds and pins can proceed while it
+// runs:
+//
+//   - the lock is taken to wait for the adds and pins in progress, and to
+//     start recording the blocks written and read through bs from then on
+//   - the marked set is computed without the lock, as with GC, from the
+//     pins and the best effort roots, which are only read then
+//   - the blocks that are not marked are deleted in batches of
+//     SweepBatchSize, taking the lock for each. Before each batch, the
+//     blocks recorded so far, and all their descendants, are marked as
+//     well: first without the lock, and then with it for the few that were
+//     recorded in between.
+//
+// Any object written, read or pinned during the collection is kept, until
+// the next one.
+//
+// Only the sweep is incremental: like GC, each collection computes the
+// marked set from scratch, walking every pin. Keeping the marked set between
+// collections, so that only the pins added since the last one are walked, is
+// left for later: the set would stay in memory between the collections, and
+// would have to be computed again after any unpin.
+func IncrementalGC(ctx context.Context, bs *TrackingBlockstore, dstor dstore.Datastore, pn pin.Pinner, bestEffortRoots func() ([]cid.Cid, error)) <-chan Result {
+	return incrementalGC(ctx, bs, dstor, pn, bestEffortRoots, func(s *sweeper) error {
+		keychan, err := bs.GCBlockstore.AllKeysChan(s.ctx)
+		if err != nil {
+			return err
+		}
+
+		batch := make([]cid.Cid, 0, SweepBatchSize)
+		for k := range keychan {
+			if s.gcs.Has(k) {
+				continue
+			}
+			batch = append(batch, k)
+			if len(batch) < SweepBatchSize {
+				continue
+			}
+			if err := s.sweep(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+		return s.sweep(batch)
+	})
+}
+
+// incrementalGC computes the marked set like IncrementalGC, and then has
+// sweepAll pick the blocks to delete with the sweeper.
+func incrementalGC(ctx context.Context, bs *TrackingBlockstore, dstor dstore.Datastore, pn pin.Pinner, bestEffortRoots func() ([]cid.Cid, error), sweepAll func(*sweeper) error) <-chan Result {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// the collection reads through the wrapped blockstore, so that its own
+	// reads aren't recorded
+	bsrv := bserv.New(bs.GCBlockstore, offline.Exchange(bs.GCBlockstore))
+	ds := dag.NewDAGService(bsrv)
+
+	output := make(chan Result, 128)
+
+	go func() {
+		defer cancel()
+		defer close(output)
+
+		emitErr := func(err error) {
+			select {
+			case output <- Result{Error: err}:
+			case <-ctx.Done():
+			}
+		}
+
+		bs.running.Lock()
+		defer bs.running.Unlock()
+
+		unlocker := bs.GCLock()
+		bs.setTracking(true)
+		unlocker.Unlock()
+		defer bs.setTracking(false)
+
+		roots, err := bestEffortRoots()
+		if err != nil {
+			emitErr(err)
+			return
+		}
+		gcs, err := ColoredSet(ctx, pn, ds, roots, output)
+		if err != nil {
+			emitErr(err)
+			return
+		}
+
+		s := &sweeper{
+			ctx:    ctx,
+			bs:     bs,
+			ng:     ds,
+			gcs:    gcs,
+			output: output,
+		}
+		if err := sweepAll(s); err != nil {
+			if ctx.Err() == nil {
+				emitErr(err)
+			}
+			return
+		}
+		if s.errors {
+			emitErr(ErrCannotDeleteSomeBlocks)
+		}
+
+		gds, ok := dstor.(dstore.GCDatastore)
+		if !ok {
+			return
+		}
+		if err := gds.CollectGarbage(); err != nil {
+			emitErr(err)
+		}
+	}()
+
+	return output
+}
+
+type sweeper struct {
+	ctx    context.Context
+	bs     *TrackingBlockstore
+	ng     ipld.NodeGetter
+	gcs    *cid.Set
+	output chan<- Result
+
+	// sizes of the blocks, to count the bytes freed, if set
+	sizes map[cid.Cid]int
+	freed uint64
+
+	errors bool
+}
+
+// remark marks the blocks touched since the last call, and their
+// descendants.
+func (s *sweeper) remark() error {
+	touched := s.bs.drain()
+	if len(touched) == 0 {
+		return nil
+	}
+
+	// blocks with insecure hashes can't be linked to, and would fail the
+	// walk
+	roots := touched[:0]
+	for _, c := range touched {
+		if verifcid.ValidateCid(c) == nil {
+			roots = append(roots, c)
+		}
+	}
+
+	var failed int32
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		links, err := ipld.GetLinks(ctx, s.ng, c)
+		if err != nil && err != ipld.ErrNotFound {
+			atomic.StoreInt32(&failed, 1)
+			select {
+			case s.output <- Result{Error: &CannotFetchLinksError{c, err}}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		return links, nil
+	}
+	if err := Descendants(s.ctx, getLinks, s.gcs, roots); err != nil {
+		return err
+	}
+	if atomic.LoadInt32(&failed) != 0 {
+		return ErrCannotFetchAllLinks
+	}
+	return nil
+}
+
+// sweep deletes the blocks of batch that are still not marked once the
+// blocks touched in the meantime are.
+func (s *sweeper) sweep(batch []cid.Cid) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := s.remark(); err != nil {
+		return err
+	}
+
+	unlocker := s.bs.GCLock()
+	if err := s.remark(); err != nil {
+		unlocker.Unlock()
+		return err
+	}
+	results := make([]Result, 0, len(batch))
+	for _, k := range batch {
+		if s.gcs.Has(k) {
+			continue
+		}
+		if err := s.bs.GCBlockstore.DeleteBlock(k); err != nil {
+			s.errors = true
+			results = append(results, Result{Error: &CannotDeleteBlockError{k, err}})
+			continue
+		}
+		if at := s.bs.accessTimes(); at != nil {
+			at.remove(k)
+		}
+		s.freed += uint64(s.sizes[k])
+		results = append(results, Result{KeyRemoved: k})
+	}
+	unlocker.Unlock()
+
+	for _, r := range results {
+		select {
+		case s.output <- r:
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+	}
+	return nil
+}
+
+// ErrIncrementalGCUnsupported is returned when the blockstore of a node
+// doesn't support incremental garbage collections.
+var ErrIncrementalGCUnsupported = errors.New("incremental garbage collection is not supported by this blockstore")
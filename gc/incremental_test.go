@@ -0,0 +1,141 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+// addTree adds a node linking to a raw node, and returns both.
+func addTree(t *testing.T, ctx context.Context, dserv ipld.DAGService, name string) (*dag.ProtoNode, ipld.Node) {
+	t.Helper()
+	child := dag.NewRawNode([]byte(name + " child"))
+	parent := dag.NodeWithData([]byte(name))
+	if err := parent.AddNodeLink("child", child); err != nil {
+		t.Fatal(err)
+	}
+	if err := dserv.AddMany(ctx, []ipld.Node{child, parent}); err != nil {
+		t.Fatal(err)
+	}
+	return parent, child
+}
+
+func TestIncrementalGC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	bs := NewTrackingBlockstore(bstore.NewGCBlockstore(bstore.NewBlockstore(ds), bstore.NewGCLocker()))
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	pinning, err := dspinner.New(ctx, ds, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pinned, pinnedChild := addTree(t, ctx, dserv, "pinned")
+	if err := pinning.Pin(ctx, pinned, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := pinning.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	read, readChild := addTree(t, ctx, dserv, "read")
+	root, rootChild := addTree(t, ctx, dserv, "root")
+
+	// enough garbage for a few batches
+	garbage := cid.NewSet()
+	for i := 0; i < 2*SweepBatchSize+10; i++ {
+		nd := dag.NewRawNode([]byte(fmt.Sprintf("garbage %d", i)))
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		garbage.Add(nd.Cid())
+	}
+
+	added := dag.NewRawNode([]byte("added"))
+	roots := func() ([]cid.Cid, error) {
+		// adds and pins aren't blocked while marking
+		unlocker := bs.PinLock()
+		defer unlocker.Unlock()
+
+		if _, err := dserv.Get(ctx, read.Cid()); err != nil {
+			return nil, err
+		}
+		if err := dserv.Add(ctx, added); err != nil {
+			return nil, err
+		}
+		return []cid.Cid{root.Cid()}, nil
+	}
+
+	removed := cid.NewSet()
+	for res := range IncrementalGC(ctx, bs, ds, pinning, roots) {
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		removed.Add(res.KeyRemoved)
+	}
+	if removed.Len() != garbage.Len() {
+		t.Fatalf("expected %d blocks to be removed, got %d", garbage.Len(), removed.Len())
+	}
+	if err := garbage.ForEach(func(c cid.Cid) error {
+		if !removed.Has(c) {
+			return fmt.Errorf("expected %s to be removed", c)
+		}
+		has, err := bs.Has(c)
+		if err != nil {
+			return err
+		}
+		if has {
+			return fmt.Errorf("expected %s to be deleted", c)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pins, the best effort roots and the blocks touched while
+	// collecting are kept, with what they link to
+	kept := []cid.Cid{
+		pinned.Cid(), pinnedChild.Cid(),
+		root.Cid(), rootChild.Cid(),
+		read.Cid(), readChild.Cid(),
+		added.Cid(),
+	}
+	for _, c := range kept {
+		has, err := bs.Has(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Fatalf("expected %s to be kept", c)
+		}
+	}
+
+	// until the next collection
+	removed = cid.NewSet()
+	noRoots := func() ([]cid.Cid, error) { return nil, nil }
+	for res := range IncrementalGC(ctx, bs, ds, pinning, noRoots) {
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		removed.Add(res.KeyRemoved)
+	}
+	for _, c := range kept[2:] {
+		if !removed.Has(c) {
+			t.Fatalf("expected %s to be removed", c)
+		}
+	}
+	if removed.Len() != len(kept[2:]) {
+		t.Fatalf("expected %d blocks to be removed, got %d", len(kept[2:]), removed.Len())
+	}
+}
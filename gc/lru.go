@@ -0,0 +1,278 @@
+package gc
+
+import (
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	dstore "github.com/ipfs/go-datastore"
+	namespace "github.com/ipfs/go-datastore/namespace"
+	query "github.com/ipfs/go-datastore/query"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	pin "github.com/ipfs/go-ipfs-pinner"
+)
+
+// AccessTimesPrefix is the datastore key under which the access times of
+// the blocks are stored.
+var AccessTimesPrefix = dstore.NewKey("/local/blockatime")
+
+// maxDirtyAccessTimes is how many access times are kept in memory before
+// they are written to the datastore.
+const maxDirtyAccessTimes = 100000
+
+// AccessTimes records when the blocks were last read or written, for
+// EvictLRU. The times are kept in memory, and written to the datastore in
+// batches, see Flush.
+type AccessTimes struct {
+	ds  dstore.Batching
+	now func() time.Time
+
+	// flushMu serializes the flushes, so that older times never overwrite
+	// newer ones.
+	flushMu sync.Mutex
+
+	mu       sync.Mutex
+	dirty    map[cid.Cid]int64 // unix nanoseconds, or 0 once deleted
+	flushing bool
+}
+
+// NewAccessTimes returns the access times stored in ds.
+func NewAccessTimes(ds dstore.Batching) *AccessTimes {
+	return &AccessTimes{
+		ds:    namespace.Wrap(ds, AccessTimesPrefix),
+		now:   time.Now,
+		dirty: make(map[cid.Cid]int64),
+	}
+}
+
+func (at *AccessTimes) touch(c cid.Cid) {
+	at.set(c, at.now().UnixNano())
+}
+
+func (at *AccessTimes) remove(c cid.Cid) {
+	at.set(c, 0)
+}
+
+func (at *AccessTimes) set(c cid.Cid, t int64) {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	at.dirty[c] = t
+	if len(at.dirty) < maxDirtyAccessTimes || at.flushing {
+		return
+	}
+	at.flushing = true
+	go func() {
+		if err := at.Flush(); err != nil {
+			log.Errorf("failed to write the access times of the blocks: %s", err)
+		}
+		at.mu.Lock()
+		at.flushing = false
+		at.mu.Unlock()
+	}()
+}
+
+// Flush writes the access times recorded since the last flush to the
+// datastore.
+func (at *AccessTimes) Flush() error {
+	at.flushMu.Lock()
+	defer at.flushMu.Unlock()
+
+	at.mu.Lock()
+	dirty := at.dirty
+	at.dirty = make(map[cid.Cid]int64)
+	at.mu.Unlock()
+	if len(dirty) == 0 {
+		return nil
+	}
+
+	b, err := at.ds.Batch()
+	if err != nil {
+		return err
+	}
+	for c, t := range dirty {
+		k := dstore.NewKey(c.String())
+		if t == 0 {
+			err = b.Delete(k)
+		} else {
+			buf := make([]byte, binary.MaxVarintLen64)
+			err = b.Put(k, buf[:binary.PutVarint(buf, t)])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return b.Commit()
+}
+
+// all returns the access times written to the datastore.
+func (at *AccessTimes) all() (map[cid.Cid]int64, error) {
+	results, err := at.ds.Query(query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	all := make(map[cid.Cid]int64)
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		c, err := cid.Decode(dstore.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			log.Errorf("skipping access time %s: %s", r.Key, err)
+			continue
+		}
+		t, n := binary.Varint(r.Value)
+		if n <= 0 {
+			log.Errorf("skipping access time of %s: invalid value", c)
+			continue
+		}
+		all[c] = t
+	}
+	return all, nil
+}
+
+// ErrAccessTimesNotTracked is returned by EvictLRU when the access times of
+// the blocks aren't tracked, see TrackingBlockstore.TrackAccessTimes.
+var ErrAccessTimesNotTracked = errors.New("the access times of the blocks are not tracked")
+
+// get returns the access time of c written to the datastore, or 0 if there
+// is none.
+func (at *AccessTimes) get(c cid.Cid) (int64, error) {
+	v, err := at.ds.Get(dstore.NewKey(c.String()))
+	if err == dstore.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	t, n := binary.Varint(v)
+	if n <= 0 {
+		log.Errorf("skipping access time of %s: invalid value", c)
+		return 0, nil
+	}
+	return t, nil
+}
+
+// EvictLRU is an IncrementalGC which removes the blocks that aren't marked in
+// least recently used order, until free bytes are freed. The blocks accessed
+// before their access times were tracked go first.
+//
+// The blocks are streamed from the blockstore, and only the least recently
+// used ones that free enough are kept in memory: a few more passes over the
+// blockstore are made if some of them are used again in the meantime.
+func EvictLRU(ctx context.Context, bs *TrackingBlockstore, dstor dstore.Datastore, pn pin.Pinner, bestEffortRoots func() ([]cid.Cid, error), free uint64) <-chan Result {
+	return incrementalGC(ctx, bs, dstor, pn, bestEffortRoots, func(s *sweeper) error {
+		at := bs.accessTimes()
+		if at == nil {
+			return ErrAccessTimesNotTracked
+		}
+		if err := at.Flush(); err != nil {
+			return err
+		}
+
+		s.sizes = make(map[cid.Cid]int)
+		batch := make([]cid.Cid, 0, SweepBatchSize)
+		for s.freed < free {
+			candidates, err := lruCandidates(s, at, free-s.freed)
+			if err != nil {
+				return err
+			}
+			if len(candidates) == 0 {
+				return nil
+			}
+
+			before := s.freed
+			for len(candidates) > 0 && s.freed < free {
+				// stop at the blocks that should free enough, unless some
+				// are kept in the meantime
+				batch = batch[:0]
+				planned := s.freed
+				for len(candidates) > 0 && len(batch) < SweepBatchSize && planned < free {
+					batch = append(batch, candidates[0].key)
+					planned += uint64(candidates[0].size)
+					candidates = candidates[1:]
+				}
+				if err := s.sweep(batch); err != nil {
+					return err
+				}
+			}
+			if s.freed == before {
+				// everything left is used again
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+type lruCandidate struct {
+	key   cid.Cid
+	atime int64
+	size  int
+}
+
+// lruHeap is a max-heap of candidates on their access times.
+type lruHeap []lruCandidate
+
+func (h lruHeap) Len() int            { return len(h) }
+func (h lruHeap) Less(i, j int) bool  { return h[i].atime > h[j].atime }
+func (h lruHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *lruHeap) Push(x interface{}) { *h = append(*h, x.(lruCandidate)) }
+func (h *lruHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// lruCandidates returns the least recently used blocks which aren't marked,
+// oldest first, as few as are needed to free the given number of bytes.
+func lruCandidates(s *sweeper, at *AccessTimes, free uint64) ([]lruCandidate, error) {
+	keychan, err := s.bs.GCBlockstore.AllKeysChan(s.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var h lruHeap
+	var size uint64
+	for k := range keychan {
+		if s.gcs.Has(k) {
+			continue
+		}
+		blockSize, err := s.bs.GCBlockstore.GetSize(k)
+		if err == bstore.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		atime, err := at.get(k)
+		if err != nil {
+			return nil, err
+		}
+		heap.Push(&h, lruCandidate{k, atime, blockSize})
+		size += uint64(blockSize)
+		// drop the most recently used blocks while the others free enough
+		for len(h) > 1 && size-uint64(h[0].size) >= free {
+			size -= uint64(heap.Pop(&h).(lruCandidate).size)
+		}
+	}
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	candidates := []lruCandidate(h)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].atime < candidates[j].atime
+	})
+	for _, c := range candidates {
+		s.sizes[c.key] = c.size
+	}
+	return candidates, nil
+}
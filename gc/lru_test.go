@@ -0,0 +1,132 @@
+package gc
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func TestEvictLRU(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	bs := NewTrackingBlockstore(bstore.NewGCBlockstore(bstore.NewBlockstore(ds), bstore.NewGCLocker()))
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	pinning, err := dspinner.New(ctx, ds, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// blocks of 100 bytes
+	newBlock := func(b byte) ipld.Node {
+		nd := dag.NewRawNode(bytes.Repeat([]byte{b}, 100))
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		return nd
+	}
+	untracked := newBlock('u')
+
+	at := NewAccessTimes(ds)
+	var clock int64
+	at.now = func() time.Time {
+		clock++
+		return time.Unix(0, clock)
+	}
+	bs.TrackAccessTimes(at)
+
+	pinned, pinnedChild := addTree(t, ctx, dserv, "pinned")
+	if err := pinning.Pin(ctx, pinned, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := pinning.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var unpinned []ipld.Node
+	for b := byte('0'); b < '5'; b++ {
+		unpinned = append(unpinned, newBlock(b))
+	}
+	// the second block is now the most recently used
+	if _, err := dserv.Get(ctx, unpinned[1].Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	noRoots := func() ([]cid.Cid, error) { return nil, nil }
+	removed := cid.NewSet()
+	for res := range EvictLRU(ctx, bs, ds, pinning, noRoots, 250) {
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		removed.Add(res.KeyRemoved)
+	}
+
+	expected := []cid.Cid{untracked.Cid(), unpinned[0].Cid(), unpinned[2].Cid()}
+	if removed.Len() != len(expected) {
+		t.Fatalf("expected %d blocks to be removed, got %v", len(expected), removed.Keys())
+	}
+	for _, c := range expected {
+		if !removed.Has(c) {
+			t.Fatalf("expected %s to be removed, got %v", c, removed.Keys())
+		}
+	}
+	for _, c := range []cid.Cid{pinned.Cid(), pinnedChild.Cid(), unpinned[1].Cid(), unpinned[3].Cid(), unpinned[4].Cid()} {
+		has, err := bs.GCBlockstore.Has(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			t.Fatalf("expected %s to be kept", c)
+		}
+	}
+
+	// the access times are kept in the datastore, without the removed
+	// blocks
+	if err := at.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	atimes, err := NewAccessTimes(ds).all()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := atimes[unpinned[0].Cid()]; ok {
+		t.Fatal("expected the access time of a removed block to be deleted")
+	}
+	if atimes[unpinned[1].Cid()] <= atimes[unpinned[4].Cid()] {
+		t.Fatalf("expected the access times to be kept, got %v", atimes)
+	}
+}
+
+func TestEvictLRUNotTracked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	bs := NewTrackingBlockstore(bstore.NewGCBlockstore(bstore.NewBlockstore(ds), bstore.NewGCLocker()))
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	pinning, err := dspinner.New(ctx, ds, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noRoots := func() ([]cid.Cid, error) { return nil, nil }
+	var errs []error
+	for res := range EvictLRU(ctx, bs, ds, pinning, noRoots, 100) {
+		errs = append(errs, res.Error)
+	}
+	if len(errs) != 1 || errs[0] != ErrAccessTimesNotTracked {
+		t.Fatalf("expected %s, got %v", ErrAccessTimesNotTracked, errs)
+	}
+}
@@ -56,6 +56,8 @@ require (
 	github.com/ipfs/interface-go-ipfs-core v0.4.0
 	github.com/ipfs/tar-utils v0.0.1
 	github.com/ipld/go-car v0.3.1
+	github.com/ipld/go-codec-dagpb v1.2.0
+	github.com/ipld/go-ipld-prime v0.9.1-0.20210324083106-dc342a9917db
 	github.com/jbenet/go-random v0.0.0-20190219211222-123a90aedc0c
 	github.com/jbenet/go-temp-err-catcher v0.1.0
 	github.com/jbenet/goprocess v0.1.4
@@ -93,6 +95,7 @@ require (
 	github.com/multiformats/go-multihash v0.0.15
 	github.com/opentracing/opentracing-go v1.2.0
 	github.com/pkg/errors v0.9.1
+	github.com/polydawn/refmt v0.0.0-20201211092308-30ac6d18308e
 	github.com/prometheus/client_golang v1.10.0
 	github.com/stretchr/testify v1.7.0
 	github.com/syndtr/goleveldb v1.0.0
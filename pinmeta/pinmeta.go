@@ -0,0 +1,221 @@
+// Package pinmeta keeps what is known about local pins besides their CID and
+// type: a name, arbitrary key/value metadata and an optional expiry time,
+// after which the pin is removed.
+//
+// The records live in the repo datastore, next to the pins, under
+// /local/pinmeta/<cid>. A pin without a record has no name, no metadata and
+// never expires.
+package pinmeta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	namespace "github.com/ipfs/go-datastore/namespace"
+	query "github.com/ipfs/go-datastore/query"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("pinmeta")
+
+// Prefix is the datastore key under which the records are stored.
+var Prefix = datastore.NewKey("/local/pinmeta")
+
+// Info is the name, metadata and expiry time of a pin.
+type Info struct {
+	Name    string            `json:",omitempty"`
+	Meta    map[string]string `json:",omitempty"`
+	Expires *time.Time        `json:",omitempty"`
+}
+
+// Empty reports whether info records nothing.
+func (info *Info) Empty() bool {
+	return info == nil || (info.Name == "" && len(info.Meta) == 0 && info.Expires == nil)
+}
+
+// Expired reports whether the pin expired at t.
+func (info *Info) Expired(t time.Time) bool {
+	return info != nil && info.Expires != nil && !info.Expires.After(t)
+}
+
+// Filter selects pins by name and metadata. The zero Filter matches every
+// pin.
+type Filter struct {
+	Name string
+	// Meta are the key/value pairs a pin must all have.
+	Meta map[string]string
+}
+
+// Empty reports whether f matches every pin.
+func (f Filter) Empty() bool {
+	return f.Name == "" && len(f.Meta) == 0
+}
+
+// Match reports whether a pin with the given info, which may be nil, is
+// selected by f.
+func (f Filter) Match(info *Info) bool {
+	if f.Empty() {
+		return true
+	}
+	if info == nil || (f.Name != "" && info.Name != f.Name) {
+		return false
+	}
+	for k, v := range f.Meta {
+		if got, ok := info.Meta[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Store reads and writes the records of the pins.
+type Store struct {
+	ds datastore.Datastore
+}
+
+// New returns a store that keeps the records in ds, usually the root
+// datastore of the repo.
+func New(ds datastore.Datastore) *Store {
+	return &Store{ds: namespace.Wrap(ds, Prefix)}
+}
+
+func key(c cid.Cid) datastore.Key {
+	return datastore.NewKey(c.String())
+}
+
+// Get returns the record of the pin of c, or nil if there is none.
+func (s *Store) Get(c cid.Cid) (*Info, error) {
+	data, err := s.ds.Get(key(c))
+	if err == datastore.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	info := new(Info)
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("pin record of %s: %w", c, err)
+	}
+	return info, nil
+}
+
+// Put records info for the pin of c, replacing the previous record. An empty
+// info deletes the record.
+func (s *Store) Put(c cid.Cid, info *Info) error {
+	if info.Empty() {
+		return s.Delete(c)
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := s.ds.Put(key(c), data); err != nil {
+		return err
+	}
+	return s.ds.Sync(key(c))
+}
+
+// Delete removes the record of the pin of c, if any.
+func (s *Store) Delete(c cid.Cid) error {
+	if err := s.ds.Delete(key(c)); err != nil && err != datastore.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// Move moves the record of the pin of from to the pin of to, when a pin is
+// updated.
+func (s *Store) Move(from, to cid.Cid) error {
+	info, err := s.Get(from)
+	if err != nil || info == nil {
+		return err
+	}
+	if err := s.Put(to, info); err != nil {
+		return err
+	}
+	return s.Delete(from)
+}
+
+// All returns all the records, by CID.
+func (s *Store) All() (map[cid.Cid]*Info, error) {
+	results, err := s.ds.Query(query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	all := make(map[cid.Cid]*Info)
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		c, err := cid.Decode(datastore.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			log.Errorf("skipping pin record %s: %s", r.Key, err)
+			continue
+		}
+		info := new(Info)
+		if err := json.Unmarshal(r.Value, info); err != nil {
+			log.Errorf("skipping pin record of %s: %s", c, err)
+			continue
+		}
+		all[c] = info
+	}
+	return all, nil
+}
+
+// RemoveExpired removes the pins that expired at now, along with their
+// records, and returns their CIDs. The expired records of CIDs that are no
+// longer pinned are deleted too, but these CIDs aren't returned. Records that
+// haven't expired are kept, pinned or not.
+func (s *Store) RemoveExpired(ctx context.Context, pinning pin.Pinner, locker bstore.GCLocker, now time.Time) ([]cid.Cid, error) {
+	all, err := s.All()
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []cid.Cid
+	for c, info := range all {
+		if info.Expired(now) {
+			expired = append(expired, c)
+		}
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+
+	// Note: after unpin the pin sets are flushed to the blockstore, so we
+	// need to take a lock to prevent a concurrent garbage collection
+	defer locker.PinLock().Unlock()
+
+	var removed []cid.Cid
+	for _, c := range expired {
+		mode, pinned, err := pinning.IsPinned(ctx, c)
+		if err != nil {
+			return removed, err
+		}
+		pinned = pinned && (mode == "recursive" || mode == "direct")
+		if pinned {
+			if err := pinning.Unpin(ctx, c, true); err != nil {
+				return removed, fmt.Errorf("removing expired pin %s: %w", c, err)
+			}
+		}
+		if err := s.Delete(c); err != nil {
+			return removed, err
+		}
+		if pinned {
+			removed = append(removed, c)
+		}
+	}
+	if len(removed) > 0 {
+		if err := pinning.Flush(ctx); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,142 @@
+package pinmeta
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	"github.com/ipfs/go-merkledag"
+	mdtest "github.com/ipfs/go-merkledag/test"
+)
+
+func TestStore(t *testing.T) {
+	s := New(dssync.MutexWrap(datastore.NewMapDatastore()))
+	a := merkledag.NewRawNode([]byte("a")).Cid()
+	b := merkledag.NewRawNode([]byte("b")).Cid()
+
+	if info, err := s.Get(a); err != nil || info != nil {
+		t.Fatalf("expected no record, got %v, %v", info, err)
+	}
+
+	info := &Info{Name: "backup", Meta: map[string]string{"job": "nightly"}}
+	if err := s.Put(a, info); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "backup" || got.Meta["job"] != "nightly" || got.Expires != nil {
+		t.Fatalf("unexpected record %+v", got)
+	}
+
+	if err := s.Move(a, b); err != nil {
+		t.Fatal(err)
+	}
+	all, err := s.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[b] == nil || all[b].Name != "backup" {
+		t.Fatalf("expected the record to be moved to %s, got %v", b, all)
+	}
+
+	// an empty record deletes it
+	if err := s.Put(b, &Info{}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := s.Get(b); err != nil || info != nil {
+		t.Fatalf("expected no record, got %v, %v", info, err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	info := &Info{Name: "backup", Meta: map[string]string{"job": "nightly", "team": "infra"}}
+	for _, test := range []struct {
+		filter Filter
+		info   *Info
+		match  bool
+	}{
+		{Filter{}, nil, true},
+		{Filter{Name: "backup"}, nil, false},
+		{Filter{Name: "backup"}, info, true},
+		{Filter{Name: "other"}, info, false},
+		{Filter{Meta: map[string]string{"job": "nightly"}}, info, true},
+		{Filter{Name: "backup", Meta: map[string]string{"job": "nightly", "team": "infra"}}, info, true},
+		{Filter{Meta: map[string]string{"job": "weekly"}}, info, false},
+		{Filter{Meta: map[string]string{"owner": ""}}, info, false},
+	} {
+		if match := test.filter.Match(test.info); match != test.match {
+			t.Errorf("%+v matching %+v: got %t, expected %t", test.filter, test.info, match, test.match)
+		}
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	ctx := context.Background()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	dserv := mdtest.Mock()
+	pinning, err := dspinner.New(ctx, ds, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locker := bstore.NewGCLocker()
+	s := New(ds)
+
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	pins := map[string]*Info{
+		"expired":   {Name: "expired", Expires: &past},
+		"current":   {Name: "current", Expires: &future},
+		"permanent": {Name: "permanent"},
+	}
+	cids := make(map[string]cid.Cid)
+	for name, info := range pins {
+		nd := merkledag.NewRawNode([]byte(name))
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		if err := pinning.Pin(ctx, nd, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(nd.Cid(), info); err != nil {
+			t.Fatal(err)
+		}
+		cids[name] = nd.Cid()
+	}
+	// a record left over for a pin that is already gone
+	gone := merkledag.NewRawNode([]byte("gone")).Cid()
+	if err := s.Put(gone, &Info{Expires: &past}); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := s.RemoveExpired(ctx, pinning, locker, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != cids["expired"] {
+		t.Fatalf("expected only %s to be removed, got %v", cids["expired"], removed)
+	}
+
+	for name, c := range cids {
+		_, pinned, err := pinning.IsPinned(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pinned != (name != "expired") {
+			t.Errorf("pin %s: pinned is %t", name, pinned)
+		}
+	}
+	all, err := s.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[cids["current"]] == nil || all[cids["permanent"]] == nil {
+		t.Fatalf("unexpected records left: %v", all)
+	}
+}
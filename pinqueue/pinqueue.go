@@ -0,0 +1,491 @@
+// Package pinqueue implements a persistent queue of local pins, which are
+// fetched and pinned in the background rather than while the client waits.
+//
+// Each pin goes through the statuses of the remote pinning services:
+// queued, pinning, and then pinned or failed. Failed attempts are retried
+// with an exponential backoff, up to a maximum number of attempts. Pins that
+// expire before they are pinned fail without being attempted again. The jobs
+// are stored in the repo datastore under /local/pinqueue/<cid>, so that the
+// queue survives restarts: pins that were in progress are queued again. Jobs
+// that are done are kept for a while, so that their status can be reported,
+// and then dropped.
+package pinqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	namespace "github.com/ipfs/go-datastore/namespace"
+	query "github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log"
+
+	"github.com/ipfs/go-ipfs/pinmeta"
+)
+
+var log = logging.Logger("pinqueue")
+
+// Prefix is the datastore key under which the jobs are stored.
+var Prefix = datastore.NewKey("/local/pinqueue")
+
+// Status is the status of a queued pin.
+type Status string
+
+const (
+	// Queued pins wait for a worker, possibly for a retry after a failed
+	// attempt.
+	Queued Status = "queued"
+	// Pinning pins are being fetched and pinned.
+	Pinning Status = "pinning"
+	// Pinned pins are done.
+	Pinned Status = "pinned"
+	// Failed pins ran out of attempts.
+	Failed Status = "failed"
+)
+
+// ParseStatus parses the name of a status.
+func ParseStatus(s string) (Status, error) {
+	switch st := Status(s); st {
+	case Queued, Pinning, Pinned, Failed:
+		return st, nil
+	}
+	return "", fmt.Errorf("invalid pin status %q, must be one of {queued, pinning, pinned, failed}", s)
+}
+
+// ExpiredError is the error of the jobs whose pin expired before it was
+// pinned.
+const ExpiredError = "the pin expired before it was pinned"
+
+// Done reports whether the pin won't be attempted again.
+func (s Status) Done() bool {
+	return s == Pinned || s == Failed
+}
+
+// Job is a pin requested through the queue.
+type Job struct {
+	Cid       cid.Cid
+	Recursive bool
+	Info      *pinmeta.Info `json:",omitempty"`
+
+	Status   Status
+	Attempts int `json:",omitempty"`
+	// Error is the error of the last failed attempt.
+	Error string `json:",omitempty"`
+	// Retry is when a queued pin is attempted again after a failure.
+	Retry *time.Time `json:",omitempty"`
+
+	Created time.Time
+	Updated time.Time
+}
+
+// PinFunc fetches and pins c. It is given the record of the pin, if any,
+// see the pinmeta package.
+type PinFunc func(ctx context.Context, c cid.Cid, recursive bool, info *pinmeta.Info) error
+
+// Options are the limits of a Queue.
+type Options struct {
+	// Workers is how many pins are fetched at the same time.
+	Workers int
+	// MaxAttempts is how many times a pin is attempted before it fails.
+	MaxAttempts int
+	// RetryDelay is the delay before the first retry of a pin, doubled for
+	// each of the next ones, up to MaxRetryDelay.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// Retention is how long the jobs that are done are kept after they
+	// finished, or 0 to keep them until they are removed.
+	Retention time.Duration
+}
+
+// DefaultOptions are the default limits of a Queue.
+var DefaultOptions = Options{
+	Workers:       4,
+	MaxAttempts:   5,
+	RetryDelay:    time.Minute,
+	MaxRetryDelay: time.Hour,
+	Retention:     24 * time.Hour,
+}
+
+// Queue is a persistent queue of pins.
+type Queue struct {
+	ds   datastore.Datastore
+	pin  PinFunc
+	opts Options
+
+	mu      sync.Mutex
+	jobs    map[cid.Cid]*Job
+	running map[*Job]context.CancelFunc
+	// wake is closed and replaced when there is new work for the workers.
+	wake chan struct{}
+
+	started bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// New returns the queue stored in ds, usually the root datastore of the
+// repo, which pins with the given function once started. The pins that were
+// in progress when the queue last stopped are queued again.
+func New(ds datastore.Datastore, pin PinFunc, opts Options) (*Queue, error) {
+	if opts.Workers < 1 || opts.MaxAttempts < 1 {
+		return nil, errors.New("pin queue: workers and attempts must be at least 1")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		ds:      namespace.Wrap(ds, Prefix),
+		pin:     pin,
+		opts:    opts,
+		jobs:    make(map[cid.Cid]*Job),
+		running: make(map[*Job]context.CancelFunc),
+		wake:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	if err := q.load(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return q, nil
+}
+
+func key(c cid.Cid) datastore.Key {
+	return datastore.NewKey(c.String())
+}
+
+func (q *Queue) load() error {
+	results, err := q.ds.Query(query.Query{})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	for r := range results.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		job := new(Job)
+		if err := json.Unmarshal(r.Value, job); err != nil {
+			log.Errorf("skipping pin queue job %s: %s", r.Key, err)
+			continue
+		}
+		if job.Status == Pinning {
+			job.Status = Queued
+			if err := q.save(job); err != nil {
+				return err
+			}
+		}
+		q.jobs[job.Cid] = job
+	}
+	return nil
+}
+
+// save persists job, the caller holds q.mu or owns the queue.
+func (q *Queue) save(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if err := q.ds.Put(key(job.Cid), data); err != nil {
+		return err
+	}
+	return q.ds.Sync(key(job.Cid))
+}
+
+// Start starts the workers, which pin the queued pins until Close.
+func (q *Queue) Start() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.started {
+		return
+	}
+	q.started = true
+	for i := 0; i < q.opts.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+}
+
+// Close stops the workers. The pins in progress are queued again when the
+// queue is next loaded.
+func (q *Queue) Close() error {
+	q.cancel()
+	q.wg.Wait()
+	return nil
+}
+
+// Add queues c to be pinned, and returns its job. A pin that is already
+// queued or in progress is left as is, but is given info if it isn't nil. A
+// pin that is done is queued again, with a new count of attempts.
+func (q *Queue) Add(c cid.Cid, recursive bool, info *pinmeta.Info) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	job, ok := q.jobs[c]
+	if ok && !job.Status.Done() {
+		if info != nil {
+			job.Info = info
+			job.Updated = now
+			if err := q.save(job); err != nil {
+				return Job{}, err
+			}
+		}
+		return *job, nil
+	}
+
+	job = &Job{
+		Cid:       c,
+		Recursive: recursive,
+		Info:      info,
+		Status:    Queued,
+		Created:   now,
+		Updated:   now,
+	}
+	if err := q.save(job); err != nil {
+		return Job{}, err
+	}
+	q.jobs[c] = job
+	q.notify()
+	return *job, nil
+}
+
+// Get returns the job of c, if it is in the queue.
+func (q *Queue) Get(c cid.Cid) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[c]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Jobs returns all the jobs, in the order they were queued.
+func (q *Queue) Jobs() []Job {
+	q.mu.Lock()
+	jobs := make([]Job, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		jobs = append(jobs, *job)
+	}
+	q.mu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].Created.Equal(jobs[j].Created) {
+			return jobs[i].Created.Before(jobs[j].Created)
+		}
+		return jobs[i].Cid.KeyString() < jobs[j].Cid.KeyString()
+	})
+	return jobs
+}
+
+// Remove removes the job of c from the queue, stopping it if it is in
+// progress, and returns it. It returns false if c isn't in the queue.
+func (q *Queue) Remove(c cid.Cid) (Job, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[c]
+	if !ok {
+		return Job{}, false, nil
+	}
+	if cancel, ok := q.running[job]; ok {
+		cancel()
+		delete(q.running, job)
+	}
+	delete(q.jobs, c)
+	if err := q.ds.Delete(key(c)); err != nil && err != datastore.ErrNotFound {
+		return Job{}, false, err
+	}
+	return *job, true, nil
+}
+
+// Expire fails the jobs whose pin expired at now before it was pinned,
+// stopping them if they are in progress, and returns their CIDs.
+func (q *Queue) Expire(now time.Time) []cid.Cid {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var expired []cid.Cid
+	for c, job := range q.jobs {
+		if q.expire(job, now) {
+			expired = append(expired, c)
+		}
+	}
+	return expired
+}
+
+// expire fails job if its pin expired at now before it was pinned, and
+// reports whether it did. The caller holds q.mu.
+func (q *Queue) expire(job *Job, now time.Time) bool {
+	if job.Status.Done() || !job.Info.Expired(now) {
+		return false
+	}
+	if cancel, ok := q.running[job]; ok {
+		cancel()
+		delete(q.running, job)
+	}
+	job.Status = Failed
+	job.Error = ExpiredError
+	job.Retry = nil
+	job.Updated = now
+	if err := q.save(job); err != nil {
+		log.Errorf("pin queue: %s", err)
+	}
+	return true
+}
+
+// notify wakes the workers up, the caller holds q.mu.
+func (q *Queue) notify() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+func (q *Queue) worker() {
+	defer q.wg.Done()
+	for {
+		job, ctx, wake, wait := q.next()
+		if job != nil {
+			q.run(ctx, job)
+			continue
+		}
+
+		var retry <-chan time.Time
+		var timer *time.Timer
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			retry = timer.C
+		}
+		select {
+		case <-q.ctx.Done():
+		case <-wake:
+		case <-retry:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if q.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// next returns the next job to attempt, marked as pinning, with its
+// context, fails the jobs that expired, and drops the jobs that are done and
+// past their retention. When
+// there is no job to attempt yet, it returns the channel closed on new work,
+// and how long to wait for the next retry or expiry, or 0 if there is none.
+func (q *Queue) next() (*Job, context.Context, <-chan struct{}, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var next *Job
+	var wait time.Duration
+	waitUntil := func(t time.Time) {
+		if d := t.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+	for c, job := range q.jobs {
+		if job.Status.Done() && q.opts.Retention > 0 {
+			expiry := job.Updated.Add(q.opts.Retention)
+			if expiry.After(now) {
+				waitUntil(expiry)
+				continue
+			}
+			delete(q.jobs, c)
+			if err := q.ds.Delete(key(c)); err != nil && err != datastore.ErrNotFound {
+				log.Errorf("pin queue: %s", err)
+			}
+			continue
+		}
+		if job.Status != Queued || q.expire(job, now) {
+			continue
+		}
+		if job.Retry != nil && job.Retry.After(now) {
+			waitUntil(*job.Retry)
+			if job.Info != nil && job.Info.Expires != nil {
+				// it may expire before it is retried
+				waitUntil(*job.Info.Expires)
+			}
+			continue
+		}
+		if next == nil || job.Created.Before(next.Created) {
+			next = job
+		}
+	}
+	if next == nil {
+		return nil, nil, q.wake, wait
+	}
+
+	next.Status = Pinning
+	next.Retry = nil
+	next.Updated = now
+	if err := q.save(next); err != nil {
+		log.Errorf("pin queue: %s", err)
+	}
+	ctx, cancel := context.WithCancel(q.ctx)
+	q.running[next] = cancel
+	return next, ctx, nil, 0
+}
+
+// run attempts job, which was returned by next.
+func (q *Queue) run(ctx context.Context, job *Job) {
+	q.mu.Lock()
+	c, recursive, info := job.Cid, job.Recursive, job.Info
+	q.mu.Unlock()
+
+	err := q.pin(ctx, c, recursive, info)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if cancel, ok := q.running[job]; ok {
+		cancel()
+		delete(q.running, job)
+	}
+	if q.jobs[c] != job || job.Status != Pinning || q.ctx.Err() != nil {
+		// removed or expired meanwhile, or stopped: it is then queued
+		// again on the next start
+		return
+	}
+
+	current := job
+	now := time.Now()
+	current.Updated = now
+	current.Attempts++
+	if err == nil {
+		current.Status = Pinned
+		current.Error = ""
+	} else {
+		log.Debugf("pin queue: attempt %d of %s failed: %s", current.Attempts, c, err)
+		current.Error = err.Error()
+		if current.Attempts >= q.opts.MaxAttempts {
+			current.Status = Failed
+		} else {
+			current.Status = Queued
+			retry := now.Add(q.retryDelay(current.Attempts))
+			current.Retry = &retry
+		}
+	}
+	if err := q.save(current); err != nil {
+		log.Errorf("pin queue: %s", err)
+	}
+}
+
+// retryDelay returns the delay before the retry after the given number of
+// attempts.
+func (q *Queue) retryDelay(attempts int) time.Duration {
+	d := q.opts.RetryDelay
+	for i := 1; i < attempts && d < q.opts.MaxRetryDelay; i++ {
+		d *= 2
+	}
+	if q.opts.MaxRetryDelay > 0 && d > q.opts.MaxRetryDelay {
+		d = q.opts.MaxRetryDelay
+	}
+	return d
+}
@@ -0,0 +1,275 @@
+package pinqueue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-merkledag"
+
+	"github.com/ipfs/go-ipfs/pinmeta"
+)
+
+var testOptions = Options{
+	Workers:       2,
+	MaxAttempts:   3,
+	RetryDelay:    time.Millisecond,
+	MaxRetryDelay: 10 * time.Millisecond,
+}
+
+func testCid(s string) cid.Cid {
+	return merkledag.NewRawNode([]byte(s)).Cid()
+}
+
+// waitFor waits until the job of c has the given status.
+func waitFor(t *testing.T, q *Queue, c cid.Cid, status Status) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, ok := q.Get(c)
+		if ok && job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: expected status %s, got %+v", c, status, job)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	ok, flaky, broken := testCid("ok"), testCid("flaky"), testCid("broken")
+
+	var mu sync.Mutex
+	attempts := make(map[cid.Cid]int)
+	var pinned []cid.Cid
+	pin := func(ctx context.Context, c cid.Cid, recursive bool, info *pinmeta.Info) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[c]++
+		switch {
+		case c == broken, c == flaky && attempts[c] < 2:
+			return errors.New("not found")
+		}
+		pinned = append(pinned, c)
+		return nil
+	}
+
+	q, err := New(dssync.MutexWrap(datastore.NewMapDatastore()), pin, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	for _, c := range []cid.Cid{ok, flaky, broken} {
+		job, err := q.Add(c, true, &pinmeta.Info{Name: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != Queued {
+			t.Fatalf("expected a new job to be queued, got %s", job.Status)
+		}
+	}
+	q.Start()
+
+	if job := waitFor(t, q, ok, Pinned); job.Attempts != 1 || job.Error != "" {
+		t.Errorf("unexpected job %+v", job)
+	}
+	if job := waitFor(t, q, flaky, Pinned); job.Attempts != 2 || job.Error != "" {
+		t.Errorf("unexpected job %+v", job)
+	}
+	if job := waitFor(t, q, broken, Failed); job.Attempts != 3 || job.Error != "not found" {
+		t.Errorf("unexpected job %+v", job)
+	}
+
+	// a failed pin is queued again with a new count of attempts
+	if job, err := q.Add(broken, true, nil); err != nil || job.Status != Queued || job.Attempts != 0 {
+		t.Fatalf("expected the job to be queued again, got %+v, %v", job, err)
+	}
+	waitFor(t, q, broken, Failed)
+
+	jobs := q.Jobs()
+	if len(jobs) != 3 || jobs[0].Cid != ok || jobs[1].Cid != flaky || jobs[2].Cid != broken {
+		t.Fatalf("unexpected jobs %v", jobs)
+	}
+	if jobs[0].Info == nil || jobs[0].Info.Name != "test" {
+		t.Fatalf("expected the record to be kept, got %+v", jobs[0].Info)
+	}
+}
+
+func TestQueueRestart(t *testing.T) {
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	c := testCid("slow")
+
+	started := make(chan struct{})
+	block := func(ctx context.Context, c cid.Cid, recursive bool, info *pinmeta.Info) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	q, err := New(ds, block, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Add(c, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	q.Start()
+	<-started
+	waitFor(t, q, c, Pinning)
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pin in progress is queued again, and then done
+	done := func(ctx context.Context, c cid.Cid, recursive bool, info *pinmeta.Info) error {
+		if recursive {
+			return errors.New("expected a direct pin")
+		}
+		return nil
+	}
+	q, err = New(ds, done, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if job, ok := q.Get(c); !ok || job.Status != Queued {
+		t.Fatalf("expected the job to be queued again, got %+v", job)
+	}
+	q.Start()
+	waitFor(t, q, c, Pinned)
+}
+
+func TestQueueRemove(t *testing.T) {
+	c := testCid("removed")
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+	pin := func(ctx context.Context, c cid.Cid, recursive bool, info *pinmeta.Info) error {
+		close(started)
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return ctx.Err()
+	}
+
+	q, err := New(dssync.MutexWrap(datastore.NewMapDatastore()), pin, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if _, err := q.Add(c, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	q.Start()
+	<-started
+
+	job, ok, err := q.Remove(c)
+	if err != nil || !ok || job.Status != Pinning {
+		t.Fatalf("expected to remove the pin in progress, got %+v, %t, %v", job, ok, err)
+	}
+	if err := <-stopped; err != context.Canceled {
+		t.Fatalf("expected the pin to be canceled, got %v", err)
+	}
+	if _, ok := q.Get(c); ok {
+		t.Fatal("expected the job to be removed")
+	}
+	if _, ok, err := q.Remove(c); ok || err != nil {
+		t.Fatalf("expected nothing to remove, got %t, %v", ok, err)
+	}
+}
+
+func TestQueueRetention(t *testing.T) {
+	ok, broken := testCid("ok"), testCid("broken")
+	pin := func(ctx context.Context, c cid.Cid, recursive bool, info *pinmeta.Info) error {
+		if c == broken {
+			return errors.New("not found")
+		}
+		return nil
+	}
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	opts := testOptions
+	opts.MaxAttempts = 1
+	opts.Retention = 50 * time.Millisecond
+	q, err := New(ds, pin, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	for _, c := range []cid.Cid{ok, broken} {
+		if _, err := q.Add(c, true, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.Start()
+
+	// the jobs that are done are reported, and then dropped
+	waitFor(t, q, ok, Pinned)
+	waitFor(t, q, broken, Failed)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(q.Jobs()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the jobs to be dropped, got %v", q.Jobs())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if has, err := ds.Has(Prefix.ChildString(ok.String())); err != nil || has {
+		t.Fatalf("expected the job to be deleted from the datastore, got %t, %v", has, err)
+	}
+}
+
+func TestQueueExpired(t *testing.T) {
+	expired, running := testCid("expired"), testCid("running")
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+	pin := func(ctx context.Context, c cid.Cid, recursive bool, info *pinmeta.Info) error {
+		if c == expired {
+			t.Errorf("expected the expired pin not to be attempted")
+			return nil
+		}
+		close(started)
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return ctx.Err()
+	}
+
+	q, err := New(dssync.MutexWrap(datastore.NewMapDatastore()), pin, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	past := time.Now().Add(-time.Minute)
+	if _, err := q.Add(expired, true, &pinmeta.Info{Expires: &past}); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if _, err := q.Add(running, true, &pinmeta.Info{Expires: &future}); err != nil {
+		t.Fatal(err)
+	}
+	q.Start()
+
+	// a queued pin that expired fails when it is taken
+	if job := waitFor(t, q, expired, Failed); job.Attempts != 0 || job.Error != ExpiredError {
+		t.Errorf("unexpected job %+v", job)
+	}
+
+	// a pin in progress is stopped once it expired
+	<-started
+	if got := q.Expire(time.Now()); len(got) != 0 {
+		t.Fatalf("expected nothing to expire yet, got %v", got)
+	}
+	if got := q.Expire(future); len(got) != 1 || got[0] != running {
+		t.Fatalf("expected %s to expire, got %v", running, got)
+	}
+	if err := <-stopped; err != context.Canceled {
+		t.Fatalf("expected the pin to be canceled, got %v", err)
+	}
+	if job := waitFor(t, q, running, Failed); job.Error != ExpiredError {
+		t.Errorf("unexpected job %+v", job)
+	}
+}
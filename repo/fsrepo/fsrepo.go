@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 
@@ -558,9 +559,7 @@ func (r *FSRepo) setConfigUnsynced(updated *config.Config) error {
 	if err != nil {
 		return err
 	}
-	for k, v := range m {
-		mapconf[k] = v
-	}
+	mergeConfig(mapconf, m, reflect.TypeOf(config.Config{}))
 	if err := serialize.WriteConfigFile(configFilename, mapconf); err != nil {
 		return err
 	}
@@ -570,6 +569,35 @@ func (r *FSRepo) setConfigUnsynced(updated *config.Config) error {
 	return nil
 }
 
+// mergeConfig writes the values of m, of the given struct type, to mapconf.
+// Structs are merged field by field, so that the keys the struct doesn't
+// declare are kept, while other values, maps included, are replaced. The
+// declared keys missing from m, such as the omitempty fields that were
+// reset, are removed from mapconf.
+func mergeConfig(mapconf, m map[string]interface{}, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		k := t.Field(i).Name
+		if _, ok := m[k]; !ok {
+			delete(mapconf, k)
+		}
+	}
+	for k, v := range m {
+		if f, ok := t.FieldByName(k); ok {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			dst, dok := mapconf[k].(map[string]interface{})
+			src, sok := v.(map[string]interface{})
+			if ft.Kind() == reflect.Struct && dok && sok {
+				mergeConfig(dst, src, ft)
+				continue
+			}
+		}
+		mapconf[k] = v
+	}
+}
+
 // SetConfig updates the FSRepo's config. The user must not modify the config
 // object after calling this method.
 func (r *FSRepo) SetConfig(updated *config.Config) error {
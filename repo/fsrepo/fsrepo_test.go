@@ -116,3 +116,42 @@ func TestOpenMoreThanOnceInSameProcess(t *testing.T) {
 	assert.Nil(r1.Close(), t)
 	assert.Nil(r2.Close(), t)
 }
+
+func TestSetConfigKeepsUnknownKeys(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("", t)
+	cfg := &config.Config{Datastore: config.DefaultDatastoreConfig()}
+	cfg.Identity.PrivKey = "key"
+	assert.Nil(Init(path, cfg), t)
+	r, err := Open(path)
+	assert.Nil(err, t)
+	defer r.Close()
+
+	assert.Nil(r.SetConfigKey("Pinning.Queue.Workers", 8), t, "setting an unknown key should succeed")
+	assert.Nil(r.SetConfigKey("Pinning.RemoteServices", map[string]interface{}{}), t)
+	updated, err := r.Config()
+	assert.Nil(err, t)
+	updated, err = updated.Clone()
+	assert.Nil(err, t)
+	updated.Pinning.RemoteServices = nil
+	assert.Nil(r.SetConfig(updated), t)
+
+	// resetting an omitempty field removes it from the file
+	updated, err = updated.Clone()
+	assert.Nil(err, t)
+	updated.Swarm.DisableRelay = true
+	assert.Nil(r.SetConfig(updated), t)
+	updated, err = updated.Clone()
+	assert.Nil(err, t)
+	updated.Swarm.DisableRelay = false
+	assert.Nil(r.SetConfig(updated), t)
+	_, err = r.GetConfigKey("Swarm.DisableRelay")
+	assert.Err(err, t, "the reset omitempty field should be removed")
+
+	v, err := r.GetConfigKey("Pinning.Queue.Workers")
+	assert.Nil(err, t, "the unknown key should be kept")
+	assert.True(v == float64(8), t, "the unknown key should keep its value")
+	v, err = r.GetConfigKey("Pinning.RemoteServices")
+	assert.Nil(err, t)
+	assert.True(v == nil, t, "known keys should be updated")
+}
@@ -70,3 +70,11 @@ func (r *ref) Close() error {
 	delete(r.parent.active, r.key)
 	return r.Repo.Close()
 }
+
+// Path returns the directory of the repo, or "" if it doesn't live on disk.
+func (r *ref) Path() string {
+	if fsRepo, ok := r.Repo.(interface{ Path() string }); ok {
+		return fsRepo.Path()
+	}
+	return ""
+}
@@ -0,0 +1,30 @@
+package repo
+
+import "testing"
+
+type pathRepo struct {
+	Mock
+	path string
+}
+
+func (r *pathRepo) Path() string { return r.path }
+
+func TestOnlyOnePath(t *testing.T) {
+	var o OnlyOne
+	r, err := o.Open("fs", func() (Repo, error) { return &pathRepo{path: "/repo"}, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the directory of the repo stays reachable through the wrapper
+	if p := r.(interface{ Path() string }).Path(); p != "/repo" {
+		t.Fatalf("got path %q, expected /repo", p)
+	}
+
+	r, err = o.Open("mem", func() (Repo, error) { return &Mock{}, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := r.(interface{ Path() string }).Path(); p != "" {
+		t.Fatalf("got path %q for a repo that doesn't live on disk", p)
+	}
+}
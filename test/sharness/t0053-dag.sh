@@ -303,6 +303,175 @@ test_dag_cmd() {
     echo "Size: 302705, NumBlocks: 5" > exp_stat_directory_unixfs &&
     test_cmp exp_stat_directory_unixfs actual_stat_directory_unixfs
   '
+
+  test_expect_success "dag stat --verbose shows duplicates, depth and codecs" '
+    ipfs dag stat -v $MULTIBLOCK_UNIXFS > actual_stat_verbose &&
+    cat > exp_stat_verbose <<-\EOF &&
+	Size: 302582, NumBlocks: 3
+	TotalSize: 10002428 (with duplicates), MaxDepth: 1
+	Codecs:
+	  protobuf  Blocks: 3  Size: 302582
+	Multihashes:
+	  sha2-256  Blocks: 3  Size: 302582
+	EOF
+    test_cmp exp_stat_verbose actual_stat_verbose
+  '
+
+  test_expect_success "dag stat --local-only counts the missing blocks" '
+    STAT_CHILD=$(echo "{\"stat\":\"child\"}" | ipfs dag put --pin=false) &&
+    STAT_PARENT=$(echo "{\"c\":{\"/\":\"$STAT_CHILD\"}}" | ipfs dag put --pin=false) &&
+    ipfs dag stat --local-only $STAT_PARENT > actual_stat_local &&
+    echo "Complete: all the blocks are local" > exp_stat_local_complete &&
+    tail -n 1 actual_stat_local > actual_stat_local_complete &&
+    test_cmp exp_stat_local_complete actual_stat_local_complete &&
+    ipfs block rm $STAT_CHILD &&
+    ipfs dag stat --local-only $STAT_PARENT > actual_stat_local_missing &&
+    tail -n 1 actual_stat_local_missing > actual_stat_local_missing_count &&
+    echo "MissingBlocks: 1" > exp_stat_local_missing_count &&
+    test_cmp exp_stat_local_missing_count actual_stat_local_missing_count
+  '
+
+  test_expect_success "dag get --output-codec=dag-json writes links and bytes as in the spec" '
+    echo "{\"b\":{\"/\":{\"bytes\":\"aGVsbG8\"}},\"l\":{\"/\":\"$IPLDHASH\"},\"n\":12345678901234567}" > codec_in.json &&
+    CODEC_OBJ=$(ipfs dag put --input-enc=dag-json < codec_in.json) &&
+    ipfs dag get --output-codec=dag-json $CODEC_OBJ > codec_out.json &&
+    test_cmp codec_in.json codec_out.json
+  '
+
+  test_expect_success "dag get --output-codec output round-trips through dag put" '
+    ipfs dag get --output-codec=dag-cbor $CODEC_OBJ > codec_out.cbor &&
+    echo $CODEC_OBJ > codec_exp &&
+    ipfs dag put --input-enc=cbor < codec_out.cbor > codec_cbor_actual &&
+    test_cmp codec_exp codec_cbor_actual &&
+    ipfs dag put --input-enc=dag-json < codec_out.json > codec_json_actual &&
+    test_cmp codec_exp codec_json_actual
+  '
+
+  test_expect_success "dag get --output-codec=raw writes bytes and blocks as they are" '
+    ipfs dag get --output-codec=raw $CODEC_OBJ/b > codec_bytes &&
+    printf hello > codec_bytes_exp &&
+    test_cmp codec_bytes_exp codec_bytes &&
+    ipfs dag get --output-codec=raw $CODEC_OBJ > codec_block &&
+    ipfs block get $CODEC_OBJ > codec_block_exp &&
+    test_cmp codec_block_exp codec_block
+  '
+
+  test_expect_success "dag put --batch links objects by label" '
+    echo "{\"label\": \"alice\", \"object\": {\"name\": \"Alice\"}}" > batch.ndjson &&
+    echo "" >> batch.ndjson &&
+    echo "{\"label\": \"bob\", \"object\": {\"name\": \"Bob\", \"friends\": [{\"/\": \"@alice\"}]}}" >> batch.ndjson &&
+    echo "{\"object\": {\"all\": [{\"/\": \"@alice\"}, {\"/\": \"@bob\"}]}}" >> batch.ndjson &&
+    ipfs dag put --batch < batch.ndjson > batch_out &&
+    ALICE=$(echo "{\"name\": \"Alice\"}" | ipfs dag put) &&
+    BOB=$(echo "{\"name\": \"Bob\", \"friends\": [{\"/\": \"$ALICE\"}]}" | ipfs dag put) &&
+    ALL=$(echo "{\"all\": [{\"/\": \"$ALICE\"}, {\"/\": \"$BOB\"}]}" | ipfs dag put) &&
+    printf "alice\t$ALICE\nbob\t$BOB\n4\t$ALL\n" > batch_exp &&
+    test_cmp batch_exp batch_out
+  '
+
+  test_expect_success "dag put --batch labels the records of several files by their line in the batch" '
+    echo "{\"object\": {\"n\": 1}}" > batch1.ndjson &&
+    echo "{\"object\": {\"n\": 2}}" >> batch1.ndjson &&
+    echo "{\"object\": {\"n\": 3}}" > batch2.ndjson &&
+    echo "{\"object\": {\"first\": {\"/\": \"@1\"}, \"third\": {\"/\": \"@3\"}}}" >> batch2.ndjson &&
+    ipfs dag put --batch batch1.ndjson batch2.ndjson > batch_files_out &&
+    N1=$(echo "{\"n\": 1}" | ipfs dag put) &&
+    N2=$(echo "{\"n\": 2}" | ipfs dag put) &&
+    N3=$(echo "{\"n\": 3}" | ipfs dag put) &&
+    LINKS=$(echo "{\"first\": {\"/\": \"$N1\"}, \"third\": {\"/\": \"$N3\"}}" | ipfs dag put) &&
+    printf "1\t$N1\n2\t$N2\n3\t$N3\n4\t$LINKS\n" > batch_files_exp &&
+    test_cmp batch_files_exp batch_files_out
+  '
+
+  test_expect_success "dag put --batch fails on links to unknown labels" '
+    echo "{\"object\": {\"x\": {\"/\": \"@nope\"}}}" > batch_bad.ndjson &&
+    test_expect_code 1 ipfs dag put --batch < batch_bad.ndjson 2> batch_bad_err &&
+    grep -q "line 1: link to unknown label \"nope\"" batch_bad_err
+  '
+
+  test_expect_success "setup dag put --schema" '
+    mkdir -p "$IPFS_PATH/schemas" &&
+    cat > "$IPFS_PATH/schemas/record.json" <<-\EOF &&
+	{"types": {
+	  "Record": {"struct": {"fields": {
+	    "name": {"type": "String"},
+	    "age": {"type": "Int", "optional": true},
+	    "tags": {"type": {"list": {"valueType": "String"}}, "optional": true}
+	  }}}
+	}}
+	EOF
+    SCHEMA=$(ipfs dag put < "$IPFS_PATH/schemas/record.json")
+  '
+
+  test_expect_success "dag put --schema accepts conforming objects" '
+    echo "{\"name\": \"a\", \"age\": 3, \"tags\": [\"x\"]}" > schema_ok.json &&
+    ipfs dag put --input-enc=dag-json < schema_ok.json > schema_ok_exp &&
+    ipfs dag put --input-enc=dag-json --schema=record < schema_ok.json > schema_ok_named &&
+    test_cmp schema_ok_exp schema_ok_named &&
+    ipfs dag put --input-enc=dag-json --schema=$SCHEMA --schema-type=Record < schema_ok.json > schema_ok_cid &&
+    test_cmp schema_ok_exp schema_ok_cid
+  '
+
+  test_expect_success "dag put --schema rejects objects with the paths of the errors" '
+    echo "{\"age\": \"3\", \"tags\": [\"x\", 1]}" > schema_bad.json &&
+    test_expect_code 1 ipfs dag put --input-enc=dag-json --schema=record < schema_bad.json 2> schema_bad_err &&
+    echo "Error: object does not conform to Record: /age: expected Int, got string; /tags/1: expected String, got int; /name: missing required field of Record" > schema_bad_exp &&
+    test_cmp schema_bad_exp schema_bad_err
+  '
+
+  test_expect_success "dag put --schema names --input-enc=dag-json when json made an Int a float" '
+    test_expect_code 1 ipfs dag put --schema=record < schema_ok.json 2> schema_float_err &&
+    grep -q "/age: expected Int, got float (--input-enc=json reads all numbers as floats: use --input-enc=dag-json for Int values)" schema_float_err
+  '
+
+  test_expect_success "dag put --batch --schema rejects the records that do not conform" '
+    echo "{\"object\": {\"name\": \"a\"}}" > schema_batch.ndjson &&
+    echo "{\"object\": {\"name\": \"b\", \"extra\": 1}}" >> schema_batch.ndjson &&
+    test_expect_code 1 ipfs dag put --batch --input-enc=dag-json --schema=record < schema_batch.ndjson 2> schema_batch_err &&
+    grep -q "line 2: object does not conform to Record: /extra: unexpected field: Record has no field extra" schema_batch_err
+  '
+
+  test_expect_success "dag put --schema fails on unknown schemas and types" '
+    test_expect_code 1 ipfs dag put --schema=nope < schema_ok.json 2> schema_nope_err &&
+    grep -q "no schema named nope" schema_nope_err &&
+    test_expect_code 1 ipfs dag put --schema=record --schema-type=Nope < schema_ok.json 2> schema_type_err &&
+    grep -q "schema record has no type Nope" schema_type_err
+  '
+
+  test_expect_success "prepare data for dag diff" '
+    DIFF_LEAF=$(echo "{\"name\":\"r1\"}" | ipfs dag put) &&
+    DIFF_LEAF_B=$(echo "{\"name\":\"r2\"}" | ipfs dag put) &&
+    DIFF_A=$(echo "{\"version\":1,\"tags\":[\"x\"],\"gone\":true,\"recs\":[{\"/\":\"$DIFF_LEAF\"},{\"/\":\"$DIFF_LEAF\"}]}" | ipfs dag put) &&
+    DIFF_B=$(echo "{\"version\":2,\"tags\":[\"x\",\"y\"],\"recs\":[{\"/\":\"$DIFF_LEAF\"},{\"/\":\"$DIFF_LEAF_B\"}]}" | ipfs dag put)
+  '
+
+  test_expect_success "dag diff of dag-cbor objects" '
+    ipfs dag diff $DIFF_A $DIFF_B > actual_diff &&
+    echo "- /gone: true" > exp_diff &&
+    echo "~ /recs/1/name: \"r1\" => \"r2\"" >> exp_diff &&
+    echo "+ /tags/1: \"y\"" >> exp_diff &&
+    echo "~ /version: 1 => 2" >> exp_diff &&
+    test_cmp exp_diff actual_diff
+  '
+
+  test_expect_success "dag diff of identical objects is empty" '
+    ipfs dag diff $DIFF_A $DIFF_A > actual_diff_same &&
+    test_must_be_empty actual_diff_same
+  '
+
+  test_expect_success "dag diff of UnixFS directories" '
+    mkdir -p diffdir_a diffdir_b &&
+    echo "same" > diffdir_a/same && echo "same" > diffdir_b/same &&
+    echo "before" > diffdir_a/changed && echo "after" > diffdir_b/changed &&
+    echo "old" > diffdir_a/old && echo "new" > diffdir_b/new &&
+    DIR_A=$(ipfs add -r --pin=false -Q diffdir_a) &&
+    DIR_B=$(ipfs add -r --pin=false -Q diffdir_b) &&
+    ipfs dag diff --enc=json $DIR_A $DIR_B > actual_diff_unixfs &&
+    grep -q "\"Type\":\"changed\",\"Path\":\"/changed\"" actual_diff_unixfs &&
+    grep -q "\"Type\":\"added\",\"Path\":\"/new\"" actual_diff_unixfs &&
+    grep -q "\"Type\":\"removed\",\"Path\":\"/old\"" actual_diff_unixfs &&
+    ! grep -q "/same" actual_diff_unixfs
+  '
 }
 
 # should work offline
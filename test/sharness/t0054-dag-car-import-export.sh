@@ -169,6 +169,158 @@ test_expect_success "correct error" '
 '
 
 
+test_expect_success "export with a selector of the whole DAG matches the default export" '
+  ipfs dag export "$HASH_WELCOME_DOCS" > welcome_docs.car &&
+  ipfs dag export --selector="{\"R\":{\"l\":{\"none\":{}},\":>\":{\"a\":{\">\":{\"@\":{}}}}}}" "$HASH_WELCOME_DOCS" > welcome_docs_selector.car &&
+  test_cmp welcome_docs.car welcome_docs_selector.car
+'
+
+test_expect_success "export with a selector limited to the root block" '
+  ipfs dag export --selector="{\".\":{}}" "$HASH_WELCOME_DOCS" > welcome_docs_root.car &&
+  test $(file_size welcome_docs_root.car) -lt $(file_size welcome_docs.car)
+'
+
+test_expect_success "a resumed export completes an interrupted one" '
+  ipfs dag export --resume-from=1 "$HASH_WELCOME_DOCS" > welcome_docs_resumed.car &&
+  cat welcome_docs_root.car welcome_docs_resumed.car > welcome_docs_completed.car &&
+  test_cmp welcome_docs.car welcome_docs_completed.car
+'
+
+test_expect_success "an export can be resumed after the CID of a block" '
+  ipfs dag export --resume-from="$HASH_WELCOME_DOCS" "$HASH_WELCOME_DOCS" > welcome_docs_resumed_cid.car &&
+  test_cmp welcome_docs_resumed.car welcome_docs_resumed_cid.car
+'
+
+test_expect_success "an export cannot be resumed past its end or as a CARv2 file" '
+  test_expect_code 1 ipfs dag export --resume-from=1000 "$HASH_WELCOME_DOCS" 2> resume_end_actual >/dev/null &&
+  grep -q "cannot resume from block 1000: the export only has" resume_end_actual &&
+  test_expect_code 1 ipfs dag export --resume-from=1 --car-version=2 "$HASH_WELCOME_DOCS" 2> resume_v2_actual >/dev/null &&
+  grep -q "only works with CARv1 exports" resume_v2_actual
+'
+
+test_expect_success "export with an invalid selector fails" '
+  test_expect_code 1 ipfs dag export --selector="{\"x\":{}}" "$HASH_WELCOME_DOCS" 2> invalid_selector_actual &&
+  grep -q "invalid selector" invalid_selector_actual
+'
+
+test_expect_success "export of a path includes the blocks proving it" '
+  README_CID=$(ipfs resolve -r /ipfs/$HASH_WELCOME_DOCS/readme | cut -d/ -f3) &&
+  ipfs dag export --car-version=2 /ipfs/$HASH_WELCOME_DOCS/readme > readme_path.car &&
+  IPFS_PATH="$(pwd)/.ipfs-proof" ipfs init --profile=test -e >/dev/null &&
+  IPFS_PATH="$(pwd)/.ipfs-proof" ipfs dag import readme_path.car > readme_path_import_actual &&
+  echo "Pinned root${tab}${HASH_WELCOME_DOCS}${tab}skipped: proof of the path to another root" > readme_path_import_expected &&
+  echo "Pinned root${tab}${README_CID}${tab}success" >> readme_path_import_expected &&
+  test_cmp_sorted readme_path_import_expected readme_path_import_actual &&
+  IPFS_PATH="$(pwd)/.ipfs-proof" ipfs pin ls --type=recursive $README_CID &&
+  test_must_fail env IPFS_PATH="$(pwd)/.ipfs-proof" ipfs pin ls $HASH_WELCOME_DOCS &&
+  IPFS_PATH="$(pwd)/.ipfs-proof" ipfs dag import --pin-roots=false --verify readme_path.car > readme_path_verify_actual &&
+  grep -q "^Root${tab}${HASH_WELCOME_DOCS}${tab}proof of the path to another root$" readme_path_verify_actual &&
+  IPFS_PATH="$(pwd)/.ipfs-proof" ipfs --offline cat /ipfs/$HASH_WELCOME_DOCS/readme > readme_actual &&
+  ipfs cat /ipfs/$HASH_WELCOME_DOCS/readme > readme_expected &&
+  test_cmp readme_expected readme_actual
+'
+
+test_expect_success "the proof of a CARv1 export of a path is incomplete unless --path-proof is set" '
+  ipfs dag export /ipfs/$HASH_WELCOME_DOCS/readme > readme_path_v1.car &&
+  IPFS_PATH="$(pwd)/.ipfs-proof-v1" ipfs init --profile=test -e >/dev/null &&
+  test_expect_code 1 env IPFS_PATH="$(pwd)/.ipfs-proof-v1" ipfs dag import --pin-roots=false --verify readme_path_v1.car > readme_path_v1_actual &&
+  grep -q "^Root${tab}${HASH_WELCOME_DOCS}${tab}incomplete: " readme_path_v1_actual &&
+  IPFS_PATH="$(pwd)/.ipfs-proof-v1" ipfs dag import --path-proof readme_path_v1.car > readme_path_v1_actual &&
+  test_cmp_sorted readme_path_import_expected readme_path_v1_actual
+'
+
+
+
+test_expect_success "export as CARv2 works" '
+  ipfs dag export --car-version=2 "$HASH_WELCOME_DOCS" > welcome_docs_v2.car &&
+  printf "\x0a\xa1\x67\x76\x65\x72\x73\x69\x6f\x6e\x02" > carv2_pragma_expected &&
+  head -c 11 welcome_docs_v2.car > carv2_pragma_actual &&
+  test_cmp carv2_pragma_expected carv2_pragma_actual
+'
+
+test_expect_success "wrapping a CARv1 export matches the CARv2 export" '
+  ipfs dag index --wrap welcome_docs.car > welcome_docs_wrapped.car &&
+  test_cmp welcome_docs_v2.car welcome_docs_wrapped.car
+'
+
+test_expect_success "the index of a CARv1 file is the one embedded in the CARv2 file" '
+  ipfs dag index welcome_docs.car > welcome_docs.idx &&
+  tail -c $(file_size welcome_docs.idx) welcome_docs_v2.car > welcome_docs_embedded.idx &&
+  test_cmp welcome_docs.idx welcome_docs_embedded.idx
+'
+
+test_expect_success "CARv2 import works" '
+  echo "Pinned root${tab}${HASH_WELCOME_DOCS}${tab}success" > carv2_import_expected &&
+  ipfs dag import welcome_docs_v2.car > carv2_import_actual &&
+  test_cmp carv2_import_expected carv2_import_actual
+'
+
+test_expect_success "blocks of a mounted CAR file can be read" '
+  IPFS_PATH="$(pwd)/.ipfs-mount" ipfs init --profile=test -e >/dev/null &&
+  IPFS_PATH="$(pwd)/.ipfs-mount" ipfs dag mount-car welcome_docs_v2.car > mount_actual &&
+  grep -q "^mounted $(pwd)/welcome_docs_v2.car: " mount_actual &&
+  IPFS_PATH="$(pwd)/.ipfs-mount" ipfs dag mounted-cars | grep -q "^$(pwd)/welcome_docs_v2.car${tab}" &&
+  IPFS_PATH="$(pwd)/.ipfs-mount" ipfs config Datastore.CarMounts | grep -q "\"$(pwd)/welcome_docs_v2.car\"" &&
+  IPFS_PATH="$(pwd)/.ipfs-mount" ipfs --offline cat /ipfs/$HASH_WELCOME_DOCS/readme > mounted_readme_actual &&
+  ipfs cat /ipfs/$HASH_WELCOME_DOCS/readme > mounted_readme_expected &&
+  test_cmp mounted_readme_expected mounted_readme_actual
+'
+
+test_expect_success "mounted blocks are gone once unmounted" '
+  IPFS_PATH="$(pwd)/.ipfs-mount" ipfs dag unmount-car welcome_docs_v2.car &&
+  test -z "$(IPFS_PATH="$(pwd)/.ipfs-mount" ipfs dag mounted-cars)" &&
+  test_expect_code 1 env IPFS_PATH="$(pwd)/.ipfs-mount" ipfs --offline cat /ipfs/$HASH_WELCOME_DOCS/readme
+'
+
+test_expect_success "mounting a missing CAR file fails" '
+  test_expect_code 1 env IPFS_PATH="$(pwd)/.ipfs-mount" ipfs dag mount-car missing.car
+'
+
+test_expect_success "a CAR file cannot be unmounted while its blocks are pinned" '
+  IPFS_PATH="$(pwd)/.ipfs-mount" ipfs dag mount-car welcome_docs_v2.car &&
+  IPFS_PATH="$(pwd)/.ipfs-mount" ipfs pin add $HASH_WELCOME_DOCS &&
+  test_expect_code 1 env IPFS_PATH="$(pwd)/.ipfs-mount" ipfs dag unmount-car welcome_docs_v2.car 2> unmount_pinned_actual &&
+  grep -q "the pin $HASH_WELCOME_DOCS has blocks that are only in it" unmount_pinned_actual
+'
+
+test_expect_success "pinned blocks outlive the mount once imported" '
+  IPFS_PATH="$(pwd)/.ipfs-mount" ipfs dag import --verify welcome_docs_v2.car > import_mounted_actual &&
+  grep -q "^Imported [1-9][0-9]* blocks, 0 were already present$" import_mounted_actual &&
+  IPFS_PATH="$(pwd)/.ipfs-mount" ipfs dag unmount-car welcome_docs_v2.car &&
+  IPFS_PATH="$(pwd)/.ipfs-mount" ipfs pin verify > pin_verify_actual &&
+  test_must_be_empty pin_verify_actual &&
+  IPFS_PATH="$(pwd)/.ipfs-mount" ipfs --offline cat /ipfs/$HASH_WELCOME_DOCS/readme > unmounted_readme_actual &&
+  test_cmp mounted_readme_expected unmounted_readme_actual
+'
+
+test_expect_success "verified import of an incomplete DAG lists the missing blocks" '
+  IPFS_PATH="$(pwd)/.ipfs-verify" ipfs init --profile=test -e >/dev/null &&
+  test_expect_code 1 env IPFS_PATH="$(pwd)/.ipfs-verify" ipfs dag import --verify welcome_docs_root.car > verify_root_actual &&
+  grep -q "^Pinned root${tab}${HASH_WELCOME_DOCS}${tab}FAILED: incomplete DAG: " verify_root_actual &&
+  ipfs refs $HASH_WELCOME_DOCS | sed "s/^/Missing block${tab}/" > verify_missing_expected &&
+  grep "^Missing block" verify_root_actual > verify_missing_actual &&
+  test_cmp_sorted verify_missing_expected verify_missing_actual
+'
+
+test_expect_success "verified import without pinning reports the root as incomplete" '
+  test_expect_code 1 env IPFS_PATH="$(pwd)/.ipfs-verify" ipfs dag import --verify --pin-roots=false welcome_docs_root.car > verify_nopin_actual &&
+  grep -q "^Root${tab}${HASH_WELCOME_DOCS}${tab}incomplete: " verify_nopin_actual
+'
+
+test_expect_success "import of a truncated CAR fails" '
+  head -c $(( $(file_size welcome_docs.car) - 100 )) welcome_docs.car > welcome_docs_truncated.car &&
+  test_expect_code 1 env IPFS_PATH="$(pwd)/.ipfs-verify" ipfs dag import welcome_docs_truncated.car 2> truncated_import_err &&
+  grep -q "import the file again to resume" truncated_import_err
+'
+
+test_expect_success "importing the whole CAR resumes the import" '
+  IPFS_PATH="$(pwd)/.ipfs-verify" ipfs dag import --verify welcome_docs.car > verify_resume_actual &&
+  grep -q "^Pinned root${tab}${HASH_WELCOME_DOCS}${tab}success$" verify_resume_actual &&
+  ! grep -q "^Missing block" verify_resume_actual &&
+  grep -q "^Imported [0-9]* blocks, [1-9][0-9]* were already present$" verify_resume_actual
+'
+
+
 cat >multiroot_import_json_expected <<EOE
 {"Root":{"Cid":{"/":"bafy2bzaceb55n7uxyfaelplulk3ev2xz7gnq6crncf3ahnvu46hqqmpucizcw"},"PinErrorMsg":""}}
 {"Root":{"Cid":{"/":"bafy2bzacebedrc4n2ac6cqdkhs7lmj5e4xiif3gu7nmoborihajxn3fav3vdq"},"PinErrorMsg":""}}
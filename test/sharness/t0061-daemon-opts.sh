@@ -57,4 +57,16 @@ test_expect_success 'output contains info about supernode dht option' '
   test_fsh cat daemon_output2
 '
 
+test_expect_success 'daemon should not start with gc opts without --enable-gc' '
+  test_must_fail ipfs daemon --lru-gc > daemon_output3 2>&1 &&
+  grep "only applies to the garbage collections enabled with --enable-gc" daemon_output3 &&
+  test_must_fail ipfs daemon --incremental-gc > daemon_output4 2>&1 &&
+  grep "only applies to the garbage collections enabled with --enable-gc" daemon_output4
+'
+
+test_expect_success 'daemon should not start with both --incremental-gc and --lru-gc' '
+  test_must_fail ipfs daemon --enable-gc --incremental-gc --lru-gc > daemon_output5 2>&1 &&
+  grep "cannot be used together" daemon_output5
+'
+
 test_done
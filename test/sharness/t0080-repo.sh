@@ -122,6 +122,52 @@ test_expect_success "'ipfs refs local' no longer shows file" '
   test_must_fail grep "$HASH" actual8
 '
 
+test_expect_success "'ipfs repo gc --incremental' removes unpinned file" '
+  echo "incremental gc" >incr &&
+  echo "incremental gc pinned" >incr_pinned &&
+  INCR_HASH=`ipfs add -q --pin=false incr` &&
+  INCR_PINNED_HASH=`ipfs add -q incr_pinned` &&
+  ipfs repo gc --incremental >actual_incr &&
+  grep "removed $INCR_HASH" actual_incr
+'
+
+test_expect_success "'ipfs repo gc --incremental' keeps pinned file" '
+  test_must_fail grep "removed $INCR_PINNED_HASH" actual_incr &&
+  ipfs block stat "$INCR_PINNED_HASH" &&
+  ipfs pin rm "$INCR_PINNED_HASH"
+'
+
+test_expect_success "'ipfs repo gc --dry-run' lists unpinned file" '
+  echo "dry run" >dryrun &&
+  DRY_HASH=`ipfs add -q --pin=false dryrun` &&
+  ipfs repo gc --dry-run >actual_dry &&
+  grep "would remove $DRY_HASH" actual_dry &&
+  grep "would free [0-9]* bytes (.*) from [0-9]* objects" actual_dry
+'
+
+test_expect_success "'ipfs repo gc --dry-run' doesn't remove file" '
+  ipfs block stat "$DRY_HASH"
+'
+
+test_expect_success "'ipfs repo gc --dry-run --incremental' fails" '
+  test_expect_code 1 ipfs repo gc --dry-run --incremental 2>err_dry &&
+  grep -q "cannot be used together" err_dry
+'
+
+test_expect_success "'ipfs repo gc --explain' shows the pin keeping a file" '
+  ipfs pin add --name=dry "$DRY_HASH" &&
+  echo "$DRY_HASH is kept by the recursive pin $DRY_HASH (dry)" >expected_explain &&
+  ipfs repo gc --explain="$DRY_HASH" >actual_explain &&
+  test_cmp expected_explain actual_explain
+'
+
+test_expect_success "'ipfs repo gc --explain' shows an unpinned file" '
+  ipfs pin rm "$DRY_HASH" &&
+  echo "$DRY_HASH would be removed" >expected_explain &&
+  ipfs repo gc --explain="$DRY_HASH" >actual_explain &&
+  test_cmp expected_explain actual_explain
+'
+
 test_expect_success "adding multiblock random file succeeds" '
   random 1000000 >multiblock &&
   MBLOCKHASH=`ipfs add -q multiblock`
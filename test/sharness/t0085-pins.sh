@@ -164,6 +164,119 @@ test_pin_progress() {
   '
 }
 
+test_pin_info() {
+  test_expect_success "'ipfs pin add --name --meta' succeeds" '
+    HASH_A=$(echo "pin info a" | ipfs add -q --pin=false) &&
+    HASH_B=$(echo "pin info b" | ipfs add -q --pin=false) &&
+    ipfs pin add --name=backup --meta=job=nightly --meta=team=infra $HASH_A &&
+    ipfs pin add --name=other --meta=job=weekly $HASH_B
+  '
+
+  test_expect_success "'ipfs pin ls' shows the names" '
+    ipfs pin ls --type=recursive > actual &&
+    grep "^$HASH_A recursive backup$" actual &&
+    grep "^$HASH_B recursive other$" actual
+  '
+
+  test_expect_success "'ipfs pin ls --name' only lists the pins with the name" '
+    echo "$HASH_A recursive backup" > expected &&
+    ipfs pin ls --name=backup > actual &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "'ipfs pin ls --meta' only lists the pins with all the metadata" '
+    ipfs pin ls --meta=job=nightly --meta=team=infra > actual &&
+    test_cmp expected actual &&
+    echo "$HASH_B recursive other" > expected_b &&
+    ipfs pin ls --stream --meta=job=weekly > actual &&
+    test_cmp expected_b actual &&
+    ipfs pin ls --meta=job=nightly --meta=team=other > actual &&
+    test_must_be_empty actual
+  '
+
+  test_expect_success "'ipfs pin ls' JSON output has the metadata" '
+    ipfs pin ls --name=backup --enc=json > actual &&
+    grep -q "\"Meta\":{\"job\":\"nightly\",\"team\":\"infra\"}" actual
+  '
+
+  test_expect_success "'ipfs pin ls --name' fails for a path pinned with another name" '
+    test_must_fail ipfs pin ls --name=backup $HASH_B 2> err &&
+    grep -q "is not pinned with the given name and metadata" err
+  '
+
+  test_expect_success "'ipfs pin update' moves the name to the new pin" '
+    HASH_C=$(echo "pin info c" | ipfs add -q --pin=false) &&
+    ipfs pin update $HASH_A $HASH_C &&
+    echo "$HASH_C recursive backup" > expected &&
+    ipfs pin ls --name=backup > actual &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "'ipfs pin rm' removes the name" '
+    ipfs pin rm $HASH_B $HASH_C &&
+    ipfs pin add $HASH_C &&
+    ipfs pin ls --type=recursive > actual &&
+    grep "^$HASH_C recursive$" actual &&
+    ipfs pin rm $HASH_C
+  '
+
+  test_expect_success "'ipfs pin add' rejects invalid metadata and expiry times" '
+    test_must_fail ipfs pin add --meta=job $HASH_A 2> err &&
+    grep -q "expected key=value" err &&
+    test_must_fail ipfs pin add --expires=tomorrow $HASH_A 2> err &&
+    grep -q "expected a duration" err &&
+    test_must_fail ipfs pin add --expires=2000-01-01T00:00:00Z $HASH_A 2> err &&
+    grep -q "the pin would already be expired" err
+  '
+}
+
+test_pin_queue() {
+  test_expect_success "'ipfs pin status' shows the pin queued before the daemon started as pinned" '
+    for i in 1 2 3 4 5; do
+      ipfs pin status $HASH_Q | grep -q pinned && break
+      sleep 1
+    done &&
+    echo "$HASH_Q pinned" > expected &&
+    ipfs pin status $HASH_Q > actual &&
+    test_cmp expected actual &&
+    ipfs pin ls --type=recursive $HASH_Q
+  '
+
+  test_expect_success "'ipfs pin add --background' of a missing object fails after all attempts" '
+    MISSING=bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy &&
+    ipfs pin add --background --name=missing -r=false $MISSING > actual &&
+    echo "queued $MISSING directly" > expected &&
+    test_cmp expected actual &&
+    for i in 1 2 3 4 5; do
+      ipfs pin status $MISSING | grep -q failed && break
+      sleep 1
+    done &&
+    ipfs pin status $MISSING > actual &&
+    grep -q "^$MISSING failed: attempt 2 failed: .*not found" actual
+  '
+
+  test_expect_success "'ipfs pin ls --status' lists the pins of the queue" '
+    echo "$MISSING direct failed missing" > expected &&
+    ipfs pin ls --status=failed > actual &&
+    test_cmp expected actual &&
+    ipfs pin ls --status=queued,pinning > actual &&
+    test_must_be_empty actual &&
+    ipfs pin ls --status=pinned,failed --name=missing > actual &&
+    test_cmp expected actual &&
+    test_must_fail ipfs pin ls --status=done 2> err &&
+    grep -q "invalid pin status" err
+  '
+
+  test_expect_success "'ipfs pin rm' removes failed pins from the queue" '
+    ipfs pin rm $MISSING &&
+    test_must_fail ipfs pin status $MISSING 2> err &&
+    grep -q "is not in the pin queue" err &&
+    ipfs pin rm $HASH_Q &&
+    ipfs pin status > actual &&
+    test_must_be_empty actual
+  '
+}
+
 test_init_ipfs
 
 test_pins '' '' ''
@@ -180,8 +293,48 @@ test_pin_dag --raw-leaves
 
 test_pin_progress
 
+test_pin_info
+
+test_expect_success "'ipfs pin add --expires' records the expiry time" '
+  HASH_E=$(echo "expiring pin" | ipfs add -q --pin=false) &&
+  ipfs pin add --name=expiring --expires=1s $HASH_E &&
+  ipfs pin ls --name=expiring --enc=json > actual &&
+  grep -q "\"Expires\":" actual &&
+  sleep 2
+'
+
+test_expect_success "'ipfs pin add --background' queues pins without the daemon" '
+  HASH_Q=$(echo "queued pin" | ipfs add -q --pin=false) &&
+  ipfs pin add --background $HASH_Q > actual &&
+  echo "queued $HASH_Q recursively" > expected &&
+  test_cmp expected actual &&
+  echo "$HASH_Q queued" > expected &&
+  ipfs pin status > actual &&
+  test_cmp expected actual &&
+  test_must_fail ipfs pin ls $HASH_Q
+'
+
+test_expect_success "'ipfs pin add --background' can't show progress" '
+  test_must_fail ipfs pin add --background --progress $HASH_Q 2> err &&
+  grep -q "cannot be used together" err
+'
+
+test_expect_success "set the limits of the pin queue" '
+  ipfs config --json Pinning.Queue "{\"Attempts\": 2, \"RetryDelay\": \"100ms\"}"
+'
+
 test_launch_ipfs_daemon --offline
 
+test_expect_success "the daemon removes expired pins" '
+  for i in 1 2 3 4 5; do
+    test_must_fail ipfs pin ls $HASH_E && break
+    sleep 1
+  done &&
+  test_must_fail ipfs pin ls $HASH_E &&
+  ipfs pin ls --name=expiring > actual &&
+  test_must_be_empty actual
+'
+
 test_pins '' '' ''
 test_pins --progress '' ''
 test_pins --progress --stream ''
@@ -196,6 +349,10 @@ test_pin_dag --raw-leaves
 
 test_pin_progress
 
+test_pin_info
+
+test_pin_queue
+
 test_kill_ipfs_daemon
 
 test_done